@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scopes granted to api keys and tokens
+const (
+	scopeTreesRead       = "trees:read"
+	scopeTreesWrite      = "trees:write"
+	scopeFavouritesWrite = "favourites:write"
+	// scopeFavouritesAdmin allows changing the favourites of other users
+	scopeFavouritesAdmin = "favourites:admin"
+	scopeWebhooksManage  = "webhooks:manage"
+)
+
+var knownScopes = []string{scopeTreesRead, scopeTreesWrite, scopeFavouritesWrite, scopeFavouritesAdmin,
+	scopeWebhooksManage}
+
+const (
+	// apiKeyHeader carries a static api key
+	apiKeyHeader = "X-API-Key"
+	// apiKeyHashPrefix starts the hashes of api keys in the config
+	apiKeyHashPrefix = "sha256:"
+	// clockSkew is the tolerance when checking the validity period of tokens
+	clockSkew = time.Minute
+	authRealm = "tree-spotter"
+)
+
+// principal is the authenticated caller of a request
+type principal struct {
+	// subject is the name of the api key or the subject of the token,
+	// empty for anonymous callers
+	subject string
+	scopes  map[string]bool
+}
+
+func newPrincipal(subject, scopes string) principal {
+	p := principal{subject: subject, scopes: map[string]bool{}}
+	for _, s := range strings.Fields(scopes) {
+		p.scopes[s] = true
+	}
+	return p
+}
+
+type principalKey struct{}
+
+// jwk is a key of a JSON Web Key Set. Symmetric keys (kty oct) verify HS256
+// and RSA keys RS256 signatures.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+
+	secret []byte
+	public *rsa.PublicKey
+}
+
+// authenticator verifies the credentials of requests
+type authenticator struct {
+	// apiKeys maps the sha256 hashes of the api keys to their principals
+	apiKeys   map[string]principal
+	keys      []jwk
+	issuer    string
+	audience  string
+	anonymous principal
+	now       func() time.Time
+}
+
+// newAuthenticator returns the authenticator configured by cfg or nil if
+// neither api keys nor a JWKS file are configured
+func newAuthenticator(cfg authConfig) (*authenticator, error) {
+	if len(cfg.APIKeys) == 0 && cfg.JWKSFile == "" {
+		return nil, nil
+	}
+	a := &authenticator{
+		apiKeys:   map[string]principal{},
+		issuer:    cfg.Issuer,
+		audience:  cfg.Audience,
+		anonymous: newPrincipal("", cfg.AnonymousScopes),
+		now:       time.Now,
+	}
+	for _, k := range cfg.APIKeys {
+		a.apiKeys[strings.TrimPrefix(k.Hash, apiKeyHashPrefix)] = newPrincipal(k.Name, k.Scopes)
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+	}
+	return a, nil
+}
+
+func loadJWKS(path string) ([]jwk, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS file: %s", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("decode JWKS file \"%s\": %s", path, err)
+	}
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		switch k.Kty {
+		case "oct":
+			if k.secret, err = base64.RawURLEncoding.DecodeString(k.K); err != nil || len(k.secret) == 0 {
+				return nil, fmt.Errorf("JWKS file \"%s\": key \"%s\" has an invalid k", path, k.Kid)
+			}
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("JWKS file \"%s\": key \"%s\" has an invalid n or e", path, k.Kid)
+			}
+			k.public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		default:
+			return nil, fmt.Errorf("JWKS file \"%s\": key \"%s\" has unsupported type \"%s\"", path, k.Kid, k.Kty)
+		}
+	}
+	return set.Keys, nil
+}
+
+// errNoCredentials is returned for requests that carry no credentials
+var errNoCredentials = errors.New("no credentials")
+
+// authenticate returns the principal identified by the api key or bearer
+// token in the headers of a request
+func (a *authenticator) authenticate(h http.Header) (principal, error) {
+	if key := h.Get(apiKeyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		hash := hex.EncodeToString(sum[:])
+		for h, p := range a.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+				return p, nil
+			}
+		}
+		return principal{}, newMessage("the api key is invalid")
+	}
+	authz := h.Get("Authorization")
+	if authz == "" {
+		return a.anonymous, errNoCredentials
+	}
+	parts := strings.SplitN(authz, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return principal{}, newMessage("the Authorization header must hold a bearer token")
+	}
+	return a.verifyToken(strings.TrimSpace(parts[1]))
+}
+
+// tokenClaims are the claims of a JWT the server evaluates
+type tokenClaims struct {
+	Subject  string          `json:"sub"`
+	Issuer   string          `json:"iss"`
+	Audience json.RawMessage `json:"aud"`
+	// ExpiresAt and NotBefore are NumericDates, which may have fractions
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	// Scope is a space separated list of scopes, Scp an alternative
+	// representation as a list used by some issuers
+	Scope string   `json:"scope"`
+	Scp   []string `json:"scp"`
+}
+
+// verifyToken verifies the signature and claims of a JWT
+func (a *authenticator) verifyToken(token string) (principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return principal{}, newMessage("the token is malformed")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return principal{}, newMessage("the token is malformed")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return principal{}, newMessage("the token is malformed")
+	}
+	if !a.verifySignature(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), sig) {
+		return principal{}, newMessage("the token signature is invalid")
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return principal{}, newMessage("the token is malformed")
+	}
+	now := a.now()
+	switch {
+	case claims.ExpiresAt == nil:
+		return principal{}, newMessage("the token has no expiry")
+	case now.After(numericDate(*claims.ExpiresAt).Add(clockSkew)):
+		return principal{}, newMessage("the token has expired")
+	case claims.NotBefore != nil && now.Add(clockSkew).Before(numericDate(*claims.NotBefore)):
+		return principal{}, newMessage("the token is not valid yet")
+	case claims.Subject == "":
+		return principal{}, newMessage("the token has no subject")
+	case a.issuer != "" && claims.Issuer != a.issuer:
+		return principal{}, newMessage("the token has a different issuer")
+	case a.audience != "" && !hasAudience(claims.Audience, a.audience):
+		return principal{}, newMessage("the token is meant for a different audience")
+	}
+	return newPrincipal(claims.Subject, claims.Scope+" "+strings.Join(claims.Scp, " ")), nil
+}
+
+// numericDate returns the time of a NumericDate, the seconds since the epoch
+func numericDate(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+func (a *authenticator) verifySignature(alg, kid string, signed, sig []byte) bool {
+	for _, k := range a.keys {
+		if (kid != "" && k.Kid != kid) || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		switch {
+		case alg == "HS256" && k.secret != nil:
+			mac := hmac.New(sha256.New, k.secret)
+			mac.Write(signed)
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return true
+			}
+		case alg == "RS256" && k.public != nil:
+			sum := sha256.Sum256(signed)
+			if rsa.VerifyPKCS1v15(k.public, crypto.SHA256, sum[:], sig) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// hasAudience reports whether the aud claim, a string or a list of strings,
+// contains audience
+func hasAudience(aud json.RawMessage, audience string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == audience
+	}
+	var many []string
+	if json.Unmarshal(aud, &many) == nil {
+		for _, a := range many {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authenticate identifies the caller of every request. Requests with invalid
+// credentials are rejected, requests without credentials are served with the
+// anonymous scopes.
+func authenticate(a *authenticator) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.authenticate(r.Header)
+			if err != nil && err != errNoCredentials {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s", error="invalid_token"`, authRealm))
+				writeError(w, r, http.StatusUnauthorized, codeUnauthorized, messageOf(err))
+				return
+			}
+			ctx := context.WithValue(r.Context(), principalKey{}, p)
+			if p.subject != "" {
+				ctx = withUser(ctx, p.subject)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// scoped only passes requests to h whose caller was granted scope. It
+// responds with 401 to anonymous callers and with 403 to authenticated ones.
+// All requests pass if authentication is not configured.
+func (s *server) scoped(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			h(w, r)
+			return
+		}
+		p, ok := r.Context().Value(principalKey{}).(principal)
+		switch {
+		case ok && p.scopes[scope]:
+			h(w, r)
+		case !ok || p.subject == "":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, authRealm))
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, newMessage("authentication is required"))
+		default:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s", error="insufficient_scope", scope="%s"`, authRealm, scope))
+			writeError(w, r, http.StatusForbidden, codeForbidden,
+				newMessage("the scope %s is required", scope))
+		}
+	}
+}
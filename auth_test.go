@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+	testRSAKey     *rsa.PrivateKey
+)
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	if testRSAKey == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRSAKey = key
+	}
+	return testRSAKey
+}
+
+// signToken returns a JWT with the given claims signed with the test keys
+func signToken(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, testHMACSecret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		sum := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey(t), crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func claims(sub, scope string) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   sub,
+		"iss":   "https://auth.example.com",
+		"aud":   []string{"tree-spotter", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": scope,
+	}
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return apiKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+func newAuthTestServer(t *testing.T, dir string) http.Handler {
+	b64 := base64.RawURLEncoding.EncodeToString
+	pub := rsaKey(t).PublicKey
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"oct","kid":"hs","alg":"HS256","k":"%s"},
+		{"kty":"RSA","kid":"rs","alg":"RS256","n":"%s","e":"%s"}]}`,
+		b64(testHMACSecret), b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes()))
+	file := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(file, []byte(jwks), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestServer(defaultTrees, func(cfg *config) {
+		cfg.Auth.JWKSFile = file
+		cfg.Auth.Issuer = "https://auth.example.com"
+		cfg.Auth.Audience = "tree-spotter"
+		cfg.Auth.APIKeys = []apiKeyConfig{
+			{Name: "ci", Hash: hashAPIKey("secret-ci-key"), Scopes: "trees:read trees:write"},
+		}
+	}).handler()
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func TestAuthentication(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := newAuthTestServer(t, dir)
+	rowan := `{"id":"rowan","species":"Rowan","scientificName":"Sorbus aucuparia","family":"Rosaceae"}`
+
+	expired := claims("kim", "trees:write")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	fractional := claims("kim", "trees:read")
+	fractional["exp"] = float64(time.Now().Add(time.Hour).UnixNano()) / 1e9
+	fractional["nbf"] = float64(time.Now().Add(-time.Minute).UnixNano()) / 1e9
+	fractionalExpired := claims("kim", "trees:read")
+	fractionalExpired["exp"] = float64(time.Now().Add(-time.Hour).UnixNano()) / 1e9
+	notYetValid := claims("kim", "trees:read")
+	notYetValid["nbf"] = float64(time.Now().Add(time.Hour).UnixNano()) / 1e9
+	wrongAudience := claims("kim", "trees:write")
+	wrongAudience["aud"] = "other"
+	wrongIssuer := claims("kim", "trees:write")
+	wrongIssuer["iss"] = "https://evil.example.com"
+	valid := signToken(t, "HS256", "hs", claims("kim", "trees:write"))
+	tampered := strings.Split(valid, ".")
+	tampered[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999,"scope":"trees:write"}`))
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"anonymous read", http.MethodGet, "/trees", nil, http.StatusOK},
+		{"anonymous write", http.MethodPut, "/trees/rowan", nil, http.StatusUnauthorized},
+		{"health checks stay open", http.MethodGet, "/livez", nil, http.StatusOK},
+		{"api key", http.MethodPut, "/trees/rowan", map[string]string{apiKeyHeader: "secret-ci-key"}, http.StatusCreated},
+		{"unknown api key", http.MethodGet, "/trees", map[string]string{apiKeyHeader: "guess"}, http.StatusUnauthorized},
+		{"HS256", http.MethodPut, "/trees/rowan", bearer(valid), http.StatusOK},
+		{"RS256", http.MethodPut, "/trees/rowan", bearer(signToken(t, "RS256", "rs", claims("kim", "trees:write"))), http.StatusOK},
+		{"RS256 without kid", http.MethodPut, "/trees/rowan", bearer(signToken(t, "RS256", "", claims("kim", "trees:write"))), http.StatusOK},
+		{"scp claim", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "hs",
+			map[string]interface{}{"sub": "kim", "aud": "tree-spotter", "iss": "https://auth.example.com",
+				"exp": time.Now().Add(time.Hour).Unix(), "scp": []string{"trees:read"}})), http.StatusOK},
+		{"insufficient scope", http.MethodPut, "/trees/rowan", bearer(signToken(t, "RS256", "rs", claims("kim", "trees:read"))), http.StatusForbidden},
+		{"expired", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "hs", expired)), http.StatusUnauthorized},
+		{"fractional dates", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "hs", fractional)), http.StatusOK},
+		{"fractional expiry", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "hs", fractionalExpired)), http.StatusUnauthorized},
+		{"not yet valid", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "hs", notYetValid)), http.StatusUnauthorized},
+		{"wrong audience", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "hs", wrongAudience)), http.StatusUnauthorized},
+		{"wrong issuer", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "hs", wrongIssuer)), http.StatusUnauthorized},
+		{"unknown kid", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "other", claims("kim", "trees:read"))), http.StatusUnauthorized},
+		{"alg none", http.MethodGet, "/trees", bearer(signToken(t, "none", "hs", claims("kim", "trees:read"))), http.StatusUnauthorized},
+		{"HS256 with the RSA key", http.MethodGet, "/trees", bearer(signToken(t, "HS256", "rs", claims("kim", "trees:read"))), http.StatusUnauthorized},
+		{"tampered", http.MethodGet, "/trees", bearer(strings.Join(tampered, ".")), http.StatusUnauthorized},
+		{"malformed", http.MethodGet, "/trees", bearer("not-a-token"), http.StatusUnauthorized},
+		{"basic auth", http.MethodGet, "/trees", map[string]string{"Authorization": "Basic a2ltOnNlY3JldA=="}, http.StatusUnauthorized},
+	} {
+		rec := record(h, tc.method, tc.path, rowan, withHeaders(tc.headers))
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.status, rec.Code, rec.Body.String())
+			continue
+		}
+		var e errorResponse
+		switch tc.status {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			if err := json.NewDecoder(rec.Body).Decode(&e); err != nil || e.Error.Code == "" {
+				t.Errorf("%s: expected a json error, got %v", tc.name, err)
+			}
+			if !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer ") {
+				t.Errorf("%s: expected a WWW-Authenticate challenge, got %q", tc.name, rec.Header().Get("WWW-Authenticate"))
+			}
+		}
+		if tc.status == http.StatusForbidden && !strings.Contains(rec.Header().Get("WWW-Authenticate"), `scope="trees:write"`) {
+			t.Errorf("%s: expected the missing scope in the challenge, got %q", tc.name, rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestTokenSubjectIsTheUser(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := newAuthTestServer(t, dir)
+	token := bearer(signToken(t, "RS256", "rs", claims("kim", "trees:read favourites:write")))
+
+	rec := record(h, http.MethodPut, "/users/kim/favourite-tree", `{"treeId":"ginkgo"}`, withHeaders(token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	// the subject of the token takes precedence over X-User-ID
+	token[userHeader] = "jan"
+	rec = record(h, http.MethodGet, "/tree", "", withHeaders(token))
+	if !strings.Contains(rec.Body.String(), "Ginkgo") {
+		t.Fatalf("expected the favourite of the token subject, got %s", rec.Body.String())
+	}
+}
+
+func TestAuthConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		auth     authConfig
+		expected string
+	}{
+		{authConfig{APIKeys: []apiKeyConfig{{Name: "ci", Hash: "plain-text-key"}}}, "auth.apiKeys[0].hash"},
+		{authConfig{APIKeys: []apiKeyConfig{{Hash: hashAPIKey("a")}}}, "auth.apiKeys[0].name"},
+		{authConfig{APIKeys: []apiKeyConfig{{Name: "ci", Hash: hashAPIKey("a"), Scopes: "trees:delete"}}},
+			"unknown scope \"trees:delete\""},
+		{authConfig{AnonymousScopes: "everything"}, "auth.anonymousScopes"},
+	} {
+		cfg := defaultConfig()
+		cfg.Auth = tc.auth
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%+v: expected an error containing %q, got %v", tc.auth, tc.expected, err)
+		}
+	}
+}
+
+func TestFavouritesOfOtherUsers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := newAuthTestServer(t, dir)
+
+	alice := bearer(signToken(t, "RS256", "rs", claims("alice", "trees:read favourites:write")))
+	rec := record(h, http.MethodPut, "/users/bob/favourite-tree", `{"treeId":"ginkgo"}`, withHeaders(alice))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected alice not to change the favourite of bob, got %d", rec.Code)
+	}
+	if rec := record(h, http.MethodGet, "/users/bob/favourite-tree", "", withHeaders(alice)); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the favourite of bob to be unset, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	admin := bearer(signToken(t, "RS256", "rs", claims("support", "trees:read favourites:write favourites:admin")))
+	if rec := record(h, http.MethodPut, "/users/bob/favourite-tree", `{"treeId":"ginkgo"}`, withHeaders(admin)); rec.Code != http.StatusOK {
+		t.Errorf("expected the admin scope to allow it, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
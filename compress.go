@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Content codings the server compresses responses with, in order of
+// preference
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// compressibleTypes lists the media types, or prefixes of them, that are
+// worth compressing
+var compressibleTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/yaml",
+	"application/x-yaml",
+	"text/",
+}
+
+func compressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, t := range compressibleTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding selects the content coding from an Accept-Encoding
+// header. It returns "" if the response should not be compressed.
+func negotiateEncoding(header string) string {
+	// codings have the same syntax as media ranges
+	ranges := parseAccept(header)
+	refused := map[string]bool{}
+	for _, cr := range ranges {
+		if cr.q == 0 {
+			refused[cr.mediaType] = true
+		}
+	}
+	for _, cr := range ranges {
+		if cr.q == 0 {
+			break
+		}
+		switch cr.mediaType {
+		case encodingGzip, "x-gzip":
+			return encodingGzip
+		case encodingDeflate:
+			return encodingDeflate
+		case "*":
+			for _, e := range []string{encodingGzip, encodingDeflate} {
+				if !refused[e] {
+					return e
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// compressor is a pooled writer of one content coding
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compress compresses responses of at least minSize bytes with the coding
+// negotiated with the client. Responses are streamed: only the first minSize
+// bytes are buffered to decide whether compression is worthwhile.
+func compress(minSize, level int) middleware {
+	pools := map[string]*sync.Pool{
+		encodingGzip: {New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, level)
+			return w
+		}},
+		encodingDeflate: {New: func() interface{} {
+			w, _ := zlib.NewWriterLevel(nil, level)
+			return w
+		}},
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				pool:           pools[encoding],
+				minSize:        minSize,
+				head:           r.Method == http.MethodHead,
+				status:         http.StatusOK,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter buffers the start of a response until it knows whether to
+// compress it and then streams the rest
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	minSize  int
+	// head responses get the headers of the GET response without a body
+	head bool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	hijacked    bool
+	buf         bytes.Buffer
+	size        int
+	c           compressor
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	if w.decided {
+		if w.c != nil {
+			return w.c.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf.Write(b)
+	if w.buf.Len() >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// countBody takes note of the size of the body of a HEAD response, which the
+// router discards, so that it gets the headers of the GET response
+func (w *compressWriter) countBody(n int) {
+	w.wroteHeader = true
+	w.size += n
+	if !w.decided && w.size >= w.minSize {
+		w.decide(true)
+	}
+}
+
+// encodedETag returns the entity tag of a response compressed with encoding.
+// Compressed responses differ from the uncompressed ones, so they get a tag
+// of their own, e.g. "…-gzip". stripEncoding reverses it.
+func encodedETag(tag, encoding string) string {
+	if !strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+	return tag[:len(tag)-1] + "-" + encoding + `"`
+}
+
+// stripEncoding returns the entity tag of the uncompressed response of tag
+func stripEncoding(tag string) string {
+	for _, e := range []string{encodingGzip, encodingDeflate} {
+		if strings.HasSuffix(tag, "-"+e+`"`) {
+			return tag[:len(tag)-len(e)-2] + `"`
+		}
+	}
+	return tag
+}
+
+// decide writes the header and the buffered start of the response. The
+// response is compressed if it is large, i.e. reached the size threshold, and
+// its content type is compressible.
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && w.buf.Len() > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	bodyless := w.status < http.StatusOK || w.status == http.StatusNoContent ||
+		w.status == http.StatusNotModified
+	if !bodyless && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		addVary(h, "Accept-Encoding")
+		if large && w.encoding != "" {
+			h.Set("Content-Encoding", w.encoding)
+			h.Del("Content-Length")
+			if tag := h.Get("ETag"); tag != "" {
+				h.Set("ETag", encodedETag(tag, w.encoding))
+			}
+			if !w.head {
+				w.c = w.pool.Get().(compressor)
+				w.c.Reset(w.ResponseWriter)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.c != nil {
+		_, err = w.c.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// Flush sends everything written so far to the client. Responses flushed
+// before reaching the size threshold are treated as streams and compressed
+// regardless of their size.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.c != nil {
+		w.c.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer does not support hijacking")
+	}
+	w.hijacked = true
+	return h.Hijack()
+}
+
+func (w *compressWriter) close() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		if !w.wroteHeader {
+			return
+		}
+		w.decide(false)
+	}
+	if w.c != nil {
+		w.c.Close()
+		w.pool.Put(w.c)
+		w.c = nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip":                     "gzip",
+		"x-gzip":                   "gzip",
+		"deflate, gzip;q=0.5":      "deflate",
+		"br, gzip":                 "gzip",
+		"*":                        "gzip",
+		"gzip;q=0, *":              "deflate",
+		"gzip;q=0, deflate;q=0, *": "",
+	} {
+		if got := negotiateEncoding(header); got != expected {
+			t.Errorf("%q: expected %q, got %q", header, expected, got)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+	plain := record(h, http.MethodGet, "/trees", "")
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("expected an uncompressed response without Accept-Encoding")
+	}
+	if plain.Body.Len() < defaultConfig().Compression.MinSize {
+		t.Fatalf("the tree list is too small to test compression: %d bytes", plain.Body.Len())
+	}
+
+	for encoding, reader := range map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+	} {
+		rec := record(h, http.MethodGet, "/trees", "", withHeaders(map[string]string{"Accept-Encoding": encoding}))
+		if rec.Header().Get("Content-Encoding") != encoding {
+			t.Fatalf("%s: expected Content-Encoding %s, got %v", encoding, encoding, rec.Header())
+		}
+		if !strings.Contains(strings.Join(rec.Header()["Vary"], ","), "Accept-Encoding") {
+			t.Errorf("%s: expected Vary: Accept-Encoding, got %v", encoding, rec.Header()["Vary"])
+		}
+		if rec.Body.Len() >= plain.Body.Len() {
+			t.Errorf("%s: expected a smaller body, got %d bytes for %d", encoding, rec.Body.Len(), plain.Body.Len())
+		}
+		r, err := reader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != plain.Body.String() {
+			t.Errorf("%s: the decompressed body differs from the plain one", encoding)
+		}
+	}
+}
+
+func TestCompressionSkipsSmallAndBodylessResponses(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+	gz := map[string]string{"Accept-Encoding": "gzip"}
+
+	rec := record(h, http.MethodGet, "/tree", "", withHeaders(gz))
+	if rec.Header().Get("Content-Encoding") != "" || !strings.Contains(rec.Body.String(), "Sequoia") {
+		t.Fatalf("expected a small uncompressed response, got %v %q", rec.Header(), rec.Body.String())
+	}
+	if !strings.Contains(strings.Join(rec.Header()["Vary"], ","), "Accept-Encoding") {
+		t.Errorf("expected Vary: Accept-Encoding on a compressible response, got %v", rec.Header()["Vary"])
+	}
+
+	tag := record(h, http.MethodGet, "/trees", "").Header().Get("ETag")
+	rec = record(h, http.MethodGet, "/trees", "", withHeaders(map[string]string{"Accept-Encoding": "gzip", "If-None-Match": tag}))
+	if rec.Code != http.StatusNotModified || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Fatalf("expected an empty 304, got %d %v", rec.Code, rec.Header())
+	}
+
+	big := strings.Repeat("x", 4096)
+	rec = record(compress(10, 6)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, big)
+	})), http.MethodGet, "/", "", withHeaders(gz))
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != big {
+		t.Fatalf("expected an incompressible content type to be sent as is, got %v", rec.Header())
+	}
+}
+
+func TestCompressionStreams(t *testing.T) {
+	chunk := strings.Repeat("tree ", 100)
+	rec := httptest.NewRecorder()
+	h := compress(1<<20, 6)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for i := 0; i < 3; i++ {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+			if rec.Body.Len() == 0 {
+				t.Fatalf("expected chunk %d to reach the client on flush", i)
+			}
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a flushed stream to be compressed, got %v", rec.Header())
+	}
+	r, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != strings.Repeat(chunk, 3) {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestCompressedETags(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+	gzipped := map[string]string{"Accept-Encoding": "gzip"}
+	plain := record(h, http.MethodGet, "/trees", "").Header().Get("ETag")
+	get := record(h, http.MethodGet, "/trees", "", withHeaders(gzipped))
+	tag := get.Header().Get("ETag")
+	if tag != plain[:len(plain)-1]+`-gzip"` {
+		t.Fatalf("expected the compressed response to have a tag of its own, got %s for %s", tag, plain)
+	}
+
+	head := record(h, http.MethodHead, "/trees", "", withHeaders(gzipped))
+	if head.Header().Get("Content-Encoding") != "gzip" || head.Header().Get("ETag") != tag || head.Body.Len() != 0 {
+		t.Errorf("expected HEAD to get the headers of GET, got %v", head.Header())
+	}
+
+	for _, inm := range []string{tag, plain} {
+		rec := record(h, http.MethodGet, "/trees", "", withHeaders(map[string]string{"Accept-Encoding": "gzip", "If-None-Match": inm}))
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("expected %s to match, got %d", inm, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// etag returns the strong entity tag of a response body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// versioned is implemented by resources that can be written conditionally.
+// The version identifies the stored state of the resource independent of
+// the format and language it is represented in.
+type versioned interface {
+	version() string
+}
+
+func (t Tree) version() string {
+	b, _ := json.Marshal(t)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (l treeList) version() string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(l)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// versionTag returns the entity tag of v represented as contentType in the
+// language lang. A representation of a versioned resource is determined by
+// its version, format and language, so its tag is known before it is encoded:
+// "<version>.<hash of the format and language>". Writes can be conditioned
+// on any representation and large responses can be streamed.
+func versionTag(v versioned, contentType string, lang *language) string {
+	sum := sha256.Sum256([]byte(contentType + " " + lang.tag))
+	return `"` + v.version() + "." + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// versionOf returns the version an entity tag starts with, as a tag
+func versionOf(tag string) string {
+	tag = stripEncoding(tag)
+	if i := strings.Index(tag, "."); strings.HasPrefix(tag, `"`) && i > 0 {
+		return tag[:i] + `"`
+	}
+	return tag
+}
+
+// matchVersion reports whether an If-Match or If-None-Match header lists a
+// tag of a representation of the given version
+func matchVersion(header, version string, weak bool) bool {
+	candidates := strings.Split(header, ",")
+	for i, c := range candidates {
+		c = strings.TrimSpace(c)
+		if strings.HasPrefix(c, "W/") {
+			candidates[i] = "W/" + versionOf(c[2:])
+		} else {
+			candidates[i] = versionOf(c)
+		}
+	}
+	return matchETag(strings.Join(candidates, ","), `"`+version+`"`, weak)
+}
+
+// matchETag reports whether an If-Match or If-None-Match header lists tag.
+// Weak comparison ignores the W/ prefix, strong comparison never matches
+// weak tags. The tags of compressed responses match the uncompressed ones.
+func matchETag(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = stripEncoding(strings.TrimSpace(candidate))
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if tag != "" && candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// modificationTime returns the time to record as the modification time of a
+// tree. It has the precision of the Last-Modified header.
+func modificationTime() *time.Time {
+	t := time.Now().UTC().Truncate(time.Second)
+	return &t
+}
+
+// setLastModified sets the Last-Modified header evaluated by notModified
+func setLastModified(w http.ResponseWriter, t time.Time) {
+	w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// lastModified returns the time of the last change to t. Trees that have not
+// changed since seeding are as old as the store.
+func lastModified(t Tree, store TreeStore) time.Time {
+	if t.Modified != nil {
+		return *t.Modified
+	}
+	return store.LastModified()
+}
+
+// notModified reports whether a GET or HEAD request can be answered with 304
+// given the ETag and Last-Modified headers of the response
+func notModified(r *http.Request, h http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	// If-Modified-Since is only evaluated without If-None-Match
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return matchETag(inm, h.Get("ETag"), true)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// preconditionsHold evaluates the If-Match and If-None-Match headers of a
+// write against the current state of the tree with the given id. If a
+// precondition fails a 412 response is written and false is returned. The
+// caller must hold s.writes.
+func (s *server) preconditionsHold(w http.ResponseWriter, r *http.Request, id string) bool {
+	im, inm := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if im == "" && inm == "" {
+		return true
+	}
+	// the tags are compared by the version of the tree, as the client may
+	// have read it in another format or language than it writes in
+	var version string
+	t, err := s.store(r.Context()).Get(id)
+	switch {
+	case err == nil:
+		version = t.version()
+	case err != errTreeNotFound:
+		writeStoreError(w, r, err, id)
+		return false
+	}
+	exists := err == nil
+	if (im != "" && (!exists || !matchVersion(im, version, false))) ||
+		(inm != "" && exists && matchVersion(inm, version, true)) {
+		writeError(w, r, http.StatusPreconditionFailed, codePreconditionFailed,
+			newMessage("the precondition on tree \"%s\" failed", id))
+		return false
+	}
+	return true
+}
+
+// cacheable sets the configured Cache-Control header on responses of h
+func (s *server) cacheable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cacheControl != "" {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		h(w, r)
+	}
+}
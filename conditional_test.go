@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConditionalGet(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	for _, path := range []string{"/tree", "/trees", "/trees/sequoia", "/trees/search?q=oak"} {
+		rec := record(h, http.MethodGet, path, "")
+		tag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+		if rec.Code != http.StatusOK || tag == "" || modified == "" {
+			t.Fatalf("%s: expected 200 with ETag and Last-Modified, got %d %v", path, rec.Code, rec.Header())
+		}
+		if rec.Header().Get("Cache-Control") != "no-cache" {
+			t.Errorf("%s: expected Cache-Control no-cache, got %q", path, rec.Header().Get("Cache-Control"))
+		}
+
+		for _, headers := range []map[string]string{
+			{"If-None-Match": tag},
+			{"If-None-Match": `"other", W/` + tag},
+			{"If-None-Match": "*"},
+			{"If-Modified-Since": modified},
+		} {
+			rec := record(h, http.MethodGet, path, "", withHeaders(headers))
+			if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+				t.Errorf("%s with %v: expected 304 without body, got %d", path, headers, rec.Code)
+			}
+			if rec.Header().Get("ETag") != tag {
+				t.Errorf("%s with %v: expected ETag %s, got %q", path, headers, tag, rec.Header().Get("ETag"))
+			}
+		}
+
+		past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		for _, headers := range []map[string]string{
+			{"If-None-Match": `"other"`},
+			{"If-None-Match": `"other"`, "If-Modified-Since": modified},
+			{"If-Modified-Since": past},
+			{"If-None-Match": tag, "Accept": "application/xml"},
+		} {
+			if rec := record(h, http.MethodGet, path, "", withHeaders(headers)); rec.Code != http.StatusOK {
+				t.Errorf("%s with %v: expected 200, got %d", path, headers, rec.Code)
+			}
+		}
+	}
+}
+
+func TestETagChangesWithContent(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	before := record(h, http.MethodGet, "/trees", "").Header().Get("ETag")
+	if rec := record(h, http.MethodDelete, "/trees/baobab", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	rec := record(h, http.MethodGet, "/trees", "", withHeaders(map[string]string{"If-None-Match": before}))
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == before {
+		t.Fatalf("expected a new ETag after a change, got %d %s", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+	oak := record(h, http.MethodGet, "/trees/english-oak", "")
+	tag := oak.Header().Get("ETag")
+
+	rec := record(h, http.MethodPatch, "/trees/english-oak", `{"lifespanYears":900}`, withHeaders(map[string]string{"If-Match": `"stale"`}))
+	if rec.Code != http.StatusPreconditionFailed || !strings.Contains(rec.Body.String(), codePreconditionFailed) {
+		t.Fatalf("expected 412, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = record(h, http.MethodPatch, "/trees/english-oak", `{"lifespanYears":900}`, withHeaders(map[string]string{"If-Match": tag}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	newTag := rec.Header().Get("ETag")
+	if newTag == "" || newTag == tag {
+		t.Fatalf("expected a new ETag, got %q", newTag)
+	}
+	if !strings.Contains(rec.Body.String(), `"lastModified"`) || rec.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected the modification time, got %v %s", rec.Header(), rec.Body.String())
+	}
+	if got := record(h, http.MethodGet, "/trees/english-oak", "").Header().Get("ETag"); got != newTag {
+		t.Fatalf("expected the ETag of the write response %s, got %s", newTag, got)
+	}
+
+	// the first writer wins, the second one has a stale ETag
+	body := strings.Replace(oak.Body.String(), `"lifespanYears":1000`, `"lifespanYears":1100`, 1)
+	rec = record(h, http.MethodPut, "/trees/english-oak", body, withHeaders(map[string]string{"If-Match": tag}))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a lost update, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = record(h, http.MethodDelete, "/trees/english-oak", "", withHeaders(map[string]string{"If-Match": tag}))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d", rec.Code)
+	}
+	rec = record(h, http.MethodDelete, "/trees/english-oak", "", withHeaders(map[string]string{"If-Match": newTag}))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+
+	// the tags of every format and language match the tree
+	xml := record(h, http.MethodGet, "/trees/sequoia", "", withHeaders(map[string]string{"Accept": "application/xml", "Accept-Language": "de"}))
+	rec = record(h, http.MethodPatch, "/trees/sequoia", `{"lifespanYears":3500}`, withHeaders(map[string]string{"If-Match": xml.Header().Get("ETag")}))
+	if xml.Code != http.StatusOK || rec.Code != http.StatusOK {
+		t.Fatalf("expected the tag of the xml representation to match, got %d %d: %s", xml.Code, rec.Code, rec.Body)
+	}
+
+	// If-Match requires the tree to exist, If-None-Match: * requires it not to
+	rec = record(h, http.MethodPut, "/trees/english-oak", body, withHeaders(map[string]string{"If-Match": "*"}))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d", rec.Code)
+	}
+	rec = record(h, http.MethodPut, "/trees/english-oak", body, withHeaders(map[string]string{"If-None-Match": "*"}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = record(h, http.MethodPut, "/trees/english-oak", body, withHeaders(map[string]string{"If-None-Match": "*"}))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d", rec.Code)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	cacheControl := "public, max-age=60"
+	h := newTestServer(defaultTrees, func(cfg *config) { cfg.CacheControl = cacheControl }).routes()
+
+	if got := record(h, http.MethodGet, "/trees", "").Header().Get("Cache-Control"); got != cacheControl {
+		t.Fatalf("expected Cache-Control %q, got %q", cacheControl, got)
+	}
+	if got := record(h, http.MethodGet, "/trees/larch", "").Header().Get("Cache-Control"); got != "no-store" {
+		t.Fatalf("expected errors not to be cached, got %q", got)
+	}
+	if got := record(h, http.MethodGet, "/livez", "").Header().Get("Cache-Control"); got != "" {
+		t.Fatalf("expected no Cache-Control on health checks, got %q", got)
+	}
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is prepended to the name of every environment variable the
+// server reads its configuration from
+const envPrefix = "TREE_SPOTTER_"
+
+// config holds all settings of the server. Settings are taken from, in order
+// of precedence, command line flags, environment variables, a yaml or json
+// config file and the defaults.
+type config struct {
+	Port int `yaml:"port"`
+	// GRPCPort is the port of the gRPC api, 0 disables it
+	GRPCPort     int           `yaml:"grpcPort"`
+	ReadTimeout  duration      `yaml:"readTimeout"`
+	WriteTimeout duration      `yaml:"writeTimeout"`
+	DefaultTree  string        `yaml:"defaultTree"`
+	Storage      storageConfig `yaml:"storage"`
+
+	// DrainPeriod is how long the server keeps serving after SIGTERM while
+	// reporting that it is not ready
+	DrainPeriod duration `yaml:"drainPeriod"`
+	// ShutdownTimeout is how long the server waits for in-flight requests
+	// after the drain period
+	ShutdownTimeout duration `yaml:"shutdownTimeout"`
+	// HealthCheckTimeout bounds the duration of every liveness and
+	// readiness check
+	HealthCheckTimeout duration `yaml:"healthCheckTimeout"`
+	// LogLevel is the minimum level of log entries, one of debug, info, warn
+	// or error. Request headers are logged at debug level.
+	LogLevel    string            `yaml:"logLevel"`
+	Tracing     tracingConfig     `yaml:"tracing"`
+	Compression compressionConfig `yaml:"compression"`
+	TLS         tlsConfig         `yaml:"tls"`
+	Auth        authConfig        `yaml:"auth"`
+	RateLimit   rateLimitConfig   `yaml:"rateLimit"`
+	CORS        corsConfig        `yaml:"cors"`
+	GraphQL     graphqlConfig     `yaml:"graphql"`
+	Stream      streamConfig      `yaml:"stream"`
+	Webhooks    webhookConfig     `yaml:"webhooks"`
+	// CacheControl is the Cache-Control header of successful responses
+	// about the catalogue and the favourites
+	CacheControl string `yaml:"cacheControl"`
+}
+
+type tracingConfig struct {
+	// Exporter is one of none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the url spans are posted to by the otlp exporter
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio is the fraction of new traces that are recorded. Traces
+	// started by callers are recorded if the caller sampled them.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// tlsConfig enables https if CertFile and KeyFile are set
+type tlsConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ClientCAFile is the bundle of CAs client certificates are verified
+	// against
+	ClientCAFile string `yaml:"clientCAFile"`
+	// ClientAuth is one of none, optional or required
+	ClientAuth string `yaml:"clientAuth"`
+	// MinVersion is one of 1.2 or 1.3
+	MinVersion string `yaml:"minVersion"`
+	// CipherSuites is a comma separated list of TLS 1.2 cipher suites, all
+	// secure ones if empty
+	CipherSuites string `yaml:"cipherSuites"`
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval duration `yaml:"reloadInterval"`
+	// RedirectPort is the port of a plain http listener redirecting to
+	// https, 0 disables it
+	RedirectPort int `yaml:"redirectPort"`
+}
+
+func (c tlsConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// authConfig enables authentication if api keys or a JWKS file are set.
+// Scopes are space separated lists.
+type authConfig struct {
+	// APIKeys can only be set in the config file
+	APIKeys []apiKeyConfig `yaml:"apiKeys,omitempty"`
+	// JWKSFile holds the keys bearer tokens are verified with
+	JWKSFile string `yaml:"jwksFile"`
+	// Issuer and Audience are checked against the claims of tokens if set
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// AnonymousScopes are granted to requests without credentials
+	AnonymousScopes string `yaml:"anonymousScopes"`
+}
+
+type apiKeyConfig struct {
+	Name string `yaml:"name"`
+	// Hash is the sha256 hash of the key in hex, prefixed with "sha256:"
+	Hash   string `yaml:"hash"`
+	Scopes string `yaml:"scopes"`
+}
+
+// rateLimitConfig limits the requests every client can make to every route
+type rateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Rate is the sustained number of requests per second and Burst the
+	// number of requests a client can make at once
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+	// Routes overrides the limits of single routes. It can only be set in
+	// the config file.
+	Routes []routeLimitConfig `yaml:"routes,omitempty"`
+	// TrustedProxies is a comma separated list of addresses and networks
+	// whose X-Forwarded-For header is trusted, e.g. the ingress controller
+	TrustedProxies string `yaml:"trustedProxies"`
+	// MaxClients bounds the number of clients whose buckets are kept in memory
+	MaxClients int `yaml:"maxClients"`
+}
+
+type routeLimitConfig struct {
+	// Route is the method and pattern of the route, e.g. "GET /tree"
+	Route string  `yaml:"route"`
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// corsConfig allows browsers to call the api from other origins. Lists are
+// comma separated, CORS is disabled if AllowedOrigins is empty.
+type corsConfig struct {
+	// AllowedOrigins are origins like https://example.com, the host may
+	// start with a wildcard as in https://*.example.com. * allows any origin.
+	AllowedOrigins string `yaml:"allowedOrigins"`
+	AllowedMethods string `yaml:"allowedMethods"`
+	// AllowedHeaders are the request headers scripts may set, * allows any
+	AllowedHeaders string `yaml:"allowedHeaders"`
+	// AllowCredentials lets browsers send cookies and Authorization headers
+	AllowCredentials bool `yaml:"allowCredentials"`
+	// MaxAge is how long browsers may cache the result of a preflight request
+	MaxAge duration `yaml:"maxAge"`
+}
+
+// graphqlConfig limits the queries of the /graphql endpoint
+type graphqlConfig struct {
+	// MaxDepth is the maximum nesting of fields
+	MaxDepth int `yaml:"maxDepth"`
+	// MaxComplexity is the maximum number of fields a query may resolve,
+	// counting the fields below a list once per item
+	MaxComplexity int `yaml:"maxComplexity"`
+}
+
+// streamConfig configures the live updates of /tree/stream and /tree/ws
+type streamConfig struct {
+	// MaxSubscribers caps the number of open streams, including the gRPC
+	// watches
+	MaxSubscribers int `yaml:"maxSubscribers"`
+	// Heartbeat is the interval of the pings keeping idle streams open
+	Heartbeat duration `yaml:"heartbeat"`
+	// Buffer is the number of changes a stream may fall behind before it is
+	// closed. Its client then reconnects and resumes.
+	Buffer int `yaml:"buffer"`
+}
+
+// webhookConfig configures the delivery of webhooks
+type webhookConfig struct {
+	// Timeout bounds every attempt of a delivery
+	Timeout duration `yaml:"timeout"`
+	// MaxAttempts is the number of attempts before a delivery is moved to
+	// the dead letters
+	MaxAttempts int `yaml:"maxAttempts"`
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry up to MaxBackoff.
+	Backoff    duration `yaml:"backoff"`
+	MaxBackoff duration `yaml:"maxBackoff"`
+	// Workers is the number of deliveries made at the same time
+	Workers int `yaml:"workers"`
+	// DeadLetters is the number of failed deliveries kept
+	DeadLetters int `yaml:"deadLetters"`
+	// AllowPrivateNetworks allows deliveries to loopback, link-local and
+	// private addresses. Otherwise anyone managing webhooks could make the
+	// server call internal services.
+	AllowPrivateNetworks bool `yaml:"allowPrivateNetworks"`
+}
+
+type compressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSize is the size in bytes below which responses are sent
+	// uncompressed
+	MinSize int `yaml:"minSize"`
+	// Level trades speed for size, from 1 (fastest) to 9 (smallest)
+	Level int `yaml:"level"`
+}
+
+type storageConfig struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+}
+
+func defaultConfig() config {
+	return config{
+		Port:         8090,
+		GRPCPort:     9090,
+		ReadTimeout:  duration(5 * time.Second),
+		WriteTimeout: duration(10 * time.Second),
+		DefaultTree:  defaultFavourite,
+		Storage: storageConfig{
+			Backend: storeMemory,
+			Path:    "trees.log",
+		},
+		DrainPeriod:        duration(5 * time.Second),
+		ShutdownTimeout:    duration(20 * time.Second),
+		HealthCheckTimeout: duration(time.Second),
+		LogLevel:           "info",
+		Tracing: tracingConfig{
+			Exporter:    exporterNone,
+			Endpoint:    "http://localhost:4318/v1/traces",
+			SampleRatio: 1,
+		},
+		Compression: compressionConfig{
+			Enabled: true,
+			MinSize: 1024,
+			Level:   6,
+		},
+		TLS: tlsConfig{
+			ClientAuth:     clientAuthNone,
+			MinVersion:     "1.2",
+			ReloadInterval: duration(time.Minute),
+		},
+		Auth: authConfig{
+			AnonymousScopes: scopeTreesRead,
+		},
+		RateLimit: rateLimitConfig{
+			Rate:       10,
+			Burst:      20,
+			MaxClients: 10000,
+		},
+		CORS: corsConfig{
+			AllowedMethods: "GET, HEAD, POST, PUT, PATCH, DELETE",
+			AllowedHeaders: "Accept, Accept-Language, Authorization, Content-Type, " +
+				"If-Match, If-None-Match, X-API-Key, X-User-ID",
+			MaxAge: duration(10 * time.Minute),
+		},
+		GraphQL: graphqlConfig{
+			MaxDepth:      8,
+			MaxComplexity: 1000,
+		},
+		Stream: streamConfig{
+			MaxSubscribers: 1000,
+			Heartbeat:      duration(15 * time.Second),
+			Buffer:         32,
+		},
+		Webhooks: webhookConfig{
+			Timeout:     duration(5 * time.Second),
+			MaxAttempts: 6,
+			Backoff:     duration(time.Second),
+			MaxBackoff:  duration(5 * time.Minute),
+			Workers:     4,
+			DeadLetters: 1000,
+		},
+		CacheControl: "no-cache",
+	}
+}
+
+// setting binds a config field to a command line flag and an environment
+// variable. The environment variable is the flag name in upper case with
+// dashes replaced by underscores and envPrefix prepended.
+type setting struct {
+	flag  string
+	usage string
+	field func(c *config) interface{}
+}
+
+var settings = []setting{
+	{"port", "port the server listens on",
+		func(c *config) interface{} { return &c.Port }},
+	{"grpc-port", "port of the gRPC api, 0 disables it",
+		func(c *config) interface{} { return &c.GRPCPort }},
+	{"read-timeout", "maximum duration for reading a request",
+		func(c *config) interface{} { return &c.ReadTimeout }},
+	{"write-timeout", "maximum duration for writing a response",
+		func(c *config) interface{} { return &c.WriteTimeout }},
+	{"default-tree", "id of the favourite tree of users that have not picked one",
+		func(c *config) interface{} { return &c.DefaultTree }},
+	{"store", "tree store backend, one of \"memory\", \"file\" or \"log\"",
+		func(c *config) interface{} { return &c.Storage.Backend }},
+	{"store-path", "file the \"file\" and \"log\" store backends persist to",
+		func(c *config) interface{} { return &c.Storage.Path }},
+	{"drain-period", "how long to keep serving after SIGTERM while reporting not ready",
+		func(c *config) interface{} { return &c.DrainPeriod }},
+	{"shutdown-timeout", "how long to wait for in-flight requests on shutdown",
+		func(c *config) interface{} { return &c.ShutdownTimeout }},
+	{"health-check-timeout", "maximum duration of a single liveness or readiness check",
+		func(c *config) interface{} { return &c.HealthCheckTimeout }},
+	{"log-level", "minimum level of log entries, one of debug, info, warn or error",
+		func(c *config) interface{} { return &c.LogLevel }},
+	{"tracing-exporter", "span exporter, one of \"none\", \"stdout\" or \"otlp\"",
+		func(c *config) interface{} { return &c.Tracing.Exporter }},
+	{"tracing-endpoint", "url of the OTLP/HTTP traces endpoint of the collector",
+		func(c *config) interface{} { return &c.Tracing.Endpoint }},
+	{"tracing-sample-ratio", "fraction of new traces that are recorded",
+		func(c *config) interface{} { return &c.Tracing.SampleRatio }},
+	{"compression", "compress responses with gzip or deflate if the client accepts it",
+		func(c *config) interface{} { return &c.Compression.Enabled }},
+	{"compression-min-size", "size in bytes below which responses are not compressed",
+		func(c *config) interface{} { return &c.Compression.MinSize }},
+	{"compression-level", "compression level from 1 (fastest) to 9 (smallest)",
+		func(c *config) interface{} { return &c.Compression.Level }},
+	{"tls-cert-file", "certificate file, enables https together with -tls-key-file",
+		func(c *config) interface{} { return &c.TLS.CertFile }},
+	{"tls-key-file", "private key file of the certificate",
+		func(c *config) interface{} { return &c.TLS.KeyFile }},
+	{"tls-client-ca-file", "CA bundle client certificates are verified against",
+		func(c *config) interface{} { return &c.TLS.ClientCAFile }},
+	{"tls-client-auth", "client certificate policy, one of \"none\", \"optional\" or \"required\"",
+		func(c *config) interface{} { return &c.TLS.ClientAuth }},
+	{"tls-min-version", "minimum tls version, one of \"1.2\" or \"1.3\"",
+		func(c *config) interface{} { return &c.TLS.MinVersion }},
+	{"tls-cipher-suites", "comma separated list of allowed TLS 1.2 cipher suites",
+		func(c *config) interface{} { return &c.TLS.CipherSuites }},
+	{"tls-reload-interval", "how often the certificate files are checked for changes",
+		func(c *config) interface{} { return &c.TLS.ReloadInterval }},
+	{"tls-redirect-port", "port of a plain http listener redirecting to https, 0 disables it",
+		func(c *config) interface{} { return &c.TLS.RedirectPort }},
+	{"auth-jwks-file", "JWKS file with the keys bearer tokens are verified with",
+		func(c *config) interface{} { return &c.Auth.JWKSFile }},
+	{"auth-issuer", "required issuer of bearer tokens",
+		func(c *config) interface{} { return &c.Auth.Issuer }},
+	{"auth-audience", "required audience of bearer tokens",
+		func(c *config) interface{} { return &c.Auth.Audience }},
+	{"auth-anonymous-scopes", "space separated scopes of requests without credentials",
+		func(c *config) interface{} { return &c.Auth.AnonymousScopes }},
+	{"rate-limit", "limit the requests of every client to every route",
+		func(c *config) interface{} { return &c.RateLimit.Enabled }},
+	{"rate-limit-rate", "sustained requests per second of a client to a route",
+		func(c *config) interface{} { return &c.RateLimit.Rate }},
+	{"rate-limit-burst", "requests a client can make to a route at once",
+		func(c *config) interface{} { return &c.RateLimit.Burst }},
+	{"rate-limit-trusted-proxies", "comma separated addresses and networks whose X-Forwarded-For is trusted",
+		func(c *config) interface{} { return &c.RateLimit.TrustedProxies }},
+	{"rate-limit-max-clients", "maximum number of clients whose limits are kept in memory",
+		func(c *config) interface{} { return &c.RateLimit.MaxClients }},
+	{"cors-allowed-origins", "comma separated origins allowed to call the api, e.g. https://*.example.com",
+		func(c *config) interface{} { return &c.CORS.AllowedOrigins }},
+	{"cors-allowed-methods", "comma separated methods other origins may use",
+		func(c *config) interface{} { return &c.CORS.AllowedMethods }},
+	{"cors-allowed-headers", "comma separated request headers other origins may send",
+		func(c *config) interface{} { return &c.CORS.AllowedHeaders }},
+	{"cors-allow-credentials", "allow other origins to send credentials",
+		func(c *config) interface{} { return &c.CORS.AllowCredentials }},
+	{"cors-max-age", "how long browsers may cache preflight responses",
+		func(c *config) interface{} { return &c.CORS.MaxAge }},
+	{"graphql-max-depth", "maximum nesting of the fields of a graphql query",
+		func(c *config) interface{} { return &c.GraphQL.MaxDepth }},
+	{"graphql-max-complexity", "maximum estimated number of fields a graphql query resolves",
+		func(c *config) interface{} { return &c.GraphQL.MaxComplexity }},
+	{"stream-max-subscribers", "maximum number of open live update streams and gRPC watches",
+		func(c *config) interface{} { return &c.Stream.MaxSubscribers }},
+	{"stream-heartbeat", "interval of the pings on idle live update streams",
+		func(c *config) interface{} { return &c.Stream.Heartbeat }},
+	{"stream-buffer", "number of changes a live update stream may fall behind before it is closed",
+		func(c *config) interface{} { return &c.Stream.Buffer }},
+	{"webhook-timeout", "maximum duration of a webhook delivery attempt",
+		func(c *config) interface{} { return &c.Webhooks.Timeout }},
+	{"webhook-max-attempts", "number of attempts before a webhook delivery is moved to the dead letters",
+		func(c *config) interface{} { return &c.Webhooks.MaxAttempts }},
+	{"webhook-backoff", "delay before the first retry of a webhook delivery, doubled with every retry",
+		func(c *config) interface{} { return &c.Webhooks.Backoff }},
+	{"webhook-max-backoff", "maximum delay between the retries of a webhook delivery",
+		func(c *config) interface{} { return &c.Webhooks.MaxBackoff }},
+	{"webhook-workers", "number of webhook deliveries made at the same time",
+		func(c *config) interface{} { return &c.Webhooks.Workers }},
+	{"webhook-dead-letters", "number of failed webhook deliveries kept",
+		func(c *config) interface{} { return &c.Webhooks.DeadLetters }},
+	{"webhook-allow-private-networks", "allow webhook deliveries to loopback, link-local and private addresses",
+		func(c *config) interface{} { return &c.Webhooks.AllowPrivateNetworks }},
+	{"cache-control", "Cache-Control header of catalogue and favourite responses",
+		func(c *config) interface{} { return &c.CacheControl }},
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.flag, "-", "_", -1))
+}
+
+// loadConfig assembles the configuration from the command line arguments,
+// the environment and the config file. It reports whether the configuration
+// should be printed instead of starting the server.
+func loadConfig(args []string, getenv func(string) string) (config, bool, error) {
+	fs := flag.NewFlagSet("tree-spotter", flag.ContinueOnError)
+	configFile := fs.String("config", getenv(envPrefix+"CONFIG"),
+		"yaml or json config file (env "+envPrefix+"CONFIG)")
+	printConfig := fs.Bool("print-config", false,
+		"print the effective configuration as yaml and exit")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return config{}, false, err
+	}
+
+	cfg := defaultConfig()
+	if *configFile != "" {
+		b, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return config{}, false, fmt.Errorf("read config file: %s", err)
+		}
+		if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+			return config{}, false, fmt.Errorf("parse config file \"%s\": %s", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env()); v != "" {
+			if err := setField(s.field(&cfg), v); err != nil {
+				return config{}, false, fmt.Errorf("environment variable %s: %s", s.env(), err)
+			}
+		}
+	}
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		v, ok := values[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := setField(s.field(&cfg), *v); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %s", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return config{}, false, flagErr
+	}
+
+	if err := cfg.validate(); err != nil {
+		return config{}, false, err
+	}
+	return cfg, *printConfig, nil
+}
+
+func setField(field interface{}, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("\"%s\" is not an integer", value)
+		}
+		*f = i
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("\"%s\" is not a number", value)
+		}
+		*f = v
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("\"%s\" is not a boolean", value)
+		}
+		*f = b
+	case *duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("\"%s\" is not a duration", value)
+		}
+		*f = duration(d)
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", field))
+	}
+	return nil
+}
+
+// validate returns an error listing every invalid setting
+func (c config) validate() error {
+	var errs []string
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		add("port: %d is not between 1 and 65535", c.Port)
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 || c.GRPCPort == c.Port {
+		add("grpcPort: %d is not a free port between 0 and 65535", c.GRPCPort)
+	}
+	if c.ReadTimeout <= 0 {
+		add("readTimeout: must be positive")
+	}
+	if c.WriteTimeout <= 0 {
+		add("writeTimeout: must be positive")
+	}
+	if c.DrainPeriod < 0 {
+		add("drainPeriod: must not be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		add("shutdownTimeout: must be positive")
+	}
+	if c.HealthCheckTimeout <= 0 {
+		add("healthCheckTimeout: must be positive")
+	}
+	if _, err := parseLevel(c.LogLevel); err != nil {
+		add("logLevel: %s", err)
+	}
+	switch c.Tracing.Exporter {
+	case exporterNone, exporterStdout:
+	case exporterOTLP:
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || u.Host == "" ||
+			(u.Scheme != "http" && u.Scheme != "https") {
+			add("tracing.endpoint: \"%s\" is not an http url", c.Tracing.Endpoint)
+		}
+	default:
+		add("tracing.exporter: \"%s\" is not one of \"none\", \"stdout\" or \"otlp\"", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sampleRatio: must be between 0 and 1")
+	}
+	if c.Compression.MinSize < 0 {
+		add("compression.minSize: must not be negative")
+	}
+	if c.Compression.Level < 1 || c.Compression.Level > 9 {
+		add("compression.level: must be between 1 and 9")
+	}
+	if c.TLS.enabled() {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			add("tls: certFile and keyFile must be given together")
+		}
+		if _, ok := tlsVersions[c.TLS.MinVersion]; !ok {
+			add("tls.minVersion: \"%s\" is not one of \"1.2\" or \"1.3\"", c.TLS.MinVersion)
+		}
+		if _, err := parseCipherSuites(c.TLS.CipherSuites); err != nil {
+			add("tls.cipherSuites: %s", err)
+		}
+		if c.TLS.ReloadInterval <= 0 {
+			add("tls.reloadInterval: must be positive")
+		}
+		if c.TLS.RedirectPort < 0 || c.TLS.RedirectPort > 65535 || c.TLS.RedirectPort == c.Port {
+			add("tls.redirectPort: %d is not a free port between 0 and 65535", c.TLS.RedirectPort)
+		}
+	}
+	switch c.TLS.ClientAuth {
+	case clientAuthNone:
+	case clientAuthOptional, clientAuthRequired:
+		if c.TLS.ClientCAFile == "" || !c.TLS.enabled() {
+			add("tls.clientAuth: \"%s\" requires https and clientCAFile", c.TLS.ClientAuth)
+		}
+	default:
+		add("tls.clientAuth: \"%s\" is not one of \"none\", \"optional\" or \"required\"", c.TLS.ClientAuth)
+	}
+	names := map[string]bool{}
+	for i, k := range c.Auth.APIKeys {
+		hash := strings.TrimPrefix(k.Hash, apiKeyHashPrefix)
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size || hash == k.Hash {
+			add("auth.apiKeys[%d].hash: must be \"%s\" followed by 64 hex digits", i, apiKeyHashPrefix)
+		}
+		if k.Name == "" || names[k.Name] {
+			add("auth.apiKeys[%d].name: must be unique and not empty", i)
+		}
+		names[k.Name] = true
+		if err := checkScopes(k.Scopes); err != nil {
+			add("auth.apiKeys[%d].scopes: %s", i, err)
+		}
+	}
+	if err := checkScopes(c.Auth.AnonymousScopes); err != nil {
+		add("auth.anonymousScopes: %s", err)
+	}
+	if c.RateLimit.Enabled {
+		if c.RateLimit.Rate <= 0 {
+			add("rateLimit.rate: must be positive")
+		}
+		if c.RateLimit.Burst < 1 {
+			add("rateLimit.burst: must be at least 1")
+		}
+		if c.RateLimit.MaxClients < 1 {
+			add("rateLimit.maxClients: must be at least 1")
+		}
+		if _, err := parseCIDRs(c.RateLimit.TrustedProxies); err != nil {
+			add("rateLimit.trustedProxies: %s", err)
+		}
+		routes, known := map[string]bool{}, apiRoutes()
+		for i, r := range c.RateLimit.Routes {
+			if !routePattern.MatchString(r.Route) || routes[r.Route] {
+				add("rateLimit.routes[%d].route: \"%s\" is not a unique method and path", i, r.Route)
+			} else if !known[r.Route] {
+				add("rateLimit.routes[%d].route: \"%s\" is not a rate limited route of the api", i, r.Route)
+			}
+			routes[r.Route] = true
+			if r.Rate <= 0 || r.Burst < 1 {
+				add("rateLimit.routes[%d]: rate must be positive and burst at least 1", i)
+			}
+		}
+	}
+	if _, err := newCORSPolicy(c.CORS); err != nil {
+		add("cors.allowedOrigins: %s", err)
+	}
+	if c.CORS.MaxAge < 0 {
+		add("cors.maxAge: must not be negative")
+	}
+	if c.GraphQL.MaxDepth < 1 {
+		add("graphql.maxDepth: must be at least 1")
+	}
+	if c.GraphQL.MaxComplexity < 1 {
+		add("graphql.maxComplexity: must be at least 1")
+	}
+	if c.Stream.MaxSubscribers < 1 {
+		add("stream.maxSubscribers: must be at least 1")
+	}
+	if c.Stream.Heartbeat <= 0 {
+		add("stream.heartbeat: must be positive")
+	}
+	if c.Stream.Buffer < 1 {
+		add("stream.buffer: must be at least 1")
+	}
+	if c.Webhooks.Timeout <= 0 {
+		add("webhooks.timeout: must be positive")
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		add("webhooks.maxAttempts: must be at least 1")
+	}
+	if c.Webhooks.Backoff <= 0 || c.Webhooks.MaxBackoff < c.Webhooks.Backoff {
+		add("webhooks.backoff: must be positive and not above webhooks.maxBackoff")
+	}
+	if c.Webhooks.Workers < 1 {
+		add("webhooks.workers: must be at least 1")
+	}
+	if c.Webhooks.DeadLetters < 1 {
+		add("webhooks.deadLetters: must be at least 1")
+	}
+	if strings.ContainsAny(c.CacheControl, "\r\n") {
+		add("cacheControl: must be a single line")
+	}
+	if !idPattern.MatchString(c.DefaultTree) {
+		add("defaultTree: \"%s\" is not a valid tree id", c.DefaultTree)
+	}
+	switch c.Storage.Backend {
+	case storeMemory:
+	case storeFile, storeLog:
+		if c.Storage.Path == "" {
+			add("storage.path: is required for the \"%s\" backend", c.Storage.Backend)
+		}
+	default:
+		add("storage.backend: \"%s\" is not one of \"memory\", \"file\" or \"log\"", c.Storage.Backend)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// addr is the address the server listens on
+func (c config) addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// checkScopes returns an error if a space separated list of scopes contains an
+// unknown scope
+func checkScopes(scopes string) error {
+next:
+	for _, s := range strings.Fields(scopes) {
+		for _, known := range knownScopes {
+			if s == known {
+				continue next
+			}
+		}
+		return fmt.Errorf("unknown scope \"%s\", must be one of %s", s, strings.Join(knownScopes, ", "))
+	}
+	return nil
+}
+
+// duration is a time.Duration that is written to and read from config files
+// in its string form, e.g. "5s"
+type duration time.Duration
+
+func (d duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("\"%s\" is not a duration", s)
+	}
+	*d = duration(v)
+	return nil
+}
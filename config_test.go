@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(file, []byte("port: 7000\nreadTimeout: 1s\nwriteTimeout: 2s\n"), 0644)
+
+	env := map[string]string{
+		"TREE_SPOTTER_CONFIG":        file,
+		"TREE_SPOTTER_READ_TIMEOUT":  "3s",
+		"TREE_SPOTTER_WRITE_TIMEOUT": "4s",
+	}
+	cfg, _, err := loadConfig([]string{"-write-timeout", "5s"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 7000 {
+		t.Errorf("expected the port from the file, got %d", cfg.Port)
+	}
+	if time.Duration(cfg.ReadTimeout) != 3*time.Second {
+		t.Errorf("expected the read timeout from the environment, got %v", cfg.ReadTimeout)
+	}
+	if time.Duration(cfg.WriteTimeout) != 5*time.Second {
+		t.Errorf("expected the write timeout from the flag, got %v", cfg.WriteTimeout)
+	}
+	if cfg.DefaultTree != defaultFavourite {
+		t.Errorf("expected the default tree to keep its default, got %s", cfg.DefaultTree)
+	}
+}
+
+func TestConfigJSONFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(file, []byte(`{"storage": {"backend": "file", "path": "/data/trees.json"}}`), 0644)
+
+	cfg, _, err := loadConfig([]string{"-config", file}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Storage.Backend != storeFile || cfg.Storage.Path != "/data/trees.json" {
+		t.Errorf("unexpected storage config %+v", cfg.Storage)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(file, []byte("prot: 7000\n"), 0644)
+	noEnv := func(string) string { return "" }
+
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-config", file}, "field prot not found"},
+		{[]string{"-port", "http"}, "flag -port: \"http\" is not an integer"},
+		{[]string{"-port", "0", "-store", "sql"}, "port: 0 is not between 1 and 65535"},
+		{[]string{"-store", "sql"}, "storage.backend: \"sql\""},
+		{[]string{"-read-timeout", "-1s"}, "readTimeout: must be positive"},
+		{[]string{"-tls-cert-file", "tls.crt"}, "certFile and keyFile must be given together"},
+		{[]string{"-tls-client-auth", "required"}, "tls.clientAuth: \"required\" requires https"},
+		{[]string{"-tls-cert-file", "tls.crt", "-tls-key-file", "tls.key", "-tls-min-version", "1.0"},
+			"tls.minVersion: \"1.0\""},
+		{[]string{"-tls-cert-file", "tls.crt", "-tls-key-file", "tls.key", "-tls-cipher-suites", "RC4"},
+			"unsupported cipher suite \"RC4\""},
+		{[]string{"-compression-level", "10"}, "compression.level: must be between 1 and 9"},
+		{[]string{"-rate-limit", "true", "-rate-limit-rate", "0"}, "rateLimit.rate: must be positive"},
+		{[]string{"-rate-limit", "true", "-rate-limit-trusted-proxies", "10.0.0.0/8,ingress"},
+			"rateLimit.trustedProxies: \"ingress\" is not an ip address or network"},
+	} {
+		_, _, err := loadConfig(tt.args, noEnv)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%v: expected an error containing %q, got %v", tt.args, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exposedHeaders are the response headers of the api that scripts of other
+// origins may read
+var exposedHeaders = []string{
+	"Content-Language", "ETag", "Last-Modified", "Link", "Location", "Retry-After",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", requestIDHeader,
+}
+
+// corsPolicy decides which cross origin requests browsers may make
+type corsPolicy struct {
+	origins     []string
+	anyOrigin   bool
+	methods     map[string]bool
+	headers     map[string]bool
+	anyHeader   bool
+	credentials bool
+	maxAge      time.Duration
+
+	allowMethods string
+	allowHeaders string
+}
+
+// newCORSPolicy returns nil if no origins are allowed
+func newCORSPolicy(cfg corsConfig) (*corsPolicy, error) {
+	origins := splitList(cfg.AllowedOrigins)
+	if len(origins) == 0 {
+		return nil, nil
+	}
+	p := &corsPolicy{
+		methods:     map[string]bool{},
+		headers:     map[string]bool{},
+		credentials: cfg.AllowCredentials,
+		maxAge:      time.Duration(cfg.MaxAge),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		if err := checkOrigin(o); err != nil {
+			return nil, err
+		}
+		p.origins = append(p.origins, strings.ToLower(o))
+	}
+	if p.anyOrigin && p.credentials {
+		return nil, fmt.Errorf("credentials can not be allowed for any origin")
+	}
+	methods := splitList(cfg.AllowedMethods)
+	for i, m := range methods {
+		methods[i] = strings.ToUpper(m)
+		p.methods[methods[i]] = true
+	}
+	p.allowMethods = strings.Join(methods, ", ")
+	headers := splitList(cfg.AllowedHeaders)
+	for _, h := range headers {
+		if h == "*" {
+			p.anyHeader = true
+		}
+		p.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	p.allowHeaders = strings.Join(headers, ", ")
+	return p, nil
+}
+
+// splitList splits a comma separated list and drops empty elements
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// checkOrigin accepts origins like https://example.com:8080. The host may
+// start with a wildcard label, e.g. https://*.example.com.
+func checkOrigin(origin string) error {
+	u, err := url.Parse(strings.Replace(origin, "*", "wildcard", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.User != nil ||
+		strings.Count(origin, "*") > 1 ||
+		(strings.Contains(origin, "*") && !strings.HasPrefix(u.Host, "wildcard.")) {
+		return fmt.Errorf("\"%s\" is not an origin like https://example.com or https://*.example.com", origin)
+	}
+	return nil
+}
+
+// allowsOrigin reports whether origin matches one of the allowed origins. A
+// wildcard matches one or more labels of the host.
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range p.origins {
+		i := strings.Index(o, "*")
+		if i < 0 {
+			if o == origin {
+				return true
+			}
+			continue
+		}
+		prefix, suffix := o[:i], o[i+1:]
+		if len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			isHostLabels(origin[len(prefix):len(origin)-len(suffix)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHostLabels(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return !strings.HasPrefix(s, ".") && !strings.HasSuffix(s, ".")
+}
+
+// allowsHeaders reports whether every header of the comma separated list of
+// a preflight request is allowed
+func (p *corsPolicy) allowsHeaders(list string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, h := range splitList(list) {
+		if !p.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin allows the origin of a request to read the response
+func (p *corsPolicy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// cors adds the CORS headers to the responses to allowed origins and answers
+// their preflight requests. It must run before authentication, as browsers
+// send no credentials with preflight requests.
+func cors(p *corsPolicy) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if !p.anyOrigin {
+				// the response depends on the origin unless any is allowed
+				addVary(h, "Origin")
+			}
+			origin := r.Header.Get("Origin")
+			method := r.Header.Get("Access-Control-Request-Method")
+			if r.Method == http.MethodOptions && origin != "" && method != "" {
+				addVary(h, "Access-Control-Request-Method")
+				addVary(h, "Access-Control-Request-Headers")
+				requested := r.Header.Get("Access-Control-Request-Headers")
+				if !p.allowsOrigin(origin) || !p.methods[method] || !p.allowsHeaders(requested) {
+					// without CORS headers the browser refuses the request
+					next.ServeHTTP(w, r)
+					return
+				}
+				p.setOrigin(h, origin)
+				h.Set("Access-Control-Allow-Methods", p.allowMethods)
+				if p.anyHeader {
+					if requested != "" {
+						h.Set("Access-Control-Allow-Headers", requested)
+					}
+				} else if p.allowHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+				}
+				if p.maxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if origin != "" && p.allowsOrigin(origin) {
+				p.setOrigin(h, origin)
+				h.Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newCORSTestServer(cors corsConfig) http.Handler {
+	return newTestServer(defaultTrees, func(cfg *config) {
+		cfg.CORS.AllowedOrigins = cors.AllowedOrigins
+		cfg.CORS.AllowCredentials = cors.AllowCredentials
+		if cors.AllowedHeaders != "" {
+			cfg.CORS.AllowedHeaders = cors.AllowedHeaders
+		}
+	}).handler()
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := newCORSTestServer(corsConfig{
+		AllowedOrigins:   "https://app.example.com, https://*.ecosia.org",
+		AllowCredentials: true,
+	})
+	for _, tc := range []struct {
+		origin, method, headers string
+		allowed                 bool
+	}{
+		{"https://app.example.com", "PUT", "Content-Type, X-API-Key", true},
+		{"https://APP.example.com", "GET", "", true},
+		{"https://www.ecosia.org", "DELETE", "if-match", true},
+		{"https://a.b.ecosia.org", "GET", "", true},
+		{"https://ecosia.org", "GET", "", false},
+		{"https://evil.org/.ecosia.org", "GET", "", false},
+		{"http://app.example.com", "GET", "", false},
+		{"https://app.example.com.evil.org", "GET", "", false},
+		{"https://app.example.com", "TRACE", "", false},
+		{"https://app.example.com", "GET", "X-Secret", false},
+	} {
+		rec := record(h, http.MethodOptions, "/trees/sequoia", "", withHeaders(map[string]string{
+			"Origin":                         tc.origin,
+			"Access-Control-Request-Method":  tc.method,
+			"Access-Control-Request-Headers": tc.headers,
+		}))
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s %s: expected status 204, got %d", tc.origin, tc.method, rec.Code)
+		}
+		got := rec.Header().Get("Access-Control-Allow-Origin")
+		if tc.allowed != (got == tc.origin) {
+			t.Errorf("%s %s %s: unexpected Access-Control-Allow-Origin %q", tc.origin, tc.method, tc.headers, got)
+		}
+		vary := strings.Join(rec.Header()["Vary"], ", ")
+		if !strings.Contains(vary, "Origin") || !strings.Contains(vary, "Access-Control-Request-Method") {
+			t.Errorf("%s: unexpected Vary %q", tc.origin, vary)
+		}
+		if !tc.allowed {
+			continue
+		}
+		for header, expected := range map[string]string{
+			"Access-Control-Allow-Methods":     "GET, HEAD, POST, PUT, PATCH, DELETE",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "600",
+		} {
+			if v := rec.Header().Get(header); v != expected {
+				t.Errorf("%s: expected %s %q, got %q", tc.origin, header, expected, v)
+			}
+		}
+		if !strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "X-API-Key") {
+			t.Errorf("%s: unexpected Access-Control-Allow-Headers %q", tc.origin, rec.Header().Get("Access-Control-Allow-Headers"))
+		}
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	h := newCORSTestServer(corsConfig{AllowedOrigins: "https://app.example.com"})
+
+	rec := record(h, http.MethodGet, "/tree", "", withHeaders(map[string]string{"Origin": "https://app.example.com"}))
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("expected the origin to be allowed, got %d %v", rec.Code, rec.Header())
+	}
+	for _, header := range []string{"ETag", "Link", "X-Request-ID"} {
+		if !strings.Contains(rec.Header().Get("Access-Control-Expose-Headers"), header) {
+			t.Errorf("expected %s to be exposed, got %q", header, rec.Header().Get("Access-Control-Expose-Headers"))
+		}
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("expected credentials not to be allowed")
+	}
+
+	for _, origin := range []string{"", "https://evil.org"} {
+		rec := record(h, http.MethodGet, "/tree", "", withHeaders(map[string]string{"Origin": origin}))
+		if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%q: expected no CORS headers, got %d %v", origin, rec.Code, rec.Header())
+		}
+		if !strings.Contains(strings.Join(rec.Header()["Vary"], ", "), "Origin") {
+			t.Errorf("%q: expected Vary: Origin, got %v", origin, rec.Header()["Vary"])
+		}
+	}
+
+	// plain OPTIONS requests are still answered by the router
+	rec = record(h, http.MethodOptions, "/tree", "", withHeaders(map[string]string{"Origin": "https://app.example.com"}))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") == "" {
+		t.Errorf("expected the router to answer, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := newCORSTestServer(corsConfig{AllowedOrigins: "*", AllowedHeaders: "*"})
+	rec := record(h, http.MethodOptions, "/tree", "", withHeaders(map[string]string{
+		"Origin":                         "https://anywhere.org",
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "X-Anything",
+	}))
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" ||
+		rec.Header().Get("Access-Control-Allow-Headers") != "X-Anything" {
+		t.Errorf("unexpected headers %v", rec.Header())
+	}
+	rec = record(h, http.MethodGet, "/tree", "", withHeaders(map[string]string{"Origin": "https://anywhere.org"}))
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("unexpected headers %v", rec.Header())
+	}
+}
+
+func TestCORSPreflightSkipsAuthentication(t *testing.T) {
+	h := newTestServer(defaultTrees, func(cfg *config) {
+		cfg.CORS.AllowedOrigins = "https://app.example.com"
+		cfg.Auth.AnonymousScopes = ""
+		cfg.Auth.APIKeys = []apiKeyConfig{{Name: "ci", Hash: hashAPIKey("secret"), Scopes: "trees:write"}}
+	}).handler()
+	rec := record(h, http.MethodOptions, "/trees/sequoia", "", withHeaders(map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": "PUT",
+	}))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("expected the preflight to pass, got %d", rec.Code)
+	}
+	// browsers can read the errors of rejected requests
+	rec = record(h, http.MethodGet, "/tree", "", withHeaders(map[string]string{"Origin": "https://app.example.com"}))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("expected a readable 401, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCORSConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		cors     corsConfig
+		expected string
+	}{
+		{corsConfig{AllowedOrigins: "app.example.com"}, "\"app.example.com\" is not an origin"},
+		{corsConfig{AllowedOrigins: "https://app.example.com/"}, "is not an origin"},
+		{corsConfig{AllowedOrigins: "https://*example.com"}, "is not an origin"},
+		{corsConfig{AllowedOrigins: "https://*.*.example.com"}, "is not an origin"},
+		{corsConfig{AllowedOrigins: "*", AllowCredentials: true}, "credentials can not be allowed for any origin"},
+		{corsConfig{MaxAge: duration(-1)}, "cors.maxAge"},
+	} {
+		cfg := defaultConfig()
+		cfg.CORS = tc.cors
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%+v: expected an error containing %q, got %v", tc.cors, tc.expected, err)
+		}
+	}
+}
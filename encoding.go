@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// encoder writes response bodies in one media type
+type encoder struct {
+	// format is the value of the ?format= query parameter selecting it
+	format      string
+	contentType string
+	// mediaTypes are matched against the Accept header
+	mediaTypes []string
+	// supports reports whether v can be encoded, nil means always
+	supports func(v interface{}) bool
+	encode   func(w io.Writer, v interface{}) error
+}
+
+// textMarshaler is implemented by responses that have a plain text form
+type textMarshaler interface {
+	plainText() string
+}
+
+// csvMarshaler is implemented by list responses. The first record is the
+// header.
+type csvMarshaler interface {
+	csvRecords() [][]string
+}
+
+// jsonStreamer is implemented by list responses that write their json
+// encoding item by item rather than building it in memory
+type jsonStreamer interface {
+	streamJSON(w io.Writer) error
+}
+
+// encoders lists the supported response formats. The first one is used if
+// the client expresses no preference.
+var encoders = []encoder{
+	{
+		format:      "json",
+		contentType: "application/json",
+		mediaTypes:  []string{"application/json"},
+		encode: func(w io.Writer, v interface{}) error {
+			if s, ok := v.(jsonStreamer); ok {
+				return s.streamJSON(w)
+			}
+			return json.NewEncoder(w).Encode(v)
+		},
+	},
+	{
+		format:      "xml",
+		contentType: "application/xml; charset=utf-8",
+		mediaTypes:  []string{"application/xml", "text/xml"},
+		encode: func(w io.Writer, v interface{}) error {
+			io.WriteString(w, xml.Header)
+			enc := xml.NewEncoder(w)
+			enc.Indent("", "  ")
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, "\n")
+			return err
+		},
+	},
+	{
+		format:      "yaml",
+		contentType: "application/yaml",
+		mediaTypes:  []string{"application/yaml", "application/x-yaml", "text/yaml"},
+		encode: func(w io.Writer, v interface{}) error {
+			b, err := yaml.Marshal(v)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(b)
+			return err
+		},
+	},
+	{
+		format:      "csv",
+		contentType: "text/csv; charset=utf-8",
+		mediaTypes:  []string{"text/csv"},
+		supports: func(v interface{}) bool {
+			_, ok := v.(csvMarshaler)
+			return ok
+		},
+		encode: func(w io.Writer, v interface{}) error {
+			cw := csv.NewWriter(w)
+			cw.WriteAll(v.(csvMarshaler).csvRecords())
+			return cw.Error()
+		},
+	},
+	{
+		format:      "text",
+		contentType: "text/plain; charset=utf-8",
+		mediaTypes:  []string{"text/plain"},
+		supports: func(v interface{}) bool {
+			_, ok := v.(textMarshaler)
+			return ok
+		},
+		encode: func(w io.Writer, v interface{}) error {
+			_, err := io.WriteString(w, v.(textMarshaler).plainText()+"\n")
+			return err
+		},
+	},
+}
+
+// mediaRange is a single entry of an Accept header
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges of an Accept header ordered by
+// descending quality. Ranges with equal quality keep their order.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mr := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if mr.mediaType == "" {
+			continue
+		}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil && q >= 0 && q <= 1 {
+					mr.q = q
+				}
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// matches reports whether the media range covers mediaType
+func (mr mediaRange) matches(mediaType string) bool {
+	if mr.mediaType == "*/*" || mr.mediaType == mediaType {
+		return true
+	}
+	return strings.HasSuffix(mr.mediaType, "/*") &&
+		strings.HasPrefix(mediaType, strings.TrimSuffix(mr.mediaType, "*"))
+}
+
+// negotiate selects the encoder for v from the ?format= parameter or the
+// Accept header of r. It returns false if no supported format is acceptable.
+func negotiate(r *http.Request, v interface{}) (encoder, bool) {
+	var candidates []encoder
+	for _, e := range encoders {
+		if e.supports == nil || e.supports(v) {
+			candidates = append(candidates, e)
+		}
+	}
+
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, e := range candidates {
+			if e.format == format {
+				return e, true
+			}
+		}
+		return encoder{}, false
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return candidates[0], true
+	}
+	ranges := parseAccept(accept)
+	// explicitly refused media types are excluded before wildcards match
+	refused := map[string]bool{}
+	for _, mr := range ranges {
+		if mr.q == 0 {
+			refused[mr.mediaType] = true
+		}
+	}
+	for _, mr := range ranges {
+		if mr.q == 0 {
+			break
+		}
+		// primary media types are preferred over aliases so that e.g.
+		// text/* selects text/plain rather than text/xml
+		for _, primary := range []bool{true, false} {
+			for _, e := range candidates {
+				for i, mt := range e.mediaTypes {
+					if (i == 0) == primary && !refused[mt] && mr.matches(mt) {
+						return e, true
+					}
+				}
+			}
+		}
+	}
+	return encoder{}, false
+}
+
+// addVary adds the request header field to the Vary header unless it is
+// listed already
+func addVary(h http.Header, field string) {
+	for _, v := range h["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// respond writes v in the format and language negotiated with the client
+func respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	addVary(w.Header(), "Accept")
+	e, ok := negotiate(r, v)
+	if !ok {
+		var formats []string
+		for _, e := range encoders {
+			if e.supports == nil || e.supports(v) {
+				formats = append(formats, e.mediaTypes[0])
+			}
+		}
+		writeError(w, r, http.StatusNotAcceptable, codeNotAcceptable,
+			newMessage("the resource is available as %s", strings.Join(formats, ", ")))
+		return
+	}
+
+	lang := negotiateLanguage(r)
+	if vv, ok := v.(versioned); ok {
+		// trees and pages of trees, which can be large, are tagged by
+		// their version and streamed to the client
+		setLanguage(w, lang)
+		w.Header().Set("ETag", versionTag(vv, e.contentType, lang))
+		if status == http.StatusOK && notModified(r, w.Header()) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", e.contentType)
+		w.WriteHeader(status)
+		if err := e.encode(w, localize(v, lang)); err != nil {
+			log.Printf("failed to encode response as %s: %s", e.format, err)
+		}
+		return
+	}
+
+	var body bytes.Buffer
+	if err := e.encode(&body, localize(v, lang)); err != nil {
+		log.Printf("failed to encode response as %s: %s", e.format, err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, newMessage("failed to encode the response"))
+		return
+	}
+	setLanguage(w, lang)
+	w.Header().Set("ETag", etag(body.Bytes()))
+	if status == http.StatusOK && notModified(r, w.Header()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", e.contentType)
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// localize returns v in the language l
+func localize(v interface{}, l *language) interface{} {
+	if lv, ok := v.(localizable); ok {
+		return lv.localize(l)
+	}
+	return v
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiation(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	for _, tt := range []struct {
+		path, accept string
+		status       int
+		contentType  string
+		contains     string
+	}{
+		{"/tree", "", http.StatusOK, "application/json", `{"myFavouriteTree":"Sequoia"}`},
+		{"/tree", "*/*", http.StatusOK, "application/json", `{"myFavouriteTree":"Sequoia"}`},
+		{"/tree", "text/plain", http.StatusOK, "text/plain; charset=utf-8", "Sequoia\n"},
+		{"/tree", "application/xml", http.StatusOK, "application/xml; charset=utf-8",
+			"<favouriteTree>\n  <myFavouriteTree>Sequoia</myFavouriteTree>\n</favouriteTree>"},
+		{"/tree", "text/html, application/yaml;q=0.9, application/json;q=0.8", http.StatusOK,
+			"application/yaml", "myFavouriteTree: Sequoia\n"},
+		{"/tree", "application/json;q=0.1, text/*;q=0.5", http.StatusOK, "text/plain; charset=utf-8", "Sequoia"},
+		{"/tree", "*/*, application/json;q=0", http.StatusOK, "application/xml; charset=utf-8", "<favouriteTree>"},
+		{"/tree", "text/html", http.StatusNotAcceptable, "application/json", "not_acceptable"},
+		{"/tree", "text/csv", http.StatusNotAcceptable, "application/json", "not_acceptable"},
+		{"/trees", "text/csv", http.StatusOK, "text/csv; charset=utf-8",
+			"id,species,commonName,scientificName,family,nativeRegions,maxHeightMetres,lifespanYears,description\n"},
+		{"/trees", "text/csv", http.StatusOK, "text/csv; charset=utf-8",
+			"english-oak,Oak,English oak,Quercus robur,Fagaceae,Europe;Asia,40,1000,\"A long lived"},
+		{"/trees/ginkgo", "application/xml", http.StatusOK, "application/xml; charset=utf-8",
+			"<nativeRegions>\n    <region>Asia</region>\n  </nativeRegions>"},
+		{"/trees/ginkgo?format=yaml", "application/json", http.StatusOK, "application/yaml", "scientificName: Ginkgo biloba\n"},
+		{"/trees/ginkgo?format=csv", "", http.StatusNotAcceptable, "application/json", "text/plain"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != tt.status || rec.Header().Get("Content-Type") != tt.contentType ||
+			!strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("%s with Accept %q: expected %d %s containing %q, got %d %s\n%s",
+				tt.path, tt.accept, tt.status, tt.contentType, tt.contains,
+				rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+		}
+		if rec.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: expected Vary: Accept, got %q", tt.path, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestVaryListsEveryFieldOnce(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+
+	for _, accept := range []string{"application/json", "image/png"} {
+		req := httptest.NewRequest(http.MethodGet, "/trees/oak", nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Language", "de")
+		req.Header.Set("Origin", "https://example.com")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		expectVaryOnce(t, accept, rec.Header())
+	}
+
+	// handlers announcing the language before they fail
+	req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+	rec := httptest.NewRecorder()
+	setLanguage(rec, languages["de"])
+	writeError(rec, req, http.StatusBadRequest, codeBadRequest, newMessage("the query is missing"))
+	expectVaryOnce(t, "error", rec.Header())
+}
+
+func expectVaryOnce(t *testing.T, name string, h http.Header) {
+	t.Helper()
+	seen := map[string]bool{}
+	for _, v := range h["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.ToLower(strings.TrimSpace(f))
+			if seen[f] {
+				t.Errorf("%s: Vary lists %s twice: %v", name, f, h["Vary"])
+			}
+			seen[f] = true
+		}
+	}
+	if !seen["accept-language"] {
+		t.Errorf("%s: expected Vary: Accept-Language, got %v", name, h["Vary"])
+	}
+}
+
+// countingWriter counts the writes of a streamed response
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+func TestTreeListStreamsJSON(t *testing.T) {
+	var trees []Tree
+	for i := 0; i < 100; i++ {
+		for _, tree := range defaultTrees {
+			tree.ID = fmt.Sprintf("%s-%d", tree.ID, i)
+			trees = append(trees, tree)
+		}
+	}
+	for _, list := range []treeList{
+		{Trees: []Tree{}},
+		{Trees: defaultTrees[:2], NextCursor: "next<&>", PrevCursor: "prev"},
+		{Trees: trees},
+	} {
+		expected, err := json.Marshal(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var w countingWriter
+		if err := list.streamJSON(&w); err != nil {
+			t.Fatal(err)
+		}
+		if w.String() != string(expected)+"\n" {
+			t.Errorf("expected the json of encoding/json, got %s", w.String())
+		}
+		if len(list.Trees) == len(trees) && w.writes < 2 {
+			t.Errorf("expected a large list to be written in parts, got %d writes", w.writes)
+		}
+	}
+}
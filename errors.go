@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error codes returned in the code field of an apiError
+const (
+	codeBadRequest         = "bad_request"
+	codeValidation         = "validation_failed"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeNotFound           = "not_found"
+	codeConflict           = "conflict"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeNotAcceptable      = "not_acceptable"
+	codePreconditionFailed = "precondition_failed"
+	codeTooManyRequests    = "too_many_requests"
+	codeUnavailable        = "unavailable"
+	codeInternal           = "internal_error"
+)
+
+// message is an error message that can be translated. Its id is the english
+// format of the message, which keys its translations in the catalogues.
+type message struct {
+	id   string
+	args []interface{}
+}
+
+func newMessage(id string, args ...interface{}) *message {
+	return &message{id, args}
+}
+
+// Error returns the message in english
+func (m *message) Error() string {
+	return fmt.Sprintf(m.id, m.args...)
+}
+
+// messageOf returns err as a message. Errors that are no messages are
+// returned untranslated.
+func messageOf(err error) *message {
+	if m, ok := err.(*message); ok {
+		return m
+	}
+	return newMessage("%s", err)
+}
+
+func notFoundMessage(what, id string) *message {
+	return newMessage("%s \"%s\" does not exist", newMessage(what), id)
+}
+
+// fieldError describes why a single field of a request body was rejected
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	msg     *message
+}
+
+func newFieldError(field, id string, args ...interface{}) fieldError {
+	return fieldErrorOf(field, newMessage(id, args...))
+}
+
+func fieldErrorOf(field string, m *message) fieldError {
+	return fieldError{Field: field, Message: m.Error(), msg: m}
+}
+
+// apiError is the body of every non 2xx json response
+type apiError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []fieldError `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// writeError writes an error response with the message and details
+// translated to the language negotiated with the client
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, m *message, details ...fieldError) {
+	lang := negotiateLanguage(r)
+	setLanguage(w, lang)
+	// errors must not inherit the cache policy of the resource
+	if w.Header().Get("Cache-Control") != "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	var translated []fieldError
+	for _, d := range details {
+		translated = append(translated, fieldError{Field: d.Field, Message: lang.translate(d.msg)})
+	}
+	writeJSON(w, status, errorResponse{apiError{code, lang.translate(m), translated}})
+}
+
+func writeNotFound(w http.ResponseWriter, r *http.Request, what, id string) {
+	writeError(w, r, http.StatusNotFound, codeNotFound, notFoundMessage(what, id))
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed,
+		newMessage("method \"%s\" is not allowed on \"%s\"", r.Method, r.URL.Path))
+}
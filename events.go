@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Types of the changes to the catalogue and the favourites
+const (
+	treeCreated      = "created"
+	treeUpdated      = "updated"
+	treeDeleted      = "deleted"
+	favouriteChanged = "favourite"
+)
+
+// eventHistory is the number of past events kept for subscribers resuming
+// after a lost connection
+const eventHistory = 256
+
+// treeEvent is a change to the catalogue or to the favourite of a user. The
+// tree of a deleted event only holds the id, the tree of a favourite event
+// is the new favourite of User and also only holds the id.
+type treeEvent struct {
+	// ID increases with every change
+	ID   uint64
+	Type string
+	Tree Tree
+	User string
+	Time time.Time
+}
+
+// treeEvents fans the changes to the catalogue out to its subscribers.
+// Subscribers that do not keep up are dropped, so that a slow one never
+// holds up the writes to the catalogue.
+type treeEvents struct {
+	mu          sync.Mutex
+	last        uint64
+	history     []treeEvent
+	subscribers map[chan treeEvent]bool
+	closed      bool
+}
+
+func newTreeEvents() *treeEvents {
+	return &treeEvents{subscribers: map[chan treeEvent]bool{}}
+}
+
+// subscribe returns a channel receiving every following change and a
+// function cancelling the subscription. The channel is closed once the
+// subscription is cancelled, more than buffer events are pending or the
+// server shuts down.
+func (e *treeEvents) subscribe(buffer int) (<-chan treeEvent, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.subscribeLocked(buffer)
+}
+
+// resume subscribes like subscribe and returns the events published after
+// the event with id after, and the id of the latest event. It reports false
+// and returns no events if some of them are no longer in the history or the
+// id is unknown, e.g. because it was issued before a restart.
+func (e *treeEvents) resume(after uint64, buffer int) (missed []treeEvent, last uint64, complete bool,
+	c <-chan treeEvent, cancel func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, cancel = e.subscribeLocked(buffer)
+	if after > e.last || (after < e.last && (len(e.history) == 0 || e.history[0].ID > after+1)) {
+		return nil, e.last, false, c, cancel
+	}
+	for _, ev := range e.history {
+		if ev.ID > after {
+			missed = append(missed, ev)
+		}
+	}
+	return missed, e.last, true, c, cancel
+}
+
+// lastID returns the id of the latest event
+func (e *treeEvents) lastID() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+func (e *treeEvents) subscribeLocked(buffer int) (<-chan treeEvent, func()) {
+	c := make(chan treeEvent, buffer)
+	if e.closed {
+		close(c)
+	} else {
+		e.subscribers[c] = true
+	}
+	return c, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.subscribers[c] {
+			delete(e.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// isClosed reports whether the events have been closed
+func (e *treeEvents) isClosed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closed
+}
+
+// close ends all subscriptions and refuses new ones
+func (e *treeEvents) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for c := range e.subscribers {
+		delete(e.subscribers, c)
+		close(c)
+	}
+}
+
+func (e *treeEvents) publish(typ string, t Tree) {
+	e.publishEvent(treeEvent{Type: typ, Tree: t})
+}
+
+func (e *treeEvents) publishFavourite(user, treeID string) {
+	e.publishEvent(treeEvent{Type: favouriteChanged, Tree: Tree{ID: treeID}, User: user})
+}
+
+func (e *treeEvents) publishEvent(ev treeEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last++
+	ev.ID = e.last
+	ev.Time = time.Now().UTC()
+	if len(e.history) == eventHistory {
+		copy(e.history, e.history[1:])
+		e.history = e.history[:eventHistory-1]
+	}
+	e.history = append(e.history, ev)
+	for c := range e.subscribers {
+		select {
+		case c <- ev:
+		default:
+			delete(e.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// publishingStore publishes the changes made through it
+type publishingStore struct {
+	TreeStore
+	events *treeEvents
+}
+
+func (s publishingStore) Create(t Tree) error {
+	if err := s.TreeStore.Create(t); err != nil {
+		return err
+	}
+	s.events.publish(treeCreated, t)
+	return nil
+}
+
+func (s publishingStore) Put(t Tree) (bool, error) {
+	created, err := s.TreeStore.Put(t)
+	if err != nil {
+		return false, err
+	}
+	if created {
+		s.events.publish(treeCreated, t)
+	} else {
+		s.events.publish(treeUpdated, t)
+	}
+	return created, nil
+}
+
+func (s publishingStore) Update(id string, fn func(*Tree) error) (Tree, error) {
+	t, err := s.TreeStore.Update(id, fn)
+	if err != nil {
+		return t, err
+	}
+	s.events.publish(treeUpdated, t)
+	return t, nil
+}
+
+func (s publishingStore) Delete(id string) error {
+	if err := s.TreeStore.Delete(id); err != nil {
+		return err
+	}
+	s.events.publish(treeDeleted, Tree{ID: id})
+	return nil
+}
+
+func (s publishingStore) SetFavourite(user, treeID string) error {
+	if err := s.TreeStore.SetFavourite(user, treeID); err != nil {
+		return err
+	}
+	s.events.publishFavourite(user, treeID)
+	return nil
+}
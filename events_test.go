@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestTreeEvents(t *testing.T) {
+	e := newTreeEvents()
+	fast, cancelFast := e.subscribe(10)
+	defer cancelFast()
+	slow, cancelSlow := e.subscribe(1)
+	defer cancelSlow()
+
+	e.publish(treeCreated, Tree{ID: "rowan"})
+	e.publish(treeDeleted, Tree{ID: "rowan"})
+
+	for i, expected := range []string{treeCreated, treeDeleted} {
+		ev := <-fast
+		if ev.Type != expected || ev.ID != uint64(i+1) {
+			t.Errorf("expected event %d to be %s, got %+v", i+1, expected, ev)
+		}
+	}
+	// the slow subscriber is dropped instead of blocking the publisher
+	if ev := <-slow; ev.Type != treeCreated {
+		t.Errorf("expected the first event, got %+v", ev)
+	}
+	if _, ok := <-slow; ok {
+		t.Error("expected the slow subscriber to be dropped")
+	}
+
+	e.close()
+	if _, ok := <-fast; ok {
+		t.Error("expected close to end the subscriptions")
+	}
+	late, cancelLate := e.subscribe(1)
+	defer cancelLate()
+	if _, ok := <-late; ok {
+		t.Error("expected no subscriptions after close")
+	}
+}
+
+func TestTreeEventsResume(t *testing.T) {
+	e := newTreeEvents()
+	e.publish(treeCreated, Tree{ID: "rowan"})
+	e.publishFavourite("kim", "rowan")
+
+	missed, last, complete, _, cancel := e.resume(1, 1)
+	cancel()
+	if !complete || last != 2 || len(missed) != 1 || missed[0].Type != favouriteChanged || missed[0].User != "kim" {
+		t.Errorf("expected to resume with the favourite event, got %+v %d %t", missed, last, complete)
+	}
+	if missed, _, complete, _, cancel := e.resume(2, 1); !complete || len(missed) != 0 {
+		t.Errorf("expected nothing to be missed, got %+v %t", missed, complete)
+		cancel()
+	}
+	// ids from before a restart are unknown
+	if _, _, complete, _, cancel := e.resume(3, 1); complete {
+		t.Error("expected an unknown id to be reported")
+		cancel()
+	}
+
+	for i := 0; i < eventHistory; i++ {
+		e.publish(treeUpdated, Tree{ID: "rowan"})
+	}
+	if _, _, complete, _, cancel := e.resume(1, 1); complete {
+		t.Error("expected events dropped from the history to be reported")
+		cancel()
+	}
+	if missed, _, complete, _, cancel := e.resume(2, 1); !complete || len(missed) != eventHistory {
+		t.Errorf("expected %d missed events, got %d", eventHistory, len(missed))
+		cancel()
+	}
+}
@@ -1,3 +1,11 @@
 module github.com/floekkchen/ecosia_intro
 
 go 1.13
+
+require (
+	github.com/golang/protobuf v1.3.5
+	github.com/gorilla/websocket v1.4.2
+	github.com/graphql-go/graphql v0.7.9
+	google.golang.org/grpc v1.29.1
+	gopkg.in/yaml.v2 v2.2.8
+)
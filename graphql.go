@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// defaultListCost is the number of items assumed for lists without a first
+// argument, like the families, when the complexity of a query is estimated
+const defaultListCost = 10
+
+// graphqlRequest is the body of a POST /graphql request
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlCaller is the user and language of a graphql request
+type graphqlCaller struct {
+	user string
+	lang *language
+}
+
+type graphqlCallerKey struct{}
+
+func callerFrom(ctx context.Context) graphqlCaller {
+	c, _ := ctx.Value(graphqlCallerKey{}).(graphqlCaller)
+	return c
+}
+
+// treeFamily and treeRegion group the trees of the catalogue
+type (
+	treeFamily string
+	treeRegion string
+)
+
+// graphqlSchema builds the schema of the /graphql endpoint on top of the
+// store of s
+func (s *server) graphqlSchema() (graphql.Schema, error) {
+	firstArg := &graphql.ArgumentConfig{
+		Type:         graphql.Int,
+		DefaultValue: defaultPageSize,
+		Description:  fmt.Sprintf("maximum number of trees, at most %d", maxPageSize),
+	}
+	treeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Tree",
+		Description: "A tree of the catalogue",
+		Fields: graphql.Fields{
+			"id":              &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"species":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"commonName":      &graphql.Field{Type: graphql.String},
+			"scientificName":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"maxHeightMetres": &graphql.Field{Type: graphql.Float},
+			"lifespanYears":   &graphql.Field{Type: graphql.Int},
+			"description":     &graphql.Field{Type: graphql.String},
+		},
+	})
+	familyType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Family",
+		Description: "A botanical family and its trees",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return string(p.Source.(treeFamily)), nil
+				},
+			},
+			"trees": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(treeType))),
+				Args: graphql.FieldConfigArgument{"first": firstArg},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return s.graphqlTrees(p, "family", string(p.Source.(treeFamily)))
+				},
+			},
+		},
+	})
+	regionType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Region",
+		Description: "A region trees are native to",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return string(p.Source.(treeRegion)), nil
+				},
+			},
+			"trees": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(treeType))),
+				Args: graphql.FieldConfigArgument{"first": firstArg},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return s.graphqlTrees(p, "region", string(p.Source.(treeRegion)))
+				},
+			},
+		},
+	})
+	treeType.AddFieldConfig("family", &graphql.Field{
+		Type: graphql.NewNonNull(familyType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return treeFamily(p.Source.(Tree).Family), nil
+		},
+	})
+	treeType.AddFieldConfig("nativeRegions", &graphql.Field{
+		Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(regionType))),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var regions []treeRegion
+			for _, r := range p.Source.(Tree).NativeRegions {
+				regions = append(regions, treeRegion(r))
+			}
+			return regions, nil
+		},
+	})
+	pageType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "TreePage",
+		Description: "A page of the catalogue",
+		Fields: graphql.Fields{
+			"trees":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(treeType)))},
+			"nextCursor": &graphql.Field{Type: graphql.String},
+			"prevCursor": &graphql.Field{Type: graphql.String},
+		},
+	})
+	favouriteType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Favourite",
+		Description: "The favourite tree of a user",
+		Fields: graphql.Fields{
+			"userId": &graphql.Field{
+				Type:        graphql.String,
+				Description: "null for anonymous users",
+			},
+			"tree": &graphql.Field{Type: graphql.NewNonNull(treeType)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"tree": &graphql.Field{
+				Type:        treeType,
+				Description: "The tree with the given id, null if there is none",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					t, err := s.store(p.Context).Get(p.Args["id"].(string))
+					if err == errTreeNotFound {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, graphqlStoreError(err, "")
+					}
+					return callerFrom(p.Context).lang.tree(t), nil
+				},
+			},
+			"trees": &graphql.Field{
+				Type:        graphql.NewNonNull(pageType),
+				Description: "A page of the catalogue, filtered and sorted like GET /trees",
+				Args: graphql.FieldConfigArgument{
+					"first":     firstArg,
+					"after":     &graphql.ArgumentConfig{Type: graphql.String, Description: "cursor of the previous page"},
+					"sort":      &graphql.ArgumentConfig{Type: graphql.String},
+					"family":    &graphql.ArgumentConfig{Type: graphql.String},
+					"region":    &graphql.ArgumentConfig{Type: graphql.String},
+					"minHeight": &graphql.ArgumentConfig{Type: graphql.Float},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					values := url.Values{}
+					for arg, param := range map[string]string{
+						"first": "limit", "after": "cursor", "sort": "sort",
+						"family": "family", "region": "region", "minHeight": "minHeight",
+					} {
+						if v, ok := p.Args[arg]; ok && v != nil {
+							values.Set(param, fmt.Sprint(v))
+						}
+					}
+					return s.graphqlPage(p.Context, values)
+				},
+			},
+			"families": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(familyType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return s.graphqlGroups(p.Context, func(t Tree) []string { return []string{t.Family} },
+						func(name string) interface{} { return treeFamily(name) })
+				},
+			},
+			"regions": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(regionType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return s.graphqlGroups(p.Context, func(t Tree) []string { return t.NativeRegions },
+						func(name string) interface{} { return treeRegion(name) })
+				},
+			},
+			"favourite": &graphql.Field{
+				Type:        graphql.NewNonNull(favouriteType),
+				Description: "The favourite tree of a user, the caller if userId is omitted",
+				Args: graphql.FieldConfigArgument{
+					"userId": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user, err := graphqlUser(p)
+					if err != nil {
+						return nil, err
+					}
+					t, err := s.favouriteTree(p.Context, user)
+					if err != nil {
+						return nil, graphqlStoreError(err, t.ID)
+					}
+					return graphqlFavourite(p.Context, user, t), nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"setFavourite": &graphql.Field{
+				Type:        graphql.NewNonNull(favouriteType),
+				Description: "Makes a tree the favourite of a user, the caller if userId is omitted",
+				Args: graphql.FieldConfigArgument{
+					"userId": &graphql.ArgumentConfig{Type: graphql.String},
+					"treeId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if s.auth != nil {
+						if pr, _ := p.Context.Value(principalKey{}).(principal); !pr.scopes[scopeFavouritesWrite] {
+							return nil, newMessage("the scope %s is required", scopeFavouritesWrite)
+						}
+					}
+					user, err := graphqlUser(p)
+					if err != nil {
+						return nil, err
+					}
+					if user == "" {
+						return nil, newMessage("userId is required for anonymous callers")
+					}
+					if !s.mayChangeFavourite(p.Context, user) {
+						return nil, newMessage("the favourite of another user can not be changed")
+					}
+					id := p.Args["treeId"].(string)
+					if err := s.store(p.Context).SetFavourite(user, id); err != nil {
+						return nil, graphqlStoreError(err, id)
+					}
+					t, err := s.store(p.Context).Get(id)
+					if err != nil {
+						return nil, graphqlStoreError(err, id)
+					}
+					return graphqlFavourite(p.Context, user, t), nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// graphqlStoreError hides the errors of the store from clients except for
+// missing trees
+func graphqlStoreError(err error, id string) error {
+	if err == errTreeNotFound {
+		return notFoundMessage("tree", id)
+	}
+	log.Printf("tree store error: %s", err)
+	return newMessage("the tree store failed")
+}
+
+// graphqlUser returns the userId argument of a field or the caller
+func graphqlUser(p graphql.ResolveParams) (string, error) {
+	user, _ := p.Args["userId"].(string)
+	if user == "" {
+		return callerFrom(p.Context).user, nil
+	}
+	if !userPattern.MatchString(user) {
+		return "", newMessage("\"%s\" is not a valid user id", user)
+	}
+	return user, nil
+}
+
+func graphqlFavourite(ctx context.Context, user string, t Tree) map[string]interface{} {
+	f := map[string]interface{}{"tree": callerFrom(ctx).lang.tree(t)}
+	if user != "" {
+		f["userId"] = user
+	}
+	return f
+}
+
+// graphqlPage returns the page of the catalogue selected by the query
+// parameters of GET /trees
+func (s *server) graphqlPage(ctx context.Context, values url.Values) (interface{}, error) {
+	q, errs := treeListSpec.parse(values)
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = fmt.Sprintf("%s %s", e.Field, callerFrom(ctx).lang.translate(e.msg))
+		}
+		return nil, newMessage("invalid list query: %s", strings.Join(msgs, ", "))
+	}
+	list, _, err := s.treePage(ctx, q)
+	if err != nil {
+		return nil, graphqlStoreError(err, "")
+	}
+	list = list.localize(callerFrom(ctx).lang).(treeList)
+	page := map[string]interface{}{"trees": list.Trees}
+	if list.NextCursor != "" {
+		page["nextCursor"] = list.NextCursor
+	}
+	if list.PrevCursor != "" {
+		page["prevCursor"] = list.PrevCursor
+	}
+	return page, nil
+}
+
+// graphqlTrees returns the trees of a family or region
+func (s *server) graphqlTrees(p graphql.ResolveParams, filter, value string) (interface{}, error) {
+	values := url.Values{filter: {value}, "limit": {strconv.Itoa(p.Args["first"].(int))}}
+	page, err := s.graphqlPage(p.Context, values)
+	if err != nil {
+		return nil, err
+	}
+	return page.(map[string]interface{})["trees"], nil
+}
+
+// graphqlGroups returns the sorted, distinct keys of the trees of the
+// catalogue as values of a graphql type
+func (s *server) graphqlGroups(ctx context.Context, keys func(Tree) []string,
+	value func(string) interface{}) (interface{}, error) {
+	trees, err := s.store(ctx).List()
+	if err != nil {
+		return nil, graphqlStoreError(err, "")
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, t := range trees {
+		for _, k := range keys(t) {
+			if k != "" && !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+	groups := make([]interface{}, len(names))
+	for i, n := range names {
+		groups[i] = value(n)
+	}
+	return groups, nil
+}
+
+// queryCost measures the depth and estimates the complexity of a query. Every
+// field costs one and the fields below a list count once per item. The first
+// argument of a page, like Query.trees, sizes the lists of the page.
+// Introspection is not charged, but its types nest without end, so its depth
+// is measured separately and limited to maxIntrospectionDepth.
+type queryCost struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+	// introspectionDepth is the deepest introspection field seen
+	introspectionDepth int
+}
+
+// maxIntrospectionDepth fits the introspection queries of tools like
+// GraphiQL, which unwrap type references seven levels deep
+const maxIntrospectionDepth = 13
+
+// metaFields are the introspection fields of the query type
+var metaFields = map[string]*graphql.FieldDefinition{
+	"__schema": graphql.SchemaMetaFieldDef,
+	"__type":   graphql.TypeMetaFieldDef,
+}
+
+// selectionSet returns the cost and depth of set. page is the size of the
+// page set belongs to, 0 outside of pages.
+func (c *queryCost) selectionSet(set *ast.SelectionSet, parent graphql.Type, depth, page int) (cost, maxDepth int) {
+	if set == nil {
+		return 0, depth
+	}
+	maxDepth = depth
+	add := func(childCost, childDepth int) {
+		cost += childCost
+		if childDepth > maxDepth {
+			maxDepth = childDepth
+		}
+	}
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name.Value, "__") {
+				if def, ok := metaFields[sel.Name.Value]; ok {
+					named, _ := unwrapType(def.Type)
+					if _, d := c.selectionSet(sel.SelectionSet, named, depth+1, 0); d > c.introspectionDepth {
+						c.introspectionDepth = d
+					}
+				}
+				continue
+			}
+			obj, ok := parent.(*graphql.Object)
+			if !ok {
+				continue
+			}
+			def, ok := obj.Fields()[sel.Name.Value]
+			if !ok {
+				continue
+			}
+			named, list := unwrapType(def.Type)
+			size := c.listSize(sel, def, page)
+			childPage := 0
+			if !list && hasFirstArg(def) {
+				childPage = size
+			}
+			childCost, childDepth := c.selectionSet(sel.SelectionSet, named, depth+1, childPage)
+			if list {
+				childCost *= size
+			}
+			add(1+childCost, childDepth)
+		case *ast.InlineFragment:
+			t := parent
+			if sel.TypeCondition != nil {
+				t = c.schema.Type(sel.TypeCondition.Name.Value)
+			}
+			add(c.selectionSet(sel.SelectionSet, t, depth, page))
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			f, ok := c.fragments[name]
+			if !ok || c.visiting[name] {
+				continue
+			}
+			c.visiting[name] = true
+			add(c.selectionSet(f.SelectionSet, c.schema.Type(f.TypeCondition.Name.Value), depth, page))
+			delete(c.visiting, name)
+		}
+	}
+	return cost, maxDepth
+}
+
+// listSize returns the number of items of a field. Fields with a first
+// argument return at most first items, which is clamped like the limit of GET
+// /trees so that invalid values can not lower the cost of a query. Other lists
+// are as long as the page they belong to.
+func (c *queryCost) listSize(f *ast.Field, def *graphql.FieldDefinition, page int) int {
+	if !hasFirstArg(def) {
+		if page > 0 {
+			return page
+		}
+		return defaultListCost
+	}
+	n := defaultPageSize
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if i, err := strconv.Atoi(v.Value); err == nil {
+				n = i
+			} else {
+				n = maxPageSize
+			}
+		case *ast.Variable:
+			// json numbers beyond the range of int are clamped before the conversion
+			if x, ok := c.variables[v.Name.Value].(float64); ok {
+				n = int(math.Max(math.Min(x, maxPageSize+1), 0))
+			}
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	if n > maxPageSize {
+		return maxPageSize
+	}
+	return n
+}
+
+func hasFirstArg(def *graphql.FieldDefinition) bool {
+	for _, arg := range def.Args {
+		if arg.Name() == "first" {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapType strips the non null and list wrappers of t and reports whether
+// it is a list
+func unwrapType(t graphql.Type) (graphql.Type, bool) {
+	list := false
+	for {
+		switch w := t.(type) {
+		case *graphql.NonNull:
+			t = w.OfType
+		case *graphql.List:
+			list = true
+			t = w.OfType
+		default:
+			return t, list
+		}
+	}
+}
+
+// checkCost rejects the operations of doc exceeding the depth or complexity
+// limits
+func (s *server) checkCost(doc *ast.Document, variables map[string]interface{}) error {
+	c := &queryCost{
+		schema:    &s.graphql,
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: variables,
+		visiting:  map[string]bool{},
+	}
+	for _, d := range doc.Definitions {
+		if f, ok := d.(*ast.FragmentDefinition); ok {
+			c.fragments[f.Name.Value] = f
+		}
+	}
+	for _, d := range doc.Definitions {
+		op, ok := d.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		root := graphql.Type(s.graphql.QueryType())
+		if op.Operation == ast.OperationTypeMutation {
+			root = s.graphql.MutationType()
+		}
+		cost, depth := c.selectionSet(op.SelectionSet, root, 0, 0)
+		if c.introspectionDepth > maxIntrospectionDepth {
+			return newMessage("the query is nested %d levels deep, at most %d are allowed",
+				c.introspectionDepth, maxIntrospectionDepth)
+		}
+		if depth > s.graphqlLimits.MaxDepth {
+			return newMessage("the query is nested %d levels deep, at most %d are allowed",
+				depth, s.graphqlLimits.MaxDepth)
+		}
+		if cost > s.graphqlLimits.MaxComplexity {
+			return newMessage("the query has a complexity of %d, at most %d is allowed",
+				cost, s.graphqlLimits.MaxComplexity)
+		}
+	}
+	return nil
+}
+
+// serveGraphQL executes graphql queries sent as POST with a json body or as
+// GET with query parameters. Mutations are only accepted with POST.
+func (s *server) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	lang := negotiateLanguage(r)
+	setLanguage(w, lang)
+	fail := func(status int, err error) {
+		writeJSON(w, status, graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(lang.translate(messageOf(err)))},
+		})
+	}
+
+	var req graphqlRequest
+	if r.Method == http.MethodPost {
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err := dec.Decode(&req); err != nil {
+			fail(http.StatusBadRequest, newMessage("invalid request body: %s", err))
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				fail(http.StatusBadRequest, newMessage("invalid variables: %s", err))
+				return
+			}
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		fail(http.StatusBadRequest, newMessage("the query is missing"))
+		return
+	}
+	user, err := userFromRequest(r)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+	if v := graphql.ValidateDocument(&s.graphql, doc, nil); !v.IsValid {
+		writeJSON(w, http.StatusBadRequest, graphql.Result{Errors: v.Errors})
+		return
+	}
+	if err := s.checkCost(doc, req.Variables); err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	if r.Method != http.MethodPost && isMutation(doc, req.OperationName) {
+		w.Header().Set("Allow", http.MethodPost)
+		fail(http.StatusMethodNotAllowed, newMessage("mutations must be sent with POST"))
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlCallerKey{}, graphqlCaller{user: user, lang: lang})
+	res := graphql.Execute(graphql.ExecuteParams{
+		Schema:        s.graphql,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+	for i, e := range res.Errors {
+		res.Errors[i].Message = lang.translate(graphqlMessage(e))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// graphqlMessage returns the message of an error returned by a resolver.
+// Errors of the graphql library are not translated.
+func graphqlMessage(e gqlerrors.FormattedError) *message {
+	err := e.OriginalError()
+	if located, ok := err.(*gqlerrors.Error); ok {
+		err = located.OriginalError
+	}
+	if m, ok := err.(*message); ok {
+		return m
+	}
+	return newMessage("%s", e.Message)
+}
+
+// isMutation reports whether the operation of doc that is executed is a
+// mutation
+func isMutation(doc *ast.Document, operationName string) bool {
+	for _, d := range doc.Definitions {
+		op, ok := d.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (op.Name != nil && op.Name.Value == operationName) {
+			if op.Operation == ast.OperationTypeMutation {
+				return true
+			}
+		}
+	}
+	return false
+}
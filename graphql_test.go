@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/testutil"
+)
+
+type graphqlResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func postGraphQL(t *testing.T, h http.Handler, query string, variables map[string]interface{},
+	headers map[string]string) (int, graphqlResponse) {
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	rec := record(h, http.MethodPost, "/graphql", string(body), withHeaders(headers))
+	var res graphqlResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response %q: %s", rec.Body.String(), err)
+	}
+	return rec.Code, res
+}
+
+// path returns the value at the dot separated path of v
+func path(v interface{}, p string) interface{} {
+	for _, key := range strings.Split(p, ".") {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			v = c[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(c) {
+				return nil
+			}
+			v = c[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+
+	status, res := postGraphQL(t, h, `{
+		tree(id: "baobab") { id species family { name trees { id } } nativeRegions { name } }
+		missing: tree(id: "oak") { id }
+		favourite { userId tree { id } }
+		families { name }
+	}`, nil, nil)
+	if status != http.StatusOK || len(res.Errors) > 0 {
+		t.Fatalf("unexpected response %d %+v", status, res)
+	}
+	for p, expected := range map[string]interface{}{
+		"tree.species":              "Baobab",
+		"tree.family.name":          "Malvaceae",
+		"tree.family.trees.0.id":    "baobab",
+		"tree.nativeRegions.0.name": "Africa",
+		"missing":                   nil,
+		"favourite.userId":          nil,
+		"favourite.tree.id":         "sequoia",
+		"families.0.name":           "Cupressaceae",
+	} {
+		if got := path(res.Data, p); got != expected {
+			t.Errorf("%s: expected %v, got %v", p, expected, got)
+		}
+	}
+
+	// pages are chained with their cursors
+	query := `query Page($after: String) { trees(first: 4, sort: "-maxHeightMetres", after: $after) { trees { id } nextCursor } }`
+	_, res = postGraphQL(t, h, query, nil, nil)
+	if ids := path(res.Data, "trees.trees").([]interface{}); len(ids) != 4 || path(res.Data, "trees.trees.0.id") != "sequoia" {
+		t.Errorf("unexpected first page %v", res.Data)
+	}
+	cursor := path(res.Data, "trees.nextCursor")
+	_, res = postGraphQL(t, h, query, map[string]interface{}{"after": cursor}, nil)
+	if ids := path(res.Data, "trees.trees").([]interface{}); len(ids) != len(defaultTrees)-4 {
+		t.Errorf("unexpected second page %v", res.Data)
+	}
+
+	_, res = postGraphQL(t, h, `{ trees(first: 1000) { trees { id } } }`, nil, nil)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "limit") {
+		t.Errorf("expected an invalid list query, got %+v", res)
+	}
+
+	// queries can be sent with GET
+	rec := record(h, http.MethodGet, "/graphql?query="+url.QueryEscape(`{ tree(id: "ginkgo") { species } }`), "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"species":"Ginkgo"`) {
+		t.Errorf("unexpected GET response %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGraphQLLocalisation(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+	_, res := postGraphQL(t, h, `{ tree(id: "english-oak") { species } }`, nil,
+		map[string]string{"Accept-Language": "de"})
+	if got := path(res.Data, "tree.species"); got != "Eiche" {
+		t.Errorf("expected the german name, got %v", got)
+	}
+}
+
+func TestGraphQLFavouriteMutation(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+	mutation := `mutation { setFavourite(treeId: "ginkgo") { userId tree { species } } }`
+
+	_, res := postGraphQL(t, h, mutation, nil, map[string]string{userHeader: "kim"})
+	if len(res.Errors) > 0 || path(res.Data, "setFavourite.userId") != "kim" {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	_, res = postGraphQL(t, h, `{ favourite(userId: "kim") { tree { id } } }`, nil, nil)
+	if got := path(res.Data, "favourite.tree.id"); got != "ginkgo" {
+		t.Errorf("expected the new favourite, got %v", got)
+	}
+
+	_, res = postGraphQL(t, h, `mutation { setFavourite(userId: "kim", treeId: "oak") { userId } }`, nil, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Message != `tree "oak" does not exist` {
+		t.Errorf("expected an unknown tree, got %+v", res)
+	}
+	_, res = postGraphQL(t, h, mutation, nil, nil)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "userId is required") {
+		t.Errorf("expected the user to be required, got %+v", res)
+	}
+
+	rec := record(h, http.MethodGet, "/graphql?query="+url.QueryEscape(mutation), "")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected mutations over GET to be refused, got %d", rec.Code)
+	}
+}
+
+func TestGraphQLMutationScope(t *testing.T) {
+	h := newTestServer(defaultTrees, func(cfg *config) {
+		cfg.Auth.APIKeys = []apiKeyConfig{
+			{Name: "reader", Hash: hashAPIKey("read-key"), Scopes: scopeTreesRead},
+			{Name: "kim", Hash: hashAPIKey("kim-key"), Scopes: scopeTreesRead + " " + scopeFavouritesWrite},
+		}
+	}).handler()
+	mutation := `mutation { setFavourite(treeId: "ginkgo") { userId } }`
+
+	_, res := postGraphQL(t, h, mutation, nil, map[string]string{apiKeyHeader: "read-key"})
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, scopeFavouritesWrite) {
+		t.Errorf("expected the scope to be required, got %+v", res)
+	}
+	_, res = postGraphQL(t, h, mutation, nil, map[string]string{apiKeyHeader: "kim-key"})
+	if len(res.Errors) > 0 || path(res.Data, "setFavourite.userId") != "kim" {
+		t.Errorf("expected the favourite of the api key to be set, got %+v", res)
+	}
+	_, res = postGraphQL(t, h, `mutation { setFavourite(userId: "jan", treeId: "ginkgo") { userId } }`, nil,
+		map[string]string{apiKeyHeader: "kim-key"})
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "another user") {
+		t.Errorf("expected the favourite of another user to be refused, got %+v", res)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+	for _, tc := range []struct {
+		query    string
+		expected string
+	}{
+		{`{ trees { trees { family { trees { family { trees { family { trees { id } } } } } } } } }`,
+			"nested 9 levels deep, at most 8"},
+		{`{ families { trees(first: 100) { nativeRegions { trees(first: 100) { id } } } } }`,
+			"complexity of"},
+		{`{ families { trees(first:100) { nativeRegions { trees(first:100) { id } } } } x: families { trees(first:-100000000) { id } } }`,
+			"complexity of"},
+		{`{ trees(first: 100) { trees { family { trees(first: 100) { id } } } } }`, "complexity of"},
+		{`query Deep { ...F } fragment F on Query { regions { trees(first: 100) { family { trees(first: 100) { id } } } } }`,
+			"complexity of"},
+		{`{ tree(id: "oak") { height } }`, `Cannot query field "height" on type "Tree"`},
+		{`{ tree(id: `, "Syntax Error"},
+		{``, "the query is missing"},
+	} {
+		status, res := postGraphQL(t, h, tc.query, nil, nil)
+		if status != http.StatusBadRequest || len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, tc.expected) {
+			t.Errorf("%s: expected a 400 containing %q, got %d %+v", tc.query, tc.expected, status, res)
+		}
+	}
+
+	// the standard introspection query of tools like GraphiQL passes
+	_, res := postGraphQL(t, h, testutil.IntrospectionQuery, nil, nil)
+	if len(res.Errors) > 0 || path(res.Data, "__schema.queryType.name") != "Query" {
+		t.Errorf("unexpected introspection response %+v", res)
+	}
+	// but introspection can not be nested without end
+	deep := "name"
+	for i := 0; i < 5; i++ {
+		deep = "fields { type { ofType { " + deep + " } } }"
+	}
+	status, res := postGraphQL(t, h, "{ __schema { types { "+deep+" } } }", nil, nil)
+	if status != http.StatusBadRequest || len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "nested") {
+		t.Errorf("expected a deeply nested introspection query to be refused, got %d %+v", status, res)
+	}
+}
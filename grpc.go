@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/floekkchen/ecosia_intro/treepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// treeSpotterService is the name of the gRPC service in tree.proto
+const treeSpotterService = "treespotter.v1.TreeSpotter"
+
+// watchBuffer is the number of changes a WatchTrees call may fall behind
+// before it is ended
+const watchBuffer = 64
+
+// grpcService implements treepb.TreeSpotterServer on top of the store of the
+// http api
+type grpcService struct {
+	s *server
+}
+
+// grpcServer returns the gRPC server with the tree-spotter, health and
+// reflection services. It serves plain text if tlsCfg is nil.
+func (s *server) grpcServer(tlsCfg *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := s.authenticateCall(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			ctx, err := s.authenticateCall(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, contextStream{ss, ctx})
+		}),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	g := grpc.NewServer(opts...)
+	treepb.RegisterTreeSpotterServer(g, grpcService{s})
+	healthpb.RegisterHealthServer(g, s.grpcHealth)
+	reflection.Register(g)
+	return g
+}
+
+// stopGRPC stops g once the calls in flight completed, or after timeout
+func stopGRPC(g *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		g.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		g.Stop()
+	}
+}
+
+// contextStream replaces the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticateCall authenticates calls to the tree-spotter service with the
+// api key or bearer token in their metadata, like the http api does with the
+// headers. All methods require the trees:read scope. The health and
+// reflection services are open to everyone.
+func (s *server) authenticateCall(ctx context.Context, method string) (context.Context, error) {
+	if s.auth == nil || !strings.HasPrefix(method, "/"+treeSpotterService+"/") {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	h := http.Header{}
+	for k, values := range md {
+		for _, v := range values {
+			h.Add(k, v)
+		}
+	}
+	p, err := s.auth.authenticate(h)
+	if err != nil && err != errNoCredentials {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !p.scopes[scopeTreesRead] {
+		if p.subject == "" {
+			return nil, status.Error(codes.Unauthenticated, "authentication is required")
+		}
+		return nil, status.Errorf(codes.PermissionDenied, "the scope %s is required", scopeTreesRead)
+	}
+	ctx = context.WithValue(ctx, principalKey{}, p)
+	if p.subject != "" {
+		ctx = withUser(ctx, p.subject)
+	}
+	return ctx, nil
+}
+
+// grpcStoreError converts the errors of the store to gRPC status errors
+func grpcStoreError(err error, id string) error {
+	switch err {
+	case errTreeNotFound:
+		return status.Errorf(codes.NotFound, "tree \"%s\" does not exist", id)
+	default:
+		log.Printf("tree store error: %s", err)
+		return status.Error(codes.Internal, "the tree store failed")
+	}
+}
+
+func treeToProto(t Tree) *treepb.Tree {
+	return &treepb.Tree{
+		Id:              t.ID,
+		Species:         t.Species,
+		CommonName:      t.CommonName,
+		ScientificName:  t.ScientificName,
+		Family:          t.Family,
+		NativeRegions:   t.NativeRegions,
+		MaxHeightMetres: t.MaxHeight,
+		LifespanYears:   int32(t.Lifespan),
+		Description:     t.Description,
+	}
+}
+
+var treeEventTypes = map[string]treepb.TreeEvent_Type{
+	treeCreated: treepb.TreeEvent_CREATED,
+	treeUpdated: treepb.TreeEvent_UPDATED,
+	treeDeleted: treepb.TreeEvent_DELETED,
+}
+
+func (g grpcService) GetFavouriteTree(ctx context.Context, req *treepb.GetFavouriteTreeRequest) (*treepb.Tree, error) {
+	user, _ := ctx.Value(userKey{}).(string)
+	if user == "" {
+		user = req.UserId
+		if user != "" && !userPattern.MatchString(user) {
+			return nil, status.Errorf(codes.InvalidArgument, "\"%s\" is not a valid user id", user)
+		}
+	}
+	t, err := g.s.favouriteTree(ctx, user)
+	if err == errTreeNotFound {
+		return nil, grpcStoreError(err, t.ID)
+	}
+	if err != nil {
+		log.Printf("tree store error looking up the favourite of user \"%s\": %s", user, err)
+		return nil, status.Error(codes.Internal, "the tree store failed")
+	}
+	return treeToProto(t), nil
+}
+
+func (g grpcService) ListTrees(ctx context.Context, req *treepb.ListTreesRequest) (*treepb.ListTreesResponse, error) {
+	// the request is translated to the query parameters of GET /trees
+	values := url.Values{}
+	for param, v := range map[string]string{
+		"cursor": req.PageToken,
+		"sort":   req.OrderBy,
+		"family": req.Family,
+		"region": req.Region,
+	} {
+		if v != "" {
+			values.Set(param, v)
+		}
+	}
+	if req.PageSize != 0 {
+		values.Set("limit", strconv.Itoa(int(req.PageSize)))
+	}
+	q, errs := treeListSpec.parse(values)
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = fmt.Sprintf("%s %s", e.Field, e.Message)
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "invalid list query: %s", strings.Join(msgs, ", "))
+	}
+	list, _, err := g.s.treePage(ctx, q)
+	if err != nil {
+		return nil, grpcStoreError(err, "")
+	}
+	res := &treepb.ListTreesResponse{NextPageToken: list.NextCursor}
+	for _, t := range list.Trees {
+		res.Trees = append(res.Trees, treeToProto(t))
+	}
+	return res, nil
+}
+
+func (g grpcService) GetTree(ctx context.Context, req *treepb.GetTreeRequest) (*treepb.Tree, error) {
+	t, err := g.s.store(ctx).Get(req.Id)
+	if err != nil {
+		return nil, grpcStoreError(err, req.Id)
+	}
+	return treeToProto(t), nil
+}
+
+func (g grpcService) WatchTrees(req *treepb.WatchTreesRequest, stream treepb.TreeSpotter_WatchTreesServer) error {
+	ctx := stream.Context()
+	if !g.s.openStream() {
+		return status.Error(codes.ResourceExhausted, "too many streams are open")
+	}
+	defer g.s.closeStream()
+	// subscribe before listing the catalogue so that no change is missed
+	events, cancel := g.s.events.subscribe(watchBuffer)
+	defer cancel()
+	if req.IncludeExisting {
+		trees, err := g.s.store(ctx).List()
+		if err != nil {
+			return grpcStoreError(err, "")
+		}
+		for _, t := range trees {
+			err := stream.Send(&treepb.TreeEvent{Type: treepb.TreeEvent_EXISTING, Tree: treeToProto(t)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-events:
+			if !ok && g.s.draining() {
+				return status.Error(codes.Unavailable, "the server is shutting down")
+			}
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "the watch fell behind the changes to the catalogue")
+			}
+			typ, catalogue := treeEventTypes[ev.Type]
+			if !catalogue {
+				// the favourites of the users are not watched
+				continue
+			}
+			err := stream.Send(&treepb.TreeEvent{Id: ev.ID, Type: typ, Tree: treeToProto(ev.Tree)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
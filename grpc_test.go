@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/floekkchen/ecosia_intro/treepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves the gRPC api of s in process and returns a connection to it
+func dialGRPC(t *testing.T, s *server) (*grpc.ClientConn, func()) {
+	l := bufconn.Listen(1 << 20)
+	g := s.grpcServer(nil)
+	go g.Serve(l)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.Dial()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		g.Stop()
+	}
+}
+
+func withTimeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+func TestGRPCLookups(t *testing.T) {
+	s := newTestServer(defaultTrees)
+	conn, stop := dialGRPC(t, s)
+	defer stop()
+	client := treepb.NewTreeSpotterClient(conn)
+	ctx, cancel := withTimeout()
+	defer cancel()
+
+	tree, err := client.GetFavouriteTree(ctx, &treepb.GetFavouriteTreeRequest{})
+	if err != nil || tree.Species != "Sequoia" {
+		t.Errorf("expected the default favourite, got %v (%v)", tree, err)
+	}
+	if err := s.trees.SetFavourite("kim", "ginkgo"); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = client.GetFavouriteTree(ctx, &treepb.GetFavouriteTreeRequest{UserId: "kim"})
+	if err != nil || tree.Id != "ginkgo" {
+		t.Errorf("expected the favourite of kim, got %v (%v)", tree, err)
+	}
+	_, err = client.GetFavouriteTree(ctx, &treepb.GetFavouriteTreeRequest{UserId: "not valid"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+
+	tree, err = client.GetTree(ctx, &treepb.GetTreeRequest{Id: "oak"})
+	if err == nil || status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v (%v)", tree, err)
+	}
+	tree, err = client.GetTree(ctx, &treepb.GetTreeRequest{Id: "baobab"})
+	if err != nil || tree.ScientificName != "Adansonia digitata" || len(tree.NativeRegions) == 0 {
+		t.Errorf("unexpected tree %v (%v)", tree, err)
+	}
+
+	var ids []string
+	req := &treepb.ListTreesRequest{PageSize: 2, OrderBy: "-maxHeightMetres"}
+	for {
+		res, err := client.ListTrees(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, t := range res.Trees {
+			ids = append(ids, t.Id)
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+	if len(ids) != len(defaultTrees) || ids[0] != "sequoia" {
+		t.Errorf("unexpected pages %v", ids)
+	}
+	_, err = client.ListTrees(ctx, &treepb.ListTreesRequest{PageSize: 1000})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
+
+// brokenFavourites is a store failing to look up favourites
+type brokenFavourites struct {
+	TreeStore
+}
+
+func (brokenFavourites) Favourite(string) (string, error) {
+	return "", errors.New("the disk is gone")
+}
+
+func TestGRPCFavouriteStoreError(t *testing.T) {
+	s := newTestServer(defaultTrees)
+	s.trees = brokenFavourites{s.trees}
+	conn, stop := dialGRPC(t, s)
+	defer stop()
+	ctx, cancel := withTimeout()
+	defer cancel()
+
+	_, err := treepb.NewTreeSpotterClient(conn).GetFavouriteTree(ctx, &treepb.GetFavouriteTreeRequest{UserId: "kim"})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected Internal, got %v", err)
+	}
+}
+
+func TestGRPCWatchTrees(t *testing.T) {
+	s := newTestServer(defaultTrees)
+	conn, stop := dialGRPC(t, s)
+	defer stop()
+	ctx, cancel := withTimeout()
+	defer cancel()
+
+	stream, err := treepb.NewTreeSpotterClient(conn).WatchTrees(ctx, &treepb.WatchTreesRequest{IncludeExisting: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range defaultTrees {
+		ev, err := stream.Recv()
+		if err != nil || ev.Type != treepb.TreeEvent_EXISTING {
+			t.Fatalf("expected the existing trees first, got %v (%v)", ev, err)
+		}
+	}
+
+	rowan := Tree{ID: "rowan", Species: "Rowan", ScientificName: "Sorbus aucuparia", Family: "Rosaceae"}
+	if err := s.trees.Create(rowan); err != nil {
+		t.Fatal(err)
+	}
+	rowan.Description = "Mountain ash"
+	if _, err := s.trees.Put(rowan); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.trees.Delete("rowan"); err != nil {
+		t.Fatal(err)
+	}
+	var last uint64
+	for _, expected := range []treepb.TreeEvent_Type{treepb.TreeEvent_CREATED, treepb.TreeEvent_UPDATED, treepb.TreeEvent_DELETED} {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != expected || ev.Tree.Id != "rowan" || ev.Id <= last {
+			t.Errorf("expected a %s event, got %v", expected, ev)
+		}
+		last = ev.Id
+	}
+
+	s.startDraining()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the stream to end on shutdown, got %v", err)
+	}
+}
+
+func TestGRPCWatchSharesStreamLimit(t *testing.T) {
+	s, srv := startStreamServer(func(cfg *config) { cfg.Stream.MaxSubscribers = 1 })
+	defer srv.Close()
+	conn, stop := dialGRPC(t, s)
+	defer stop()
+	ctx, cancel := withTimeout()
+	defer cancel()
+
+	res, _ := openStream(t, srv.URL+"/tree/stream", nil)
+	defer res.Body.Close()
+	stream, err := treepb.NewTreeSpotterClient(conn).WatchTrees(ctx, &treepb.WatchTreesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the watch to count against the stream limit, got %v", err)
+	}
+}
+
+func TestGRPCHealthAndReflection(t *testing.T) {
+	s := newTestServer(defaultTrees)
+	conn, stop := dialGRPC(t, s)
+	defer stop()
+	ctx, cancel := withTimeout()
+	defer cancel()
+
+	health := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", treeSpotterService} {
+		res, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("%q: expected SERVING, got %v (%v)", service, res, err)
+		}
+	}
+	s.startDraining()
+	res, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: treeSpotterService})
+	if err != nil || res.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected NOT_SERVING while draining, got %v (%v)", res, err)
+	}
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, svc := range reply.GetListServicesResponse().GetService() {
+		found = found || svc.Name == treeSpotterService
+	}
+	if !found {
+		t.Errorf("expected %s to be listed, got %v", treeSpotterService, reply)
+	}
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	s := newTestServer(defaultTrees, func(cfg *config) {
+		cfg.Auth.AnonymousScopes = ""
+		cfg.Auth.APIKeys = []apiKeyConfig{
+			{Name: "reader", Hash: hashAPIKey("read-key"), Scopes: scopeTreesRead},
+			{Name: "writer", Hash: hashAPIKey("write-key"), Scopes: scopeTreesWrite},
+		}
+	})
+	if err := s.trees.SetFavourite("reader", "european-beech"); err != nil {
+		t.Fatal(err)
+	}
+	conn, stop := dialGRPC(t, s)
+	defer stop()
+	client := treepb.NewTreeSpotterClient(conn)
+	ctx, cancel := withTimeout()
+	defer cancel()
+
+	for key, expected := range map[string]codes.Code{
+		"":          codes.Unauthenticated,
+		"guess":     codes.Unauthenticated,
+		"write-key": codes.PermissionDenied,
+		"read-key":  codes.OK,
+	} {
+		callCtx := ctx
+		if key != "" {
+			callCtx = metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+		}
+		tree, err := client.GetFavouriteTree(callCtx, &treepb.GetFavouriteTreeRequest{UserId: "kim"})
+		if status.Code(err) != expected {
+			t.Errorf("%q: expected %s, got %v", key, expected, err)
+		}
+		// the api key takes precedence over the user id of the request
+		if err == nil && tree.Id != "european-beech" {
+			t.Errorf("%q: expected the favourite of the api key, got %v", key, tree)
+		}
+	}
+	// the health service stays open
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("expected the health service to be open, got %v", err)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// maxBodyBytes limits the size of request bodies the handlers will decode
+const maxBodyBytes = 1 << 20
+
+type treeList struct {
+	XMLName    xml.Name `json:"-" yaml:"-" xml:"trees"`
+	Trees      []Tree   `json:"trees" yaml:"trees"`
+	NextCursor string   `json:"nextCursor,omitempty" yaml:"nextCursor,omitempty" xml:"nextCursor,omitempty"`
+	PrevCursor string   `json:"prevCursor,omitempty" yaml:"prevCursor,omitempty" xml:"prevCursor,omitempty"`
+}
+
+func (l treeList) plainText() string {
+	lines := make([]string, len(l.Trees))
+	for i, t := range l.Trees {
+		lines[i] = t.plainText()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// streamJSON writes the same json as encoding/json, one tree at a time
+func (l treeList) streamJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"trees":[`)
+	for i, t := range l.Trees {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		b, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		bw.Write(b)
+	}
+	bw.WriteByte(']')
+	for _, c := range []struct{ name, value string }{{"nextCursor", l.NextCursor}, {"prevCursor", l.PrevCursor}} {
+		if c.value != "" {
+			b, _ := json.Marshal(c.value)
+			bw.WriteString(`,"` + c.name + `":`)
+			bw.Write(b)
+		}
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+func (l treeList) csvRecords() [][]string {
+	records := [][]string{{"id", "species", "commonName", "scientificName", "family",
+		"nativeRegions", "maxHeightMetres", "lifespanYears", "description"}}
+	for _, t := range l.Trees {
+		records = append(records, []string{t.ID, t.Species, t.CommonName, t.ScientificName, t.Family,
+			strings.Join(t.NativeRegions, ";"),
+			strconv.FormatFloat(t.MaxHeight, 'f', -1, 64),
+			strconv.Itoa(t.Lifespan), t.Description})
+	}
+	return records
+}
+
+// treePatch holds the fields of a PATCH request. Fields that are nil are left
+// unchanged.
+type treePatch struct {
+	ID             *string   `json:"id"`
+	Species        *string   `json:"species"`
+	CommonName     *string   `json:"commonName"`
+	ScientificName *string   `json:"scientificName"`
+	Family         *string   `json:"family"`
+	NativeRegions  *[]string `json:"nativeRegions"`
+	MaxHeight      *float64  `json:"maxHeightMetres"`
+	Lifespan       *int      `json:"lifespanYears"`
+	Description    *string   `json:"description"`
+}
+
+func (p treePatch) apply(t *Tree) {
+	if p.Species != nil {
+		t.Species = *p.Species
+	}
+	if p.CommonName != nil {
+		t.CommonName = *p.CommonName
+	}
+	if p.ScientificName != nil {
+		t.ScientificName = *p.ScientificName
+	}
+	if p.Family != nil {
+		t.Family = *p.Family
+	}
+	if p.NativeRegions != nil {
+		t.NativeRegions = *p.NativeRegions
+	}
+	if p.MaxHeight != nil {
+		t.MaxHeight = *p.MaxHeight
+	}
+	if p.Lifespan != nil {
+		t.Lifespan = *p.Lifespan
+	}
+	if p.Description != nil {
+		t.Description = *p.Description
+	}
+}
+
+// validationError carries field errors out of a catalogue update
+type validationError []fieldError
+
+func (e validationError) Error() string {
+	return fmt.Sprintf("%d invalid fields", len(e))
+}
+
+// server bundles the state the http handlers operate on
+type server struct {
+	trees            TreeStore
+	defaultFavourite string
+	live             *healthChecks
+	ready            *healthChecks
+	metrics          *metricsRegistry
+	httpMetrics      *httpMetrics
+	tracer           *tracer
+	index            *searchIndex
+	events           *treeEvents
+	cacheControl     string
+	compression      compressionConfig
+	auth             *authenticator
+	limiter          *rateLimiter
+	cors             *corsPolicy
+	grpcHealth       *health.Server
+	graphql          graphql.Schema
+	graphqlLimits    graphqlConfig
+	streaming        streamConfig
+	streams          *gauge
+	webhooks         *webhooks
+
+	// writes serialises the changes to the catalogue so that the
+	// preconditions of a write still hold when it is performed
+	writes sync.Mutex
+
+	// drain is set to 1 once the server shuts down
+	drain int32
+}
+
+// newServer creates a server on top of the given store and indexes its trees
+// for search
+func newServer(trees TreeStore, cfg config) (*server, error) {
+	indexed, err := newIndexedStore(trees)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	cors, err := newCORSPolicy(cfg.CORS)
+	if err != nil {
+		return nil, err
+	}
+	events := newTreeEvents()
+	s := &server{
+		trees:            publishingStore{indexed, events},
+		index:            indexed.index,
+		events:           events,
+		cacheControl:     cfg.CacheControl,
+		compression:      cfg.Compression,
+		auth:             auth,
+		cors:             cors,
+		graphqlLimits:    cfg.GraphQL,
+		streaming:        cfg.Stream,
+		streams:          newGauge(metricsNamespace+"open_streams", "Number of open live update streams."),
+		defaultFavourite: cfg.DefaultTree,
+		live:             newHealthChecks(time.Duration(cfg.HealthCheckTimeout)),
+		ready:            newHealthChecks(time.Duration(cfg.HealthCheckTimeout)),
+		grpcHealth:       health.NewServer(),
+		metrics:          newMetricsRegistry(),
+		tracer: newTracer(
+			newSpanExporter(cfg.Tracing.Exporter, cfg.Tracing.Endpoint, os.Stdout),
+			cfg.Tracing.SampleRatio),
+	}
+	if s.graphql, err = s.graphqlSchema(); err != nil {
+		return nil, err
+	}
+	s.registerHealthChecks()
+	s.grpcHealth.SetServingStatus(treeSpotterService, healthpb.HealthCheckResponse_SERVING)
+	s.httpMetrics = newHTTPMetrics(s.metrics)
+	if s.limiter, err = newRateLimiter(cfg.RateLimit, s.metrics); err != nil {
+		return nil, err
+	}
+	s.metrics.register(s.streams)
+	s.webhooks = newWebhooks(cfg.Webhooks, s.metrics)
+	s.webhooks.start(s.events)
+	s.metrics.register(buildInfo())
+	s.metrics.register(goRuntime())
+	return s, nil
+}
+
+// routes returns the handler serving the complete api
+func (s *server) routes() http.Handler {
+	return s.router()
+}
+
+// apiRoutes returns the rate limited routes of the api, e.g. "GET /tree"
+func apiRoutes() map[string]bool {
+	return (&server{}).router().limited
+}
+
+func (s *server) router() *router {
+	rt := newRouter()
+	// handle registers a route of the api, which is subject to rate limiting
+	handle := rt.HandleLimited
+	read := func(h http.HandlerFunc) http.HandlerFunc {
+		return s.scoped(scopeTreesRead, s.cacheable(h))
+	}
+	handle(http.MethodGet, "/tree", read(s.handleFavourite))
+	handle(http.MethodGet, "/tree/stream", s.scoped(scopeTreesRead, s.streamFavourite))
+	handle(http.MethodGet, "/tree/ws", s.scoped(scopeTreesRead, s.streamFavouriteWebSocket))
+	handle(http.MethodGet, "/trees", read(s.listTrees))
+	handle(http.MethodPost, "/trees", s.scoped(scopeTreesWrite, s.createTree))
+	handle(http.MethodGet, "/trees/search", read(s.searchTrees))
+	handle(http.MethodGet, "/trees/{id}", read(s.getTree))
+	handle(http.MethodPut, "/trees/{id}", s.scoped(scopeTreesWrite, s.putTree))
+	handle(http.MethodPatch, "/trees/{id}", s.scoped(scopeTreesWrite, s.patchTree))
+	handle(http.MethodDelete, "/trees/{id}", s.scoped(scopeTreesWrite, s.deleteTree))
+	handle(http.MethodGet, "/users/{id}/favourite-tree", read(s.getUserFavourite))
+	handle(http.MethodPut, "/users/{id}/favourite-tree", s.scoped(scopeFavouritesWrite, s.putUserFavourite))
+	handle(http.MethodPost, "/webhooks", s.managed(s.createWebhook))
+	handle(http.MethodGet, "/webhooks", s.managed(s.listWebhooks))
+	handle(http.MethodGet, "/webhooks/dead-letters", s.managed(s.listDeadLetters))
+	handle(http.MethodGet, "/webhooks/{id}", s.managed(s.getWebhook))
+	handle(http.MethodDelete, "/webhooks/{id}", s.managed(s.deleteWebhook))
+	handle(http.MethodGet, "/webhooks/{id}/deliveries", s.managed(s.listDeliveries))
+	// mutations check the favourites:write scope themselves
+	handle(http.MethodGet, "/graphql", s.scoped(scopeTreesRead, s.serveGraphQL))
+	handle(http.MethodPost, "/graphql", s.scoped(scopeTreesRead, s.serveGraphQL))
+
+	// The /livez endpoint is added so that kubernetes can evaluate if the pod
+	// needs restarting, /readyz tells it whether the pod should receive traffic.
+	// /healthz is kept for clients of the former single health endpoint.
+	rt.HandleFunc(http.MethodGet, "/livez", s.live.handler)
+	rt.HandleFunc(http.MethodGet, "/livez/{check}", s.live.checkHandler)
+	rt.HandleFunc(http.MethodGet, "/readyz", s.ready.handler)
+	rt.HandleFunc(http.MethodGet, "/readyz/{check}", s.ready.checkHandler)
+	rt.HandleFunc(http.MethodGet, "/healthz", s.ready.handler)
+	rt.HandleFunc(http.MethodGet, "/metrics", s.metrics.handler)
+	return rt
+}
+
+func (s *server) handleFavourite(w http.ResponseWriter, r *http.Request) {
+	user, err := userFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, messageOf(err))
+		return
+	}
+	t, err := s.favouriteTree(r.Context(), user)
+	if err != nil {
+		writeStoreError(w, r, err, t.ID)
+		return
+	}
+	setLastModified(w, s.trees.LastModified())
+	respond(w, r, http.StatusOK, resp{MyFavouriteTree: t.Species})
+}
+
+func (s *server) listTrees(w http.ResponseWriter, r *http.Request) {
+	q, errs := treeListSpec.parse(r.URL.Query())
+	if len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, newMessage("invalid list query"), errs...)
+		return
+	}
+	list, p, err := s.treePage(r.Context(), q)
+	if err != nil {
+		writeStoreError(w, r, err, "")
+		return
+	}
+	writeLinks(w, r, p)
+	setLastModified(w, s.trees.LastModified())
+	respond(w, r, http.StatusOK, list)
+}
+
+// treePage returns the page of the catalogue selected by q
+func (s *server) treePage(ctx context.Context, q listQuery) (treeList, page, error) {
+	trees, err := s.store(ctx).List()
+	if err != nil {
+		return treeList{}, page{}, err
+	}
+	items := make([]interface{}, len(trees))
+	for i, t := range trees {
+		items[i] = t
+	}
+	p := treeListSpec.apply(items, q)
+
+	list := treeList{Trees: []Tree{}, NextCursor: p.nextCursor, PrevCursor: p.prevCursor}
+	for _, item := range p.items {
+		list.Trees = append(list.Trees, item.(Tree))
+	}
+	return list, p, nil
+}
+
+func (s *server) getTree(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	t, err := s.store(r.Context()).Get(id)
+	if err != nil {
+		writeStoreError(w, r, err, id)
+		return
+	}
+	setLastModified(w, lastModified(t, s.trees))
+	respond(w, r, http.StatusOK, t)
+}
+
+func (s *server) createTree(w http.ResponseWriter, r *http.Request) {
+	var t Tree
+	if !decodeBody(w, r, &t) {
+		return
+	}
+	if errs := validateTree(t); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	t.Modified = modificationTime()
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	if err := s.store(r.Context()).Create(t); err != nil {
+		writeStoreError(w, r, err, t.ID)
+		return
+	}
+	w.Header().Set("Location", "/trees/"+t.ID)
+	setLastModified(w, *t.Modified)
+	respond(w, r, http.StatusCreated, t)
+}
+
+func (s *server) putTree(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	var t Tree
+	if !decodeBody(w, r, &t) {
+		return
+	}
+	if t.ID == "" {
+		t.ID = id
+	}
+	if t.ID != id {
+		writeValidationError(w, r, []fieldError{newFieldError("id", "must match the id in the path")})
+		return
+	}
+	if errs := validateTree(t); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	t.Modified = modificationTime()
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	if !s.preconditionsHold(w, r, id) {
+		return
+	}
+	created, err := s.store(r.Context()).Put(t)
+	if err != nil {
+		writeStoreError(w, r, err, id)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	setLastModified(w, *t.Modified)
+	respond(w, r, status, t)
+}
+
+func (s *server) patchTree(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	var p treePatch
+	if !decodeBody(w, r, &p) {
+		return
+	}
+	if p.ID != nil && *p.ID != id {
+		writeValidationError(w, r, []fieldError{newFieldError("id", "can not be changed")})
+		return
+	}
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	if !s.preconditionsHold(w, r, id) {
+		return
+	}
+	t, err := s.store(r.Context()).Update(id, func(t *Tree) error {
+		p.apply(t)
+		if errs := validateTree(*t); len(errs) > 0 {
+			return validationError(errs)
+		}
+		t.Modified = modificationTime()
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, r, err, id)
+		return
+	}
+	setLastModified(w, *t.Modified)
+	respond(w, r, http.StatusOK, t)
+}
+
+func (s *server) deleteTree(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	if !s.preconditionsHold(w, r, id) {
+		return
+	}
+	if err := s.store(r.Context()).Delete(id); err != nil {
+		writeStoreError(w, r, err, id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeBody decodes the json request body into v. Unknown fields are rejected.
+// On failure an error response is written and false is returned.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest,
+			newMessage("invalid request body: %s", err))
+		return false
+	}
+	return true
+}
+
+// writeStoreError maps an error returned by the TreeStore to a response
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error, id string) {
+	if errs, ok := err.(validationError); ok {
+		writeValidationError(w, r, errs)
+		return
+	}
+	switch err {
+	case errTreeNotFound:
+		writeNotFound(w, r, "tree", id)
+	case errTreeExists:
+		writeError(w, r, http.StatusConflict, codeConflict,
+			newMessage("tree \"%s\" already exists", id))
+	default:
+		log.Printf("tree store error: %s", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, newMessage("the tree store failed"))
+	}
+}
+
+func writeValidationError(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	writeError(w, r, http.StatusUnprocessableEntity, codeValidation, newMessage("the tree is invalid"), errs...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to encode response: %s", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger = newJSONLogger(ioutil.Discard, levelError)
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestFavouriteIsBackwardCompatible(t *testing.T) {
+	rec := record(newTestServer(defaultTrees).routes(), http.MethodGet, "/tree", "")
+
+	if !strings.Contains(rec.Body.String(), "{\"myFavouriteTree\":\"Sequoia\"}") {
+		t.Fatalf("unexpected response %s", rec.Body.String())
+	}
+}
+
+func TestGetTrees(t *testing.T) {
+	rec := record(newTestServer(defaultTrees).routes(), http.MethodGet, "/trees", "")
+
+	var list treeList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Trees) != len(defaultTrees) {
+		t.Fatalf("expected %d trees, got %d", len(defaultTrees), len(list.Trees))
+	}
+}
+
+func TestGetTree(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+	for path, status := range map[string]int{
+		"/trees/sequoia":     http.StatusOK,
+		"/trees/unknown":     http.StatusNotFound,
+		"/trees/sequoia/foo": http.StatusNotFound,
+	} {
+		rec := record(h, http.MethodGet, path, "")
+		if rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, rec.Code)
+		}
+	}
+}
+
+func TestTreeCRUD(t *testing.T) {
+	h := newTestServer(nil).routes()
+	linden := `{"id":"linden","species":"Linden","scientificName":"Tilia cordata",` +
+		`"family":"Malvaceae","nativeRegions":["Europe"],"maxHeightMetres":30,"lifespanYears":500}`
+
+	steps := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPost, "/trees", linden, http.StatusCreated},
+		{http.MethodPost, "/trees", linden, http.StatusConflict},
+		{http.MethodPost, "/trees", `{"id":"Not Valid"}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/trees", `{"id":`, http.StatusBadRequest},
+		{http.MethodPatch, "/trees/linden", `{"commonName":"Small-leaved lime"}`, http.StatusOK},
+		{http.MethodPatch, "/trees/linden", `{"nativeRegions":["Atlantis"]}`, http.StatusUnprocessableEntity},
+		{http.MethodPatch, "/trees/missing", `{"commonName":"x"}`, http.StatusNotFound},
+		{http.MethodPut, "/trees/linden", strings.Replace(linden, "30", "35", 1), http.StatusOK},
+		{http.MethodPut, "/trees/other", linden, http.StatusUnprocessableEntity},
+		{http.MethodDelete, "/trees/linden", "", http.StatusNoContent},
+		{http.MethodDelete, "/trees/linden", "", http.StatusNotFound},
+		{http.MethodPut, "/trees/linden", linden, http.StatusCreated},
+		{http.MethodPost, "/trees/linden", linden, http.StatusMethodNotAllowed},
+	}
+	for _, step := range steps {
+		rec := record(h, step.method, step.path, step.body)
+		if rec.Code != step.status {
+			t.Fatalf("%s %s: expected status %d, got %d: %s",
+				step.method, step.path, step.status, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestValidationErrorBody(t *testing.T) {
+	rec := record(newTestServer(nil).routes(),
+		http.MethodPost, "/trees", `{"id":"x","lifespanYears":-1}`)
+
+	var body errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != codeValidation {
+		t.Fatalf("expected code %s, got %s", codeValidation, body.Error.Code)
+	}
+	fields := map[string]bool{}
+	for _, d := range body.Error.Details {
+		fields[d.Field] = true
+	}
+	for _, f := range []string{"species", "scientificName", "family", "lifespanYears"} {
+		if !fields[f] {
+			t.Errorf("expected an error for field %s, got %v", f, body.Error.Details)
+		}
+	}
+}
+
+// requestOption changes a request made by record
+type requestOption func(r *http.Request)
+
+func withHeaders(headers map[string]string) requestOption {
+	return func(r *http.Request) {
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+	}
+}
+
+// from sets the address the request comes from
+func from(remoteAddr string) requestOption {
+	return func(r *http.Request) {
+		r.RemoteAddr = remoteAddr
+	}
+}
+
+func record(h http.Handler, method, path, body string, opts ...requestOption) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for _, opt := range opts {
+		opt(req)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// testConfig returns the default config changed by configure
+func testConfig(configure ...func(cfg *config)) config {
+	cfg := defaultConfig()
+	for _, c := range configure {
+		c(&cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// newTestServer returns a server of trees with the default config changed by
+// configure
+func newTestServer(trees []Tree, configure ...func(cfg *config)) *server {
+	s, err := newServer(newMemoryStore(trees), testConfig(configure...))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthChecks is a registry of named checks served in the style of the
+// kubernetes api server: the endpoint answers "ok" if all checks pass,
+// ?verbose reports every check as json, ?exclude=<name> skips a check and
+// <endpoint>/<name> runs a single check.
+type healthChecks struct {
+	timeout time.Duration
+	checks  []healthCheck
+}
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+type healthStatus struct {
+	Status string        `json:"status"`
+	Checks []checkStatus `json:"checks"`
+}
+
+type checkStatus struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+const (
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+func newHealthChecks(timeout time.Duration) *healthChecks {
+	return &healthChecks{timeout: timeout}
+}
+
+// add registers a check. check must return once ctx is done, otherwise it is
+// abandoned and reported as timed out.
+func (h *healthChecks) add(name string, check func(ctx context.Context) error) {
+	h.checks = append(h.checks, healthCheck{name, check})
+}
+
+// run executes all checks not in exclude concurrently
+func (h *healthChecks) run(ctx context.Context, exclude map[string]bool) healthStatus {
+	result := healthStatus{Status: statusOK, Checks: []checkStatus{}}
+	statuses := make([]*checkStatus, len(h.checks))
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		if exclude[c.name] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c healthCheck) {
+			defer wg.Done()
+			statuses[i] = h.runCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, s := range statuses {
+		if s == nil {
+			continue
+		}
+		if s.Status != statusOK {
+			result.Status = statusFailed
+		}
+		result.Checks = append(result.Checks, *s)
+	}
+	return result
+}
+
+func (h *healthChecks) runCheck(ctx context.Context, c healthCheck) *checkStatus {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", h.timeout)
+	}
+	s := &checkStatus{Name: c.name, Status: statusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		s.Status = statusFailed
+		s.Error = err.Error()
+	}
+	return s
+}
+
+// handler serves the result of all checks
+func (h *healthChecks) handler(w http.ResponseWriter, r *http.Request) {
+	exclude := map[string]bool{}
+	for _, names := range r.URL.Query()["exclude"] {
+		for _, name := range strings.Split(names, ",") {
+			exclude[name] = true
+		}
+	}
+	h.write(w, r, h.run(r.Context(), exclude))
+}
+
+// checkHandler serves the result of the check named in the path
+func (h *healthChecks) checkHandler(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "check")
+	for _, c := range h.checks {
+		if c.name == name {
+			s := h.runCheck(r.Context(), c)
+			h.write(w, r, healthStatus{Status: s.Status, Checks: []checkStatus{*s}})
+			return
+		}
+	}
+	writeNotFound(w, r, "health check", name)
+}
+
+func (h *healthChecks) write(w http.ResponseWriter, r *http.Request, s healthStatus) {
+	status := http.StatusOK
+	if s.Status != statusOK {
+		status = http.StatusServiceUnavailable
+	}
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		writeJSON(w, status, s)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(s.Status))
+}
+
+// registerHealthChecks sets up the checks served on /livez and /readyz
+func (s *server) registerHealthChecks() {
+	s.live.add("ping", func(context.Context) error {
+		return nil
+	})
+
+	s.ready.add("store", func(context.Context) error {
+		return s.trees.Ping()
+	})
+	// an empty catalogue is valid, e.g. after all trees were deleted
+	s.ready.add("catalogue", func(context.Context) error {
+		_, err := s.trees.List()
+		return err
+	})
+	s.ready.add("shutdown", func(context.Context) error {
+		if s.draining() {
+			return fmt.Errorf("the server is shutting down")
+		}
+		return nil
+	})
+}
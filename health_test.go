@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthChecks(t *testing.T) {
+	h := newHealthChecks(50 * time.Millisecond)
+	h.add("good", func(context.Context) error { return nil })
+	h.add("bad", func(context.Context) error { return errors.New("broken") })
+	h.add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+		return nil
+	})
+	rt := newRouter()
+	rt.HandleFunc(http.MethodGet, "/readyz", h.handler)
+	rt.HandleFunc(http.MethodGet, "/readyz/{check}", h.checkHandler)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/readyz", http.StatusServiceUnavailable, "failed"},
+		{"/readyz?exclude=bad&exclude=slow", http.StatusOK, "ok"},
+		{"/readyz?exclude=bad,slow", http.StatusOK, "ok"},
+		{"/readyz/good", http.StatusOK, "ok"},
+		{"/readyz/slow", http.StatusServiceUnavailable, "failed"},
+	} {
+		rec := record(rt, http.MethodGet, tt.path, "")
+		if rec.Code != tt.status || rec.Body.String() != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.path, tt.status, tt.body, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := record(rt, http.MethodGet, "/readyz/unknown", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected unknown checks to be 404, got %d", rec.Code)
+	}
+
+	rec := record(rt, http.MethodGet, "/readyz?verbose", "")
+	var status healthStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	errs := map[string]string{}
+	for _, c := range status.Checks {
+		errs[c.Name] = c.Error
+	}
+	if len(errs) != 3 || errs["good"] != "" || errs["bad"] != "broken" || errs["slow"] != "timed out after 50ms" {
+		t.Errorf("unexpected verbose status %+v", status)
+	}
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	s := newTestServer(defaultTrees)
+	h := s.routes()
+	if rec := record(h, http.MethodGet, "/readyz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected the server to be ready, got %d", rec.Code)
+	}
+	s.startDraining()
+	if rec := record(h, http.MethodGet, "/readyz", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the server not to be ready while draining, got %d", rec.Code)
+	}
+	if rec := record(h, http.MethodGet, "/livez", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected the server to stay alive while draining, got %d", rec.Code)
+	}
+}
+
+func TestReadyWithAnEmptyCatalogue(t *testing.T) {
+	h := newTestServer(nil).routes()
+	if rec := record(h, http.MethodGet, "/readyz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected the server to be ready without trees, got %d %s", rec.Code, rec.Body)
+	}
+}
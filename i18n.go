@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultLanguage is used if the client accepts none of the supported
+// languages. Messages and tree names are written in it.
+const defaultLanguage = "en"
+
+// catalogue holds the translations of one language
+type catalogue struct {
+	// messages maps the ids of messages, their english format, to their
+	// translation. Translations use %s for every argument, or %[n]s if they
+	// reorder them.
+	messages map[string]string
+	// names maps english tree names to their translation
+	names map[string]string
+}
+
+// language is a supported language with the chain of languages it falls back
+// to for missing translations, e.g. en-US falls back to en
+type language struct {
+	tag       string
+	catalogue catalogue
+	parent    *language
+}
+
+// languages maps lower case language tags to the supported languages
+var languages = buildLanguages(catalogues)
+
+func buildLanguages(catalogues map[string]catalogue) map[string]*language {
+	langs := map[string]*language{}
+	for tag, c := range catalogues {
+		langs[strings.ToLower(tag)] = &language{tag: tag, catalogue: c}
+	}
+	for tag, l := range langs {
+		if i := strings.LastIndex(tag, "-"); i >= 0 {
+			l.parent = lookupLanguage(langs, tag[:i])
+		} else if tag != defaultLanguage {
+			l.parent = langs[defaultLanguage]
+		}
+	}
+	return langs
+}
+
+// lookupLanguage returns the language for tag, stripping subtags from the end
+// until a supported language is found. It returns nil if there is none.
+func lookupLanguage(langs map[string]*language, tag string) *language {
+	tag = strings.ToLower(tag)
+	for {
+		if l, ok := langs[tag]; ok {
+			return l
+		}
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			return nil
+		}
+		tag = tag[:i]
+	}
+}
+
+// negotiateLanguage selects the language of the response from the ?lang=
+// parameter or the Accept-Language header of r
+func negotiateLanguage(r *http.Request) *language {
+	if tag := r.URL.Query().Get("lang"); tag != "" {
+		if l := lookupLanguage(languages, tag); l != nil {
+			return l
+		}
+	}
+	// language ranges have the same syntax as media ranges
+	for _, lr := range parseAccept(r.Header.Get("Accept-Language")) {
+		if lr.q == 0 || lr.mediaType == "*" {
+			break
+		}
+		if l := lookupLanguage(languages, lr.mediaType); l != nil {
+			return l
+		}
+	}
+	return languages[defaultLanguage]
+}
+
+// setLanguage announces the language of the response
+func setLanguage(w http.ResponseWriter, l *language) {
+	addVary(w.Header(), "Accept-Language")
+	w.Header().Set("Content-Language", l.tag)
+}
+
+// translate returns m in the language l. Arguments of the message that are
+// messages themselves are translated as well. Messages without translation
+// are returned in english.
+func (l *language) translate(m *message) string {
+	format, ok := l.lookup(m.id)
+	args := make([]interface{}, len(m.args))
+	for i, arg := range m.args {
+		switch a := arg.(type) {
+		case *message:
+			args[i] = l.translate(a)
+		default:
+			if ok {
+				// translations format every argument with %s
+				args[i] = fmt.Sprint(a)
+			} else {
+				args[i] = a
+			}
+		}
+	}
+	if !ok {
+		format = m.id
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// lookup returns the translation of the message id
+func (l *language) lookup(id string) (string, bool) {
+	for ; l != nil; l = l.parent {
+		if t, ok := l.catalogue.messages[id]; ok {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// name returns the tree name in the language l
+func (l *language) name(name string) string {
+	for ; l != nil; l = l.parent {
+		if t, ok := l.catalogue.names[name]; ok {
+			return t
+		}
+	}
+	return name
+}
+
+func (l *language) tree(t Tree) Tree {
+	t.Species = l.name(t.Species)
+	t.CommonName = l.name(t.CommonName)
+	return t
+}
+
+// localizable is implemented by responses containing tree names
+type localizable interface {
+	localize(l *language) interface{}
+}
+
+func (t Tree) localize(l *language) interface{} {
+	return l.tree(t)
+}
+
+func (r resp) localize(l *language) interface{} {
+	r.MyFavouriteTree = l.name(r.MyFavouriteTree)
+	return r
+}
+
+func (list treeList) localize(l *language) interface{} {
+	trees := make([]Tree, len(list.Trees))
+	for i, t := range list.Trees {
+		trees[i] = l.tree(t)
+	}
+	list.Trees = trees
+	return list
+}
+
+func (f favourite) localize(l *language) interface{} {
+	if f.Tree != nil {
+		t := l.tree(*f.Tree)
+		f.Tree = &t
+	}
+	return f
+}
+
+func (r searchResults) localize(l *language) interface{} {
+	results := make([]searchMatch, len(r.Results))
+	for i, m := range r.Results {
+		results[i] = searchMatch{m.Score, l.tree(m.Tree)}
+	}
+	r.Results = results
+	return r
+}
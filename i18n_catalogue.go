@@ -0,0 +1,255 @@
+package main
+
+// catalogues holds the translations of every supported language keyed by its
+// language tag
+var catalogues = map[string]catalogue{
+	"en": {},
+	"en-US": {
+		messages: map[string]string{
+			"favourite tree of user": "favorite tree of user",
+		},
+	},
+	"de": {
+		messages: map[string]string{
+			"tree":                   "Baum",
+			"health check":           "Health Check",
+			"favourite tree of user": "Lieblingsbaum von Nutzer",
+			"webhook":                "Webhook",
+
+			"%s \"%s\" does not exist":               "%s \"%s\" existiert nicht",
+			"path \"%s\" does not exist":             "Pfad \"%s\" existiert nicht",
+			"tree \"%s\" already exists":             "Baum \"%s\" existiert bereits",
+			"the precondition on tree \"%s\" failed": "die Vorbedingung für Baum \"%s\" ist nicht erfüllt",
+			"method \"%s\" is not allowed on \"%s\"": "Methode \"%s\" ist auf \"%s\" nicht erlaubt",
+			"the resource is available as %s":        "die Ressource ist verfügbar als %s",
+			"failed to encode the response":          "die Antwort konnte nicht kodiert werden",
+			"the tree store failed":                  "der Baumspeicher ist fehlgeschlagen",
+			"the tree is invalid":                    "der Baum ist ungültig",
+			"the favourite is invalid":               "der Lieblingsbaum ist ungültig",
+			"invalid request body: %s":               "ungültiger Request-Body: %s",
+			"invalid list query":                     "ungültige Listenabfrage",
+			"invalid search":                         "ungültige Suche",
+			"\"%s\" is not a valid user id":          "\"%s\" ist keine gültige Nutzer-ID",
+			"header %s is not a valid user id":       "Header %s ist keine gültige Nutzer-ID",
+
+			"authentication is required":                        "eine Authentifizierung ist erforderlich",
+			"the scope %s is required":                          "der Scope %s ist erforderlich",
+			"webhooks require authentication to be configured":  "Webhooks erfordern eine konfigurierte Authentifizierung",
+			"the favourite of another user can not be changed":  "der Lieblingsbaum eines anderen Nutzers kann nicht geändert werden",
+			"the api key is invalid":                            "der API-Schlüssel ist ungültig",
+			"the Authorization header must hold a bearer token": "der Authorization-Header muss ein Bearer-Token enthalten",
+			"the token is malformed":                            "das Token ist fehlerhaft",
+			"the token signature is invalid":                    "die Signatur des Tokens ist ungültig",
+			"the token has no expiry":                           "das Token hat kein Ablaufdatum",
+			"the token has expired":                             "das Token ist abgelaufen",
+			"the token is not valid yet":                        "das Token ist noch nicht gültig",
+			"the token has no subject":                          "das Token hat kein Subject",
+			"the token has a different issuer":                  "das Token hat einen anderen Aussteller",
+			"the token is meant for a different audience":       "das Token ist für eine andere Zielgruppe bestimmt",
+
+			"too many requests, retry in %d seconds": "zu viele Anfragen, erneut versuchen in %s Sekunden",
+			"too many streams are open":              "zu viele Streams sind geöffnet",
+			"the response can not be streamed":       "die Antwort kann nicht gestreamt werden",
+			"the webhook is invalid":                 "der Webhook ist ungültig",
+
+			"the query is missing":                                       "die Abfrage fehlt",
+			"invalid variables: %s":                                      "ungültige Variablen: %s",
+			"invalid list query: %s":                                     "ungültige Listenabfrage: %s",
+			"mutations must be sent with POST":                           "Mutationen müssen mit POST gesendet werden",
+			"the query is nested %d levels deep, at most %d are allowed": "die Abfrage ist %s Ebenen tief verschachtelt, erlaubt sind höchstens %s",
+			"the query has a complexity of %d, at most %d is allowed":    "die Abfrage hat eine Komplexität von %s, erlaubt ist höchstens %s",
+			"userId is required for anonymous callers":                   "userId ist für anonyme Aufrufer erforderlich",
+
+			"is required":                                                              "ist erforderlich",
+			"can not be changed":                                                       "kann nicht geändert werden",
+			"must match the id in the path":                                            "muss mit der ID im Pfad übereinstimmen",
+			"must be an absolute http or https url":                                    "muss eine absolute http- oder https-URL sein",
+			"unknown event \"%s\", must be one of %s":                                  "unbekanntes Ereignis \"%s\", muss eines von %s sein",
+			"must be at least %d characters long":                                      "muss mindestens %s Zeichen lang sein",
+			"must not be longer than %d characters":                                    "darf nicht länger als %s Zeichen sein",
+			"must be between %d and %d":                                                "muss zwischen %s und %s liegen",
+			"unknown region \"%s\", must be one of %s":                                 "unbekannte Region \"%s\", muss eine von %s sein",
+			"can not sort by \"%s\", must be one of %s":                                "kann nicht nach \"%s\" sortieren, muss eines von %s sein",
+			"must contain at least one search term":                                    "muss mindestens einen Suchbegriff enthalten",
+			"must not contain more than %d search terms":                               "darf nicht mehr als %s Suchbegriffe enthalten",
+			"is invalid or belongs to a different sort order or filter":                "ist ungültig oder gehört zu einer anderen Sortierung oder einem anderen Filter",
+			"must consist of lower case letters and digits separated by single dashes": "darf nur aus Kleinbuchstaben und Ziffern bestehen, getrennt durch einzelne Bindestriche",
+		},
+		names: map[string]string{
+			"Sequoia":         "Mammutbaum",
+			"Coast redwood":   "Küstenmammutbaum",
+			"Giant sequoia":   "Riesenmammutbaum",
+			"Sierra redwood":  "Berg-Mammutbaum",
+			"Beech":           "Buche",
+			"European beech":  "Rotbuche",
+			"Oak":             "Eiche",
+			"English oak":     "Stieleiche",
+			"Baobab":          "Affenbrotbaum",
+			"African baobab":  "Afrikanischer Affenbrotbaum",
+			"Ginkgo":          "Ginkgo",
+			"Maidenhair tree": "Fächerblattbaum",
+		},
+	},
+	"fr": {
+		messages: map[string]string{
+			"tree":                   "arbre",
+			"health check":           "contrôle de santé",
+			"favourite tree of user": "arbre préféré de l'utilisateur",
+			"webhook":                "webhook",
+
+			"%s \"%s\" does not exist":               "%s « %s » n'existe pas",
+			"path \"%s\" does not exist":             "le chemin « %s » n'existe pas",
+			"tree \"%s\" already exists":             "l'arbre « %s » existe déjà",
+			"the precondition on tree \"%s\" failed": "la précondition sur l'arbre « %s » a échoué",
+			"method \"%s\" is not allowed on \"%s\"": "la méthode « %s » n'est pas autorisée sur « %s »",
+			"the resource is available as %s":        "la ressource est disponible en %s",
+			"failed to encode the response":          "l'encodage de la réponse a échoué",
+			"the tree store failed":                  "le stockage des arbres a échoué",
+			"the tree is invalid":                    "l'arbre n'est pas valide",
+			"the favourite is invalid":               "le favori n'est pas valide",
+			"invalid request body: %s":               "corps de requête invalide : %s",
+			"invalid list query":                     "requête de liste invalide",
+			"invalid search":                         "recherche invalide",
+			"\"%s\" is not a valid user id":          "« %s » n'est pas un identifiant d'utilisateur valide",
+			"header %s is not a valid user id":       "l'en-tête %s n'est pas un identifiant d'utilisateur valide",
+
+			"authentication is required":                        "une authentification est requise",
+			"the scope %s is required":                          "le scope %s est requis",
+			"webhooks require authentication to be configured":  "les webhooks nécessitent une authentification configurée",
+			"the favourite of another user can not be changed":  "le favori d'un autre utilisateur ne peut pas être modifié",
+			"the api key is invalid":                            "la clé d'API n'est pas valide",
+			"the Authorization header must hold a bearer token": "l'en-tête Authorization doit contenir un jeton bearer",
+			"the token is malformed":                            "le jeton est mal formé",
+			"the token signature is invalid":                    "la signature du jeton n'est pas valide",
+			"the token has no expiry":                           "le jeton n'a pas de date d'expiration",
+			"the token has expired":                             "le jeton a expiré",
+			"the token is not valid yet":                        "le jeton n'est pas encore valide",
+			"the token has no subject":                          "le jeton n'a pas de sujet",
+			"the token has a different issuer":                  "le jeton a un autre émetteur",
+			"the token is meant for a different audience":       "le jeton est destiné à une autre audience",
+
+			"too many requests, retry in %d seconds": "trop de requêtes, réessayez dans %s secondes",
+			"too many streams are open":              "trop de flux sont ouverts",
+			"the response can not be streamed":       "la réponse ne peut pas être diffusée en flux",
+			"the webhook is invalid":                 "le webhook n'est pas valide",
+
+			"the query is missing":                                       "la requête est manquante",
+			"invalid variables: %s":                                      "variables invalides : %s",
+			"invalid list query: %s":                                     "requête de liste invalide : %s",
+			"mutations must be sent with POST":                           "les mutations doivent être envoyées avec POST",
+			"the query is nested %d levels deep, at most %d are allowed": "la requête est imbriquée sur %s niveaux, au plus %s sont autorisés",
+			"the query has a complexity of %d, at most %d is allowed":    "la requête a une complexité de %s, au plus %s est autorisé",
+			"userId is required for anonymous callers":                   "userId est obligatoire pour les appelants anonymes",
+
+			"is required":                                                              "est obligatoire",
+			"can not be changed":                                                       "ne peut pas être modifié",
+			"must match the id in the path":                                            "doit correspondre à l'identifiant du chemin",
+			"must be an absolute http or https url":                                    "doit être une URL http ou https absolue",
+			"unknown event \"%s\", must be one of %s":                                  "événement « %s » inconnu, doit être l'un de %s",
+			"must be at least %d characters long":                                      "doit contenir au moins %s caractères",
+			"must not be longer than %d characters":                                    "ne doit pas dépasser %s caractères",
+			"must be between %d and %d":                                                "doit être compris entre %s et %s",
+			"unknown region \"%s\", must be one of %s":                                 "région « %s » inconnue, doit être l'une de %s",
+			"can not sort by \"%s\", must be one of %s":                                "impossible de trier par « %s », doit être l'un de %s",
+			"must contain at least one search term":                                    "doit contenir au moins un terme de recherche",
+			"must not contain more than %d search terms":                               "ne doit pas contenir plus de %s termes de recherche",
+			"is invalid or belongs to a different sort order or filter":                "n'est pas valide ou appartient à un autre tri ou filtre",
+			"must consist of lower case letters and digits separated by single dashes": "doit se composer de lettres minuscules et de chiffres séparés par des tirets simples",
+		},
+		names: map[string]string{
+			"Sequoia":         "Séquoia",
+			"Coast redwood":   "Séquoia à feuilles d'if",
+			"Giant sequoia":   "Séquoia géant",
+			"Sierra redwood":  "Wellingtonia",
+			"Beech":           "Hêtre",
+			"European beech":  "Hêtre commun",
+			"Oak":             "Chêne",
+			"English oak":     "Chêne pédonculé",
+			"Baobab":          "Baobab",
+			"African baobab":  "Baobab africain",
+			"Ginkgo":          "Ginkgo",
+			"Maidenhair tree": "Arbre aux quarante écus",
+		},
+	},
+	"es": {
+		messages: map[string]string{
+			"tree":                   "árbol",
+			"health check":           "comprobación de estado",
+			"favourite tree of user": "árbol favorito del usuario",
+			"webhook":                "webhook",
+
+			"%s \"%s\" does not exist":               "%s \"%s\" no existe",
+			"path \"%s\" does not exist":             "la ruta \"%s\" no existe",
+			"tree \"%s\" already exists":             "el árbol \"%s\" ya existe",
+			"the precondition on tree \"%s\" failed": "la condición previa del árbol \"%s\" no se cumple",
+			"method \"%s\" is not allowed on \"%s\"": "el método \"%s\" no está permitido en \"%s\"",
+			"the resource is available as %s":        "el recurso está disponible como %s",
+			"failed to encode the response":          "no se pudo codificar la respuesta",
+			"the tree store failed":                  "el almacén de árboles ha fallado",
+			"the tree is invalid":                    "el árbol no es válido",
+			"the favourite is invalid":               "el favorito no es válido",
+			"invalid request body: %s":               "cuerpo de la solicitud no válido: %s",
+			"invalid list query":                     "consulta de lista no válida",
+			"invalid search":                         "búsqueda no válida",
+			"\"%s\" is not a valid user id":          "\"%s\" no es un id de usuario válido",
+			"header %s is not a valid user id":       "la cabecera %s no es un id de usuario válido",
+
+			"authentication is required":                        "se requiere autenticación",
+			"the scope %s is required":                          "se requiere el scope %s",
+			"webhooks require authentication to be configured":  "los webhooks requieren que la autenticación esté configurada",
+			"the favourite of another user can not be changed":  "no se puede cambiar el favorito de otro usuario",
+			"the api key is invalid":                            "la clave de API no es válida",
+			"the Authorization header must hold a bearer token": "la cabecera Authorization debe contener un token bearer",
+			"the token is malformed":                            "el token está mal formado",
+			"the token signature is invalid":                    "la firma del token no es válida",
+			"the token has no expiry":                           "el token no tiene caducidad",
+			"the token has expired":                             "el token ha caducado",
+			"the token is not valid yet":                        "el token aún no es válido",
+			"the token has no subject":                          "el token no tiene sujeto",
+			"the token has a different issuer":                  "el token tiene otro emisor",
+			"the token is meant for a different audience":       "el token está destinado a otra audiencia",
+
+			"too many requests, retry in %d seconds": "demasiadas solicitudes, vuelva a intentarlo en %s segundos",
+			"too many streams are open":              "hay demasiados flujos abiertos",
+			"the response can not be streamed":       "la respuesta no se puede transmitir como flujo",
+			"the webhook is invalid":                 "el webhook no es válido",
+
+			"the query is missing":                                       "falta la consulta",
+			"invalid variables: %s":                                      "variables no válidas: %s",
+			"invalid list query: %s":                                     "consulta de lista no válida: %s",
+			"mutations must be sent with POST":                           "las mutaciones deben enviarse con POST",
+			"the query is nested %d levels deep, at most %d are allowed": "la consulta está anidada en %s niveles, se permiten como máximo %s",
+			"the query has a complexity of %d, at most %d is allowed":    "la consulta tiene una complejidad de %s, se permite como máximo %s",
+			"userId is required for anonymous callers":                   "se requiere userId para llamadas anónimas",
+
+			"is required":                                                              "es obligatorio",
+			"can not be changed":                                                       "no se puede cambiar",
+			"must match the id in the path":                                            "debe coincidir con el id de la ruta",
+			"must be an absolute http or https url":                                    "debe ser una URL http o https absoluta",
+			"unknown event \"%s\", must be one of %s":                                  "evento \"%s\" desconocido, debe ser uno de %s",
+			"must be at least %d characters long":                                      "debe tener al menos %s caracteres",
+			"must not be longer than %d characters":                                    "no debe tener más de %s caracteres",
+			"must be between %d and %d":                                                "debe estar entre %s y %s",
+			"unknown region \"%s\", must be one of %s":                                 "región \"%s\" desconocida, debe ser una de %s",
+			"can not sort by \"%s\", must be one of %s":                                "no se puede ordenar por \"%s\", debe ser uno de %s",
+			"must contain at least one search term":                                    "debe contener al menos un término de búsqueda",
+			"must not contain more than %d search terms":                               "no debe contener más de %s términos de búsqueda",
+			"is invalid or belongs to a different sort order or filter":                "no es válido o pertenece a otro orden o filtro",
+			"must consist of lower case letters and digits separated by single dashes": "debe constar de letras minúsculas y dígitos separados por guiones simples",
+		},
+		names: map[string]string{
+			"Sequoia":         "Secuoya",
+			"Coast redwood":   "Secuoya roja",
+			"Giant sequoia":   "Secuoya gigante",
+			"Sierra redwood":  "Secuoya de Sierra Nevada",
+			"Beech":           "Haya",
+			"European beech":  "Haya común",
+			"Oak":             "Roble",
+			"English oak":     "Roble común",
+			"Baobab":          "Baobab",
+			"African baobab":  "Baobab africano",
+			"Ginkgo":          "Ginkgo",
+			"Maidenhair tree": "Árbol de los cuarenta escudos",
+		},
+	},
+}
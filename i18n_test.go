@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		acceptLanguage, query, expected string
+	}{
+		{"", "", "en"},
+		{"de", "", "de"},
+		{"de-AT", "", "de"},
+		{"en-us", "", "en-US"},
+		{"en-GB", "", "en"},
+		{"nl, fr;q=0.8, de;q=0.5", "", "fr"},
+		{"de;q=0.5, es", "", "es"},
+		{"nl, *;q=0.5", "", "en"},
+		{"fr;q=0, de;q=0.1", "", "de"},
+		{"zh", "", "en"},
+		{"de", "lang=fr-CA", "fr"},
+		{"de", "lang=xx", "de"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/tree?"+tc.query, nil)
+		r.Header.Set("Accept-Language", tc.acceptLanguage)
+		if l := negotiateLanguage(r); l.tag != tc.expected {
+			t.Errorf("%s %s: expected %s, got %s", tc.acceptLanguage, tc.query, tc.expected, l.tag)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	for _, tc := range []struct {
+		tag      string
+		message  *message
+		expected string
+	}{
+		{"de", newMessage("is required"), "ist erforderlich"},
+		{"de", notFoundMessage("tree", "oak"), "Baum \"oak\" existiert nicht"},
+		{"de", notFoundMessage("health check", "db"), "Health Check \"db\" existiert nicht"},
+		{"fr", newMessage("must be between %d and %d", 0, 200), "doit être compris entre 0 et 200"},
+		{"en-us", notFoundMessage("favourite tree of user", "jan"), "favorite tree of user \"jan\" does not exist"},
+		{"en-us", newMessage("the tree is invalid"), "the tree is invalid"},
+		{"en", newMessage("must be between %d and %d", 1, 100), "must be between 1 and 100"},
+		{"es", newMessage("something unexpected"), "something unexpected"},
+		{"de", newMessage("unknown region \"%s\", must be one of %s", "Atlantis", "Asia"),
+			"unbekannte Region \"Atlantis\", muss eine von Asia sein"},
+	} {
+		if msg := languages[tc.tag].translate(tc.message); msg != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.tag, tc.expected, msg)
+		}
+	}
+}
+
+func TestLocalizedResponses(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/tree", nil)
+	req.Header.Set("Accept-Language", "de-DE, en;q=0.5")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Language") != "de" {
+		t.Fatalf("expected Content-Language de, got %q", rec.Header().Get("Content-Language"))
+	}
+	var body resp
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.MyFavouriteTree != "Mammutbaum" {
+		t.Fatalf("expected the german name, got %q", body.MyFavouriteTree)
+	}
+
+	rec = record(h, http.MethodGet, "/trees/baobab?lang=fr", "")
+	var tree Tree
+	if err := json.NewDecoder(rec.Body).Decode(&tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree.CommonName != "Baobab africain" || tree.ScientificName != "Adansonia digitata" {
+		t.Fatalf("expected french names, got %+v", tree)
+	}
+
+	rec = record(h, http.MethodGet, "/trees/larch?lang=es", "")
+	var e errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Error.Message != "árbol \"larch\" no existe" || rec.Header().Get("Content-Language") != "es" {
+		t.Fatalf("expected a spanish error, got %q in %q", e.Error.Message, rec.Header().Get("Content-Language"))
+	}
+
+	// the catalogue itself is not translated
+	rec = record(h, http.MethodGet, "/trees/sequoia", "")
+	if rec.Header().Get("Content-Language") != "en" {
+		t.Fatalf("expected Content-Language en, got %q", rec.Header().Get("Content-Language"))
+	}
+}
+
+func TestCataloguesAreComplete(t *testing.T) {
+	for _, tag := range []string{"de", "fr", "es"} {
+		c := catalogues[tag]
+		for _, other := range []string{"de", "fr", "es"} {
+			for msg := range catalogues[other].messages {
+				if _, ok := c.messages[msg]; !ok {
+					t.Errorf("%s: missing translation of %q", tag, msg)
+				}
+			}
+		}
+		for _, tree := range defaultTrees {
+			for _, name := range []string{tree.Species, tree.CommonName} {
+				if _, ok := c.names[name]; !ok {
+					t.Errorf("%s: missing translation of %q", tag, name)
+				}
+			}
+		}
+	}
+}
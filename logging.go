@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestIDHeader carries the id of a request. An id sent by the client is
+// kept, otherwise one is generated. It is always returned in the response.
+const requestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// Log levels in increasing order of severity
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// redactedHeaders are never written to the log
+var redactedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// logger is the structured logger of the service. It is configured in main.
+var logger = newJSONLogger(os.Stderr, levelInfo)
+
+// fields are the key value pairs of a log entry
+type fields map[string]interface{}
+
+// jsonLogger writes every entry as a single json line
+type jsonLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level int
+}
+
+func newJSONLogger(w io.Writer, level int) *jsonLogger {
+	return &jsonLogger{w: w, level: level}
+}
+
+// parseLevel returns the log level with the given name
+func parseLevel(name string) (int, error) {
+	for level, n := range levelNames {
+		if n == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level \"%s\", must be one of %s",
+		name, strings.Join(levelNames, ", "))
+}
+
+func (l *jsonLogger) setLevel(level int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+func (l *jsonLogger) enabled(level int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.level
+}
+
+func (l *jsonLogger) log(level int, msg string, f fields) {
+	if !l.enabled(level) {
+		return
+	}
+	entry := make(fields, len(f)+3)
+	for k, v := range f {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = levelNames[level]
+	entry["msg"] = msg
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(fields{"level": levelNames[levelError], "msg": "unencodable log entry: " + err.Error()})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(b, '\n'))
+}
+
+func (l *jsonLogger) Debug(msg string, f fields) { l.log(levelDebug, msg, f) }
+func (l *jsonLogger) Info(msg string, f fields)  { l.log(levelInfo, msg, f) }
+func (l *jsonLogger) Warn(msg string, f fields)  { l.log(levelWarn, msg, f) }
+func (l *jsonLogger) Error(msg string, f fields) { l.log(levelError, msg, f) }
+
+// Write lets the logger serve as the output of the standard library logger
+// so that messages written with the log package become json lines as well
+func (l *jsonLogger) Write(p []byte) (int, error) {
+	l.Info(strings.TrimSuffix(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+type requestIDKey struct{}
+
+// requestID returns the id of the request ctx belongs to
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logRequests assigns every request an id and writes one log entry per
+// request once it has been served
+func logRequests(l *jsonLogger) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(requestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(requestIDHeader, id)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+			sw := newStatusWriter(w)
+			next.ServeHTTP(sw, r)
+
+			f := fields{
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"status":     sw.status,
+				"bytes":      sw.bytes,
+				"latencyMs":  float64(time.Since(start)) / float64(time.Millisecond),
+				"remoteAddr": r.RemoteAddr,
+				"requestId":  id,
+			}
+			if sp := spanFromContext(r.Context()); sp != nil {
+				f["traceId"] = hex.EncodeToString(sp.context.TraceID[:])
+			}
+			if l.enabled(levelDebug) {
+				f["headers"] = redactHeaders(r.Header)
+			}
+			level := levelInfo
+			if sw.status >= http.StatusInternalServerError {
+				level = levelError
+			}
+			l.log(level, "request", f)
+		})
+	}
+}
+
+func redactHeaders(h http.Header) http.Header {
+	result := make(http.Header, len(h))
+	for name, values := range h {
+		result[name] = values
+	}
+	for _, name := range redactedHeaders {
+		if _, ok := result[name]; ok {
+			result[name] = []string{"REDACTED"}
+		}
+	}
+	return result
+}
+
+// statusWriter records the status code and the number of bytes written. It
+// passes flushing and hijacking through to the wrapped writer.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func newStatusWriter(w http.ResponseWriter) *statusWriter {
+	return &statusWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
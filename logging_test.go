@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	l := newJSONLogger(&buf, levelDebug)
+	h := logRequests(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestID(r.Context()) != "abc-123" {
+			t.Errorf("expected the request id in the context, got %q", requestID(r.Context()))
+		}
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/tree", nil)
+	r.Header.Set(requestIDHeader, "abc-123")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+
+	if rec.Header().Get(requestIDHeader) != "abc-123" {
+		t.Errorf("expected the request id to be returned, got %q", rec.Header().Get(requestIDHeader))
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("expected the authorization header to be redacted: %s", buf.String())
+	}
+	var entry struct {
+		Msg       string              `json:"msg"`
+		Level     string              `json:"level"`
+		Method    string              `json:"method"`
+		Path      string              `json:"path"`
+		Status    int                 `json:"status"`
+		Bytes     int                 `json:"bytes"`
+		RequestID string              `json:"requestId"`
+		Headers   map[string][]string `json:"headers"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a json log line, got %s", buf.String())
+	}
+	if entry.Msg != "request" || entry.Level != "info" || entry.Method != http.MethodGet ||
+		entry.Path != "/tree" || entry.Status != http.StatusTeapot || entry.Bytes != 15 ||
+		entry.RequestID != "abc-123" || entry.Headers["Accept"][0] != "application/json" {
+		t.Errorf("unexpected log entry %+v", entry)
+	}
+}
+
+func TestLogRequestsGeneratesIDs(t *testing.T) {
+	var buf bytes.Buffer
+	h := logRequests(newJSONLogger(&buf, levelWarn))(http.NotFoundHandler())
+
+	ids := map[string]bool{}
+	for _, sent := range []string{"", "has spaces", strings.Repeat("x", maxRequestIDLength+1)} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(requestIDHeader, sent)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		id := rec.Header().Get(requestIDHeader)
+		if id == sent || !validRequestID(id) || ids[id] {
+			t.Errorf("expected a new request id instead of %q, got %q", sent, id)
+		}
+		ids[id] = true
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected info entries to be suppressed at warn level, got %s", buf.String())
+	}
+}
@@ -1,47 +1,135 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"syscall"
 	"time"
+
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
 )
 
 const (
-	tree = "Sequoia"
+	// defaultFavourite is the id of the tree returned to anonymous users
+	defaultFavourite = "sequoia"
 )
 
+// version is set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
 type resp struct {
-	MyFavouriteTree string `json:"myFavouriteTree"`
+	XMLName         xml.Name `json:"-" yaml:"-" xml:"favouriteTree"`
+	MyFavouriteTree string   `json:"myFavouriteTree" yaml:"myFavouriteTree" xml:"myFavouriteTree"`
+}
+
+func (r resp) plainText() string {
+	return r.MyFavouriteTree
 }
 
 func main() {
-	staticResponse := resp{tree}
-
-	http.HandleFunc(
-		"/tree",
-		func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("\"%s\" request with header \"%s\" to \"%s\"", r.Method, r.Header, r.URL)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(staticResponse)
-		},
-	)
-
-	// The /healthz endpoint is added so that kubernetes can evalueate if the pod
-	// needs restarting
-	http.HandleFunc(
-		"/healthz",
-		func(w http.ResponseWriter, r *http.Request) {
-			log.Println("healthz ping")
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("OK"))
-		},
-	)
+	cfg, printConfig, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	level, _ := parseLevel(cfg.LogLevel)
+	logger.setLevel(level)
+	log.SetFlags(0)
+	log.SetOutput(logger)
+
+	if printConfig {
+		b, err := yaml.Marshal(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(b)
+		return
+	}
+
+	store, err := openStore(cfg.Storage.Backend, cfg.Storage.Path, defaultTrees)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			log.Printf("failed to close the tree store: %s", err)
+		}
+	}()
+	if _, err := store.Get(cfg.DefaultTree); err != nil {
+		log.Printf("the default tree \"%s\" is not in the catalogue", cfg.DefaultTree)
+	}
+	s, err := newServer(store, cfg)
+	if err != nil {
+		log.Fatalf("failed to index the tree catalogue: %s", err)
+	}
 
 	srv := &http.Server{
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		Addr:         ":8090",
+		ReadTimeout:  time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		Addr:         cfg.addr(),
+		Handler:      s.handler(),
+		// streams lift the write timeout of their connection
+		ConnContext: withConn,
+	}
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := signalContext(syscall.SIGTERM, os.Interrupt)
+	if cfg.TLS.enabled() {
+		if l, srv.TLSConfig, err = listenTLS(ctx, l, cfg.TLS); err != nil {
+			log.Fatal(err)
+		}
+		if cfg.TLS.RedirectPort != 0 {
+			redirect := &http.Server{
+				ReadTimeout:  time.Duration(cfg.ReadTimeout),
+				WriteTimeout: time.Duration(cfg.WriteTimeout),
+				Addr:         fmt.Sprintf(":%d", cfg.TLS.RedirectPort),
+				Handler:      redirectToHTTPS(cfg.Port),
+			}
+			go func() {
+				if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+					log.Printf("the https redirect listener failed: %s", err)
+				}
+			}()
+			defer redirect.Close()
+		}
+	}
+	var g *grpc.Server
+	if cfg.GRPCPort != 0 {
+		gl, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the gRPC api shares the certificates and client policy of https
+		g = s.grpcServer(srv.TLSConfig)
+		go func() {
+			if err := g.Serve(gl); err != nil {
+				log.Printf("the gRPC listener failed: %s", err)
+			}
+		}()
+	}
+	err = serve(ctx, srv, l, s,
+		time.Duration(cfg.DrainPeriod), time.Duration(cfg.ShutdownTimeout))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if g != nil {
+		stopGRPC(g, time.Duration(cfg.ShutdownTimeout))
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.tracer.shutdown(flushCtx); err != nil {
+		log.Printf("failed to export pending spans: %s", err)
 	}
-	log.Fatal(srv.ListenAndServe())
+	log.Println("shut down")
 }
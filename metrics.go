@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsNamespace prefixes the names of all metrics of the service
+const metricsNamespace = "tree_spotter_"
+
+// unmatchedRoute is the route label of requests no route matched. It keeps
+// the number of label values bounded.
+const unmatchedRoute = "unmatched"
+
+// knownMethods are the method labels of the request metrics, other methods
+// are counted as otherMethod for the same reason
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true,
+	http.MethodTrace: true,
+}
+
+const otherMethod = "other"
+
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return otherMethod
+}
+
+// defaultBuckets are the upper bounds of the request duration histogram in
+// seconds
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector writes metrics in the prometheus text exposition format
+type collector interface {
+	collect(w io.Writer)
+}
+
+// metricsRegistry holds all collectors exposed on /metrics
+type metricsRegistry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{}
+}
+
+func (m *metricsRegistry) register(c collector) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, c)
+}
+
+func (m *metricsRegistry) handler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	m.mu.Lock()
+	for _, c := range m.collectors {
+		c.collect(&buf)
+	}
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// metricVec is the part shared by all metrics that are partitioned by labels
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (v metricVec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+// key joins label values into a map key
+func (v metricVec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the label set of key with extra appended
+func (v metricVec) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", v.labels[i], escapeLabel(value)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// counterVec is a monotonically increasing value per label set
+type counterVec struct {
+	metricVec
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		metricVec: metricVec{name, help, "counter", labels},
+		values:    map[string]float64{},
+	}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *counterVec) collect(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// gauge is a single value that can go up and down
+type gauge struct {
+	metricVec
+	value int64
+}
+
+func newGauge(name, help string) *gauge {
+	return &gauge{metricVec: metricVec{name, help, "gauge", nil}}
+}
+
+// add changes the value by delta and returns the new value
+func (g *gauge) add(delta int64) int64 {
+	return atomic.AddInt64(&g.value, delta)
+}
+
+func (g *gauge) collect(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %d\n", g.name, atomic.LoadInt64(&g.value))
+}
+
+// histogramVec counts observations into buckets per label set
+type histogramVec struct {
+	metricVec
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		metricVec: metricVec{name, help, "histogram", labels},
+		buckets:   buckets,
+		values:    map[string]*histogram{},
+	}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) collect(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hist := h.values[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(upper)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), hist.count)
+	}
+}
+
+// collectorFunc adapts a function to the collector interface
+type collectorFunc func(w io.Writer)
+
+func (f collectorFunc) collect(w io.Writer) {
+	f(w)
+}
+
+// buildInfo exposes the version of the binary as labels of a constant gauge
+func buildInfo() collector {
+	return collectorFunc(func(w io.Writer) {
+		v := metricVec{metricsNamespace + "build_info",
+			"A metric with a constant '1' value labeled by version and goversion.",
+			"gauge", []string{"version", "goversion"}}
+		v.header(w)
+		fmt.Fprintf(w, "%s%s 1\n", v.name, v.labelPairs(v.key([]string{version, runtime.Version()})))
+	})
+}
+
+// goRuntime exposes statistics of the go runtime
+func goRuntime() collector {
+	return collectorFunc(func(w io.Writer) {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		for _, m := range []struct {
+			name, help, kind string
+			value            float64
+		}{
+			{"go_goroutines", "Number of goroutines that currently exist.", "gauge",
+				float64(runtime.NumGoroutine())},
+			{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge",
+				float64(ms.Alloc)},
+			{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter",
+				float64(ms.TotalAlloc)},
+			{"go_memstats_sys_bytes", "Number of bytes obtained from system.", "gauge",
+				float64(ms.Sys)},
+			{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge",
+				float64(ms.HeapInuse)},
+			{"go_memstats_heap_objects", "Number of allocated objects.", "gauge",
+				float64(ms.HeapObjects)},
+			{"go_gc_cycles_total", "Number of completed GC cycles.", "counter",
+				float64(ms.NumGC)},
+			{"go_gc_pause_seconds_total", "Total duration of GC pauses in seconds.", "counter",
+				float64(ms.PauseTotalNs) / float64(time.Second)},
+		} {
+			metricVec{m.name, m.help, m.kind, nil}.header(w)
+			fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.value))
+		}
+	})
+}
+
+// httpMetrics instruments the http handlers
+type httpMetrics struct {
+	requests *counterVec
+	duration *histogramVec
+	inFlight *gauge
+}
+
+func newHTTPMetrics(reg *metricsRegistry) *httpMetrics {
+	m := &httpMetrics{
+		requests: newCounterVec(metricsNamespace+"http_requests_total",
+			"Number of http requests by route, method and status.",
+			"route", "method", "status"),
+		duration: newHistogramVec(metricsNamespace+"http_request_duration_seconds",
+			"Duration of http requests by route, method and status.",
+			defaultBuckets, "route", "method", "status"),
+		inFlight: newGauge(metricsNamespace+"http_requests_in_flight",
+			"Number of http requests currently being served."),
+	}
+	reg.register(m.requests)
+	reg.register(m.duration)
+	reg.register(m.inFlight)
+	return m
+}
+
+// instrument records the metrics of every request
+func instrument(m *httpMetrics) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			m.inFlight.add(1)
+			defer m.inFlight.add(-1)
+
+			r, route := recordRoute(r)
+			sw := newStatusWriter(w)
+			next.ServeHTTP(sw, r)
+
+			if *route == "" {
+				*route = unmatchedRoute
+			}
+			status, method := strconv.Itoa(sw.status), methodLabel(r.Method)
+			m.requests.inc(*route, method, status)
+			m.duration.observe(time.Since(start).Seconds(), *route, method, status)
+		})
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
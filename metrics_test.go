@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	h := newTestServer(defaultTrees).handler()
+	record(h, http.MethodGet, "/trees/oak", "")
+	record(h, http.MethodGet, "/trees/sequoia", "")
+	record(h, http.MethodGet, "/trees/ginkgo", "")
+	record(h, http.MethodGet, "/no/such/path", "")
+	record(h, "BREW", "/trees/oak", "")
+
+	rec := record(h, http.MethodGet, "/metrics", "")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE tree_spotter_http_requests_total counter",
+		`tree_spotter_http_requests_total{route="/trees/{id}",method="GET",status="200"} 2`,
+		`tree_spotter_http_requests_total{route="/trees/{id}",method="GET",status="404"} 1`,
+		`tree_spotter_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`tree_spotter_http_requests_total{route="/trees/{id}",method="other",status="405"} 1`,
+		"# TYPE tree_spotter_http_request_duration_seconds histogram",
+		`tree_spotter_http_request_duration_seconds_bucket{route="/trees/{id}",method="GET",status="200",le="+Inf"} 2`,
+		`tree_spotter_http_request_duration_seconds_count{route="/trees/{id}",method="GET",status="200"} 2`,
+		"tree_spotter_http_requests_in_flight 1",
+		`tree_spotter_build_info{version="dev",goversion="`,
+		"# TYPE go_goroutines gauge",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected the metrics to contain %q", line)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogramVec("latency", "Latency\nin seconds.", []float64{0.1, 1}, "path")
+	h.observe(0.05, `a"b`)
+	h.observe(0.5, `a"b`)
+	h.observe(5, `a"b`)
+
+	var buf bytes.Buffer
+	h.collect(&buf)
+	expected := `# HELP latency Latency\nin seconds.
+# TYPE latency histogram
+latency_bucket{path="a\"b",le="0.1"} 1
+latency_bucket{path="a\"b",le="1"} 2
+latency_bucket{path="a\"b",le="+Inf"} 3
+latency_sum{path="a\"b"} 5.55
+latency_count{path="a\"b"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
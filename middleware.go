@@ -0,0 +1,43 @@
+package main
+
+import "net/http"
+
+// middleware wraps a handler with additional behaviour
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h with the given middlewares. The first middleware is the
+// outermost one, i.e. it sees the request first.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// handler returns the complete handler of the server including all
+// middlewares
+func (s *server) handler() http.Handler {
+	rt := s.router()
+	mws := []middleware{
+		trace(s.tracer),
+		logRequests(logger),
+		instrument(s.httpMetrics),
+	}
+	if s.cors != nil {
+		// preflight requests carry no credentials
+		mws = append(mws, cors(s.cors))
+	}
+	if s.limiter != nil {
+		mws = append(mws, rateLimit(s.limiter, rt))
+	}
+	if s.auth != nil {
+		mws = append(mws, authenticate(s.auth))
+		if s.limiter != nil {
+			mws = append(mws, rateLimitCallers(s.limiter, rt))
+		}
+	}
+	if s.compression.Enabled {
+		mws = append(mws, compress(s.compression.MinSize, s.compression.Level))
+	}
+	return chain(rt, mws...)
+}
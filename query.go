@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// listSpec describes how the items of a list endpoint are filtered, sorted
+// and paginated. Every list endpoint declares one so that they all accept the
+// same query parameters:
+//
+//	limit   maximum number of items per page
+//	cursor  opaque position returned in the Link header of a previous page
+//	sort    comma separated fields, prefixed with - for descending order
+//
+// plus the filters declared by the spec.
+type listSpec struct {
+	// fields maps sortable field names to accessors returning a string or a
+	// float64
+	fields map[string]func(item interface{}) interface{}
+	// filters maps query parameters to constructors of item predicates
+	filters map[string]func(value string) (func(item interface{}) bool, error)
+	// id returns the unique key of an item. It breaks ties when sorting.
+	id          func(item interface{}) string
+	defaultSort string
+}
+
+// listQuery is a parsed list request
+type listQuery struct {
+	limit   int
+	sort    []sortKey
+	filters []func(item interface{}) bool
+	cursor  *cursor
+	// signature identifies the sort order and filters a cursor is valid for
+	signature string
+}
+
+type sortKey struct {
+	field      string
+	descending bool
+}
+
+// cursor points between two items of a list. Values holds the sort values
+// and the id of the item next to the position.
+type cursor struct {
+	Before    bool          `json:"b,omitempty"`
+	Values    []interface{} `json:"v"`
+	Signature string        `json:"s"`
+}
+
+// page is one page of a list
+type page struct {
+	items      []interface{}
+	nextCursor string
+	prevCursor string
+}
+
+// parse reads the list query from the request parameters
+func (spec listSpec) parse(values url.Values) (listQuery, []fieldError) {
+	var errs []fieldError
+	q := listQuery{limit: defaultPageSize}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			errs = append(errs, newFieldError("limit", "must be between 1 and %d", maxPageSize))
+		}
+		q.limit = limit
+	}
+
+	sortParam := values.Get("sort")
+	if sortParam == "" {
+		sortParam = spec.defaultSort
+	}
+	for _, f := range strings.Split(sortParam, ",") {
+		key := sortKey{field: strings.TrimSpace(f)}
+		if strings.HasPrefix(key.field, "-") {
+			key.field, key.descending = key.field[1:], true
+		}
+		if _, ok := spec.fields[key.field]; !ok {
+			errs = append(errs, newFieldError("sort", "can not sort by \"%s\", must be one of %s",
+				key.field, strings.Join(spec.fieldNames(), ", ")))
+			continue
+		}
+		q.sort = append(q.sort, key)
+	}
+
+	var names []string
+	for name := range spec.filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	signature := []string{sortParam}
+	for _, name := range names {
+		v := values.Get(name)
+		if v == "" {
+			continue
+		}
+		filter, err := spec.filters[name](v)
+		if err != nil {
+			errs = append(errs, fieldErrorOf(name, messageOf(err)))
+			continue
+		}
+		q.filters = append(q.filters, filter)
+		signature = append(signature, name+"="+v)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(signature, "&")))
+	q.signature = hex.EncodeToString(sum[:8])
+
+	if v := values.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil || c.Signature != q.signature || len(c.Values) != len(q.sort)+1 {
+			errs = append(errs, newFieldError("cursor", "is invalid or belongs to a different sort order or filter"))
+		}
+		q.cursor = c
+	}
+	return q, errs
+}
+
+func (spec listSpec) fieldNames() []string {
+	var names []string
+	for name := range spec.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply filters, sorts and paginates items
+func (spec listSpec) apply(items []interface{}, q listQuery) page {
+	var matching []interface{}
+	for _, item := range items {
+		if matchesAll(item, q.filters) {
+			matching = append(matching, item)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return spec.compare(q.sort, spec.key(q.sort, matching[i]), spec.key(q.sort, matching[j])) < 0
+	})
+
+	start, end := 0, len(matching)
+	if c := q.cursor; c != nil {
+		// the position of the cursor is the first item sorting after it
+		pos := sort.Search(len(matching), func(i int) bool {
+			return spec.compare(q.sort, spec.key(q.sort, matching[i]), c.Values) >= 0
+		})
+		if c.Before {
+			end = pos
+			if end-q.limit > start {
+				start = end - q.limit
+			}
+		} else {
+			start = pos
+			if start < len(matching) && spec.compare(q.sort, spec.key(q.sort, matching[start]), c.Values) == 0 {
+				start++
+			}
+		}
+	}
+	if end-start > q.limit {
+		end = start + q.limit
+	}
+
+	p := page{items: matching[start:end]}
+	if end < len(matching) && end > start {
+		p.nextCursor = encodeCursor(cursor{Values: spec.key(q.sort, matching[end-1]), Signature: q.signature})
+	}
+	if start > 0 && start < len(matching) {
+		p.prevCursor = encodeCursor(cursor{Before: true, Values: spec.key(q.sort, matching[start]), Signature: q.signature})
+	}
+	return p
+}
+
+// key returns the sort values of item followed by its id
+func (spec listSpec) key(keys []sortKey, item interface{}) []interface{} {
+	values := make([]interface{}, 0, len(keys)+1)
+	for _, k := range keys {
+		values = append(values, spec.fields[k.field](item))
+	}
+	return append(values, spec.id(item))
+}
+
+// compare orders two keys as returned by key. Keys decoded from a cursor hold
+// float64 values where the field accessors return numbers.
+func (spec listSpec) compare(keys []sortKey, a, b []interface{}) int {
+	for i := range a {
+		c := compareValues(a[i], b[i])
+		if i < len(keys) && keys[i].descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		b, _ := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	default:
+		as, bs := fmt.Sprint(a), fmt.Sprint(b)
+		return strings.Compare(strings.ToLower(as), strings.ToLower(bs))
+	}
+}
+
+func matchesAll(item interface{}, filters []func(interface{}) bool) bool {
+	for _, f := range filters {
+		if !f(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// writeLinks sets the Link header pointing to the next and previous page
+func writeLinks(w http.ResponseWriter, r *http.Request, p page) {
+	var links []string
+	for _, l := range []struct{ rel, cursor string }{
+		{"next", p.nextCursor},
+		{"prev", p.prevCursor},
+	} {
+		if l.cursor == "" {
+			continue
+		}
+		values := r.URL.Query()
+		values.Set("cursor", l.cursor)
+		u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+		links = append(links, fmt.Sprintf("<%s>; rel=\"%s\"", u.String(), l.rel))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// treeListSpec declares the sort fields and filters of tree lists
+var treeListSpec = listSpec{
+	fields: map[string]func(interface{}) interface{}{
+		"id":              func(i interface{}) interface{} { return i.(Tree).ID },
+		"species":         func(i interface{}) interface{} { return i.(Tree).Species },
+		"commonName":      func(i interface{}) interface{} { return i.(Tree).CommonName },
+		"scientificName":  func(i interface{}) interface{} { return i.(Tree).ScientificName },
+		"family":          func(i interface{}) interface{} { return i.(Tree).Family },
+		"maxHeightMetres": func(i interface{}) interface{} { return i.(Tree).MaxHeight },
+		"lifespanYears":   func(i interface{}) interface{} { return float64(i.(Tree).Lifespan) },
+	},
+	filters: map[string]func(string) (func(interface{}) bool, error){
+		"family": func(v string) (func(interface{}) bool, error) {
+			return func(i interface{}) bool { return strings.EqualFold(i.(Tree).Family, v) }, nil
+		},
+		"region": func(v string) (func(interface{}) bool, error) {
+			return func(i interface{}) bool {
+				for _, r := range i.(Tree).NativeRegions {
+					if strings.EqualFold(r, v) {
+						return true
+					}
+				}
+				return false
+			}, nil
+		},
+		"minHeight": func(v string) (func(interface{}) bool, error) {
+			min, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, newMessage("\"%s\" is not a number", v)
+			}
+			return func(i interface{}) bool { return i.(Tree).MaxHeight >= min }, nil
+		},
+	},
+	id:          func(i interface{}) string { return i.(Tree).ID },
+	defaultSort: "id",
+}
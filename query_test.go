@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func listIDs(t *testing.T, h http.Handler, path string) ([]string, treeList, string) {
+	rec := record(h, http.MethodGet, path, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: unexpected status %d: %s", path, rec.Code, rec.Body.String())
+	}
+	var list treeList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, tr := range list.Trees {
+		ids = append(ids, tr.ID)
+	}
+	return ids, list, rec.Header().Get("Link")
+}
+
+var linkPattern = regexp.MustCompile(`<([^>]+)>; rel="(next|prev)"`)
+
+func links(header string) map[string]string {
+	result := map[string]string{}
+	for _, m := range linkPattern.FindAllStringSubmatch(header, -1) {
+		result[m[2]] = m[1]
+	}
+	return result
+}
+
+func TestPagination(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	var pages []string
+	path := "/trees?limit=2&sort=-maxHeightMetres"
+	for path != "" {
+		ids, _, link := listIDs(t, h, path)
+		pages = append(pages, strings.Join(ids, ","))
+		path = links(link)["next"]
+	}
+	expected := []string{"sequoia,giant-sequoia", "european-beech,ginkgo", "english-oak,baobab"}
+	if strings.Join(pages, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected pages %v, got %v", expected, pages)
+	}
+
+	// walk back from the last page
+	_, _, link := listIDs(t, h, "/trees?limit=2&sort=-maxHeightMetres")
+	_, _, link = listIDs(t, h, links(link)["next"])
+	last := links(link)["next"]
+	_, list, link := listIDs(t, h, last)
+	if list.NextCursor != "" || list.PrevCursor == "" {
+		t.Fatalf("expected only a previous page, got %+v", list)
+	}
+	ids, _, _ := listIDs(t, h, links(link)["prev"])
+	if strings.Join(ids, ",") != "european-beech,ginkgo" {
+		t.Fatalf("unexpected previous page %v", ids)
+	}
+}
+
+func TestFilterAndSort(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+	for path, expected := range map[string]string{
+		"/trees":                                   "baobab,english-oak,european-beech,giant-sequoia,ginkgo,sequoia",
+		"/trees?family=fagaceae":                   "english-oak,european-beech",
+		"/trees?region=Asia&sort=-lifespanYears":   "ginkgo,english-oak",
+		"/trees?minHeight=50&sort=family,-id":      "sequoia,giant-sequoia,european-beech,ginkgo",
+		"/trees?family=Cupressaceae&minHeight=100": "sequoia",
+		"/trees?region=Antarctica":                 "",
+	} {
+		ids, _, link := listIDs(t, h, path)
+		if strings.Join(ids, ",") != expected {
+			t.Errorf("%s: expected %s, got %v", path, expected, ids)
+		}
+		if link != "" {
+			t.Errorf("%s: expected no links for a single page, got %s", path, link)
+		}
+	}
+}
+
+func TestListQueryErrors(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+	_, _, link := listIDs(t, h, "/trees?limit=2")
+	otherSort := strings.Replace(links(link)["next"], "limit=2", "limit=2&sort=family", 1)
+
+	for path, field := range map[string]string{
+		"/trees?limit=0":         "limit",
+		"/trees?limit=1000":      "limit",
+		"/trees?sort=colour":     "sort",
+		"/trees?minHeight=tall":  "minHeight",
+		"/trees?cursor=nonsense": "cursor",
+		otherSort:                "cursor",
+	} {
+		rec := record(h, http.MethodGet, path, "")
+		var body errorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusBadRequest || len(body.Error.Details) != 1 || body.Error.Details[0].Field != field {
+			t.Errorf("%s: expected a bad request for %s, got %d %+v", path, field, rec.Code, body)
+		}
+	}
+}
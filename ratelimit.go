@@ -0,0 +1,287 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forwardedForHeader lists the clients and proxies a request passed through,
+// the client first
+const forwardedForHeader = "X-Forwarded-For"
+
+// routePattern matches the routes limits are configured for, e.g. "GET /tree"
+var routePattern = regexp.MustCompile(`^[A-Z]+ /\S*$`)
+
+// bucket is a token bucket. It holds up to burst tokens and gains rate tokens
+// per second, every request takes one.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// clientBuckets are the buckets of a client: one shared by the routes
+// without a limit of their own, keyed by "", and one per route with a limit
+type clientBuckets struct {
+	key     string
+	buckets map[string]*bucket
+}
+
+// limit is the rate in requests per second and the burst of a route
+type limit struct {
+	rate  float64
+	burst int
+}
+
+// refill adds the tokens earned since the last update to b
+func (l limit) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(l.burst), b.tokens+elapsed*l.rate)
+	}
+	b.updated = now
+}
+
+// rateLimiter keeps the token buckets of the clients. The buckets of the
+// least recently seen clients are evicted once there are maxClients, a bucket
+// idle long enough to be full is no different from a new one anyway.
+type rateLimiter struct {
+	defaults limit
+	routes   map[string]limit
+	trusted  []*net.IPNet
+	now      func() time.Time
+	rejected *counterVec
+
+	mu         sync.Mutex
+	maxClients int
+	clients    map[string]*list.Element
+	lru        *list.List
+}
+
+// newRateLimiter returns nil if rate limiting is disabled
+func newRateLimiter(cfg rateLimitConfig, reg *metricsRegistry) (*rateLimiter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	trusted, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	l := &rateLimiter{
+		defaults:   limit{cfg.Rate, cfg.Burst},
+		routes:     map[string]limit{},
+		trusted:    trusted,
+		now:        time.Now,
+		maxClients: cfg.MaxClients,
+		clients:    map[string]*list.Element{},
+		lru:        list.New(),
+		rejected: newCounterVec(metricsNamespace+"rate_limited_requests_total",
+			"Number of requests rejected by the rate limiter by route.", "route"),
+	}
+	for _, r := range cfg.Routes {
+		l.routes[r.Route] = limit{r.Rate, r.Burst}
+	}
+	reg.register(l.rejected)
+	return l, nil
+}
+
+// parseCIDRs parses a comma separated list of networks. Single addresses are
+// taken as networks of one address.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("\"%s\" is not an ip address or network", c)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("\"%s\" is not an ip address or network", c)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (l *rateLimiter) isTrusted(ip net.IP) bool {
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client of r. X-Forwarded-For is only
+// followed through trusted proxies, as anyone else can put anything there.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !l.isTrusted(ip) {
+		return host
+	}
+	var hops []string
+	for _, v := range r.Header[forwardedForHeader] {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	// the last untrusted hop is the client, every hop after it is a proxy
+	// we trust to have appended the address it received the request from
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !l.isTrusted(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// take takes a token from the bucket of client for route, "" for the routes
+// sharing the default bucket. It returns the tokens left and how long to
+// wait for the next one if there was none to take.
+func (l *rateLimiter) take(client, route string, lim limit) (remaining float64, wait time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var c *clientBuckets
+	if e, ok := l.clients[client]; ok {
+		l.lru.MoveToFront(e)
+		c = e.Value.(*clientBuckets)
+	} else {
+		if l.lru.Len() >= l.maxClients {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.clients, oldest.Value.(*clientBuckets).key)
+		}
+		c = &clientBuckets{key: client, buckets: map[string]*bucket{}}
+		l.clients[client] = l.lru.PushFront(c)
+	}
+	b, ok := c.buckets[route]
+	if ok {
+		lim.refill(b, now)
+	} else {
+		b = &bucket{tokens: float64(lim.burst), updated: now}
+		c.buckets[route] = b
+	}
+
+	if b.tokens < 1 {
+		return b.tokens, time.Duration((1 - b.tokens) / lim.rate * float64(time.Second))
+	}
+	b.tokens--
+	return b.tokens, 0
+}
+
+// refund returns the token taken from the bucket of client for route
+func (l *rateLimiter) refund(client, route string, lim limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.clients[client]; ok {
+		if b, ok := e.Value.(*clientBuckets).buckets[route]; ok {
+			b.tokens = math.Min(float64(lim.burst), b.tokens+1)
+		}
+	}
+}
+
+// bucketOf returns the limit of route and the name of its bucket, "" for the
+// routes sharing the default bucket
+func (l *rateLimiter) bucketOf(route string) (limit, string) {
+	if lim, ok := l.routes[route]; ok {
+		return lim, route
+	}
+	return l.defaults, ""
+}
+
+// callerKey is the client key of an authenticated caller. It can not be
+// mistaken for an address.
+func callerKey(p principal) string {
+	return "caller " + p.subject
+}
+
+// rateLimit limits the requests of every client, identified by its address.
+// It runs before the authentication, so that neither guessing credentials
+// nor requesting paths that do not exist is unlimited. The routes share a
+// bucket per client unless they have a limit of their own. Routes rt does
+// not limit, like the health checks, are not counted.
+func rateLimit(l *rateLimiter, rt *router) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, limited := rt.lookup(r)
+			if !limited || l.allow(w, r, l.clientIP(r), route) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// rateLimitCallers moves the requests of authenticated callers from the
+// bucket of their address to a bucket of their own, keyed by the name of the
+// api key or the subject of the token. Callers sharing an address, e.g.
+// behind a NAT, are then limited separately. It runs after the
+// authentication.
+func rateLimitCallers(l *rateLimiter, rt *router) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, limited := rt.lookup(r)
+			p, _ := r.Context().Value(principalKey{}).(principal)
+			if !limited || p.subject == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			lim, bucket := l.bucketOf(route)
+			l.refund(l.clientIP(r), bucket, lim)
+			if l.allow(w, r, callerKey(p), route) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// allow takes a token of client for route, "" if no route matches. It sets
+// the RateLimit headers of the IETF draft and responds with 429 and
+// Retry-After if the bucket of the client is empty.
+func (l *rateLimiter) allow(w http.ResponseWriter, r *http.Request, client, route string) bool {
+	lim, bucket := l.bucketOf(route)
+	remaining, wait := l.take(client, bucket, lim)
+	// the bucket is full again after reset seconds
+	reset := math.Ceil((float64(lim.burst) - remaining) / lim.rate)
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(lim.burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(remaining)))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset)))
+	if wait == 0 {
+		return true
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+	retry := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	l.rejected.inc(route)
+	writeError(w, r, http.StatusTooManyRequests, codeTooManyRequests,
+		newMessage("too many requests, retry in %d seconds", retry))
+	return false
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRateLimitedServer returns a server allowing a burst of 2 requests and 1
+// request per second on every route but /trees, with a clock that only moves
+// when advanced
+func newRateLimitedServer() (http.Handler, func(time.Duration)) {
+	s := newTestServer(defaultTrees, func(cfg *config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.Rate = 1
+		cfg.RateLimit.Burst = 2
+		cfg.RateLimit.TrustedProxies = "10.0.0.0/8"
+		cfg.RateLimit.Routes = []routeLimitConfig{{Route: "GET /trees", Rate: 1, Burst: 5}}
+	})
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.limiter.now = func() time.Time { return now }
+	return s.handler(), func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimit(t *testing.T) {
+	h, advance := newRateLimitedServer()
+	client := "192.0.2.1:1234"
+
+	for i, remaining := range []string{"1", "0"} {
+		rec := record(h, http.MethodGet, "/tree", "", from(client))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d", i, rec.Code)
+		}
+		if rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Remaining") != remaining {
+			t.Errorf("request %d: unexpected headers %v", i, rec.Header())
+		}
+	}
+
+	rec := record(h, http.MethodGet, "/tree", "", from(client))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" || rec.Header().Get("RateLimit-Reset") != "2" {
+		t.Errorf("unexpected headers %v", rec.Header())
+	}
+	var e errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil || e.Error.Code != codeTooManyRequests {
+		t.Errorf("expected a %s error, got %+v (%v)", codeTooManyRequests, e, err)
+	}
+
+	// other clients, routes with a limit of their own and the health checks
+	// are not affected
+	if rec := record(h, http.MethodGet, "/tree", "", from("192.0.2.2:1234")); rec.Code != http.StatusOK {
+		t.Errorf("expected another client to pass, got %d", rec.Code)
+	}
+	if rec := record(h, http.MethodGet, "/trees", "", from(client)); rec.Code != http.StatusOK {
+		t.Errorf("expected a route with its own limit to pass, got %d", rec.Code)
+	}
+	// the other routes and paths that do not exist share the bucket
+	for _, path := range []string{"/trees/sequoia", "/unknown"} {
+		if rec := record(h, http.MethodGet, path, "", from(client)); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected %s to share the bucket, got %d", path, rec.Code)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if rec := record(h, http.MethodGet, "/livez", "", from(client)); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("expected /livez not to be limited, got %d", rec.Code)
+		}
+	}
+
+	advance(time.Second)
+	if rec := record(h, http.MethodGet, "/tree", "", from(client)); rec.Code != http.StatusOK {
+		t.Errorf("expected a token after a second, got %d", rec.Code)
+	}
+	if rec := record(h, http.MethodGet, "/tree", "", from(client)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the bucket to be empty again, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	h, _ := newRateLimitedServer()
+	for i := 0; i < 5; i++ {
+		if rec := record(h, http.MethodGet, "/trees", "", from("192.0.2.1:1234")); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d", i, rec.Code)
+		}
+	}
+	rec := record(h, http.MethodGet, "/trees", "", from("192.0.2.1:1234"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("RateLimit-Limit") != "5" {
+		t.Errorf("expected the limit of the route to apply, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	h, _ := newRateLimitedServer()
+	// behind the ingress every client has its own bucket
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		for i := 0; i < 2; i++ {
+			rec := record(h, http.MethodGet, "/tree", "", from("10.1.0.1:1234"), withHeaders(map[string]string{forwardedForHeader: client}))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: unexpected status %d", client, rec.Code)
+			}
+		}
+	}
+	// clients can not escape their bucket by sending the header themselves
+	for i := 0; i < 3; i++ {
+		rec := record(h, http.MethodGet, "/tree", "", from("192.0.2.1:1234"), withHeaders(map[string]string{forwardedForHeader: "198.51.100.9"}))
+		if i == 2 && rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected a spoofed header to be ignored, got %d", rec.Code)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, _ := parseCIDRs("10.0.0.0/8, 192.0.2.10")
+	l := &rateLimiter{trusted: trusted}
+	for _, tc := range []struct {
+		remoteAddr, forwardedFor, expected string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		{"10.1.0.1:1234", "", "10.1.0.1"},
+		{"10.1.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.1.0.1:1234", "203.0.113.7, 198.51.100.1, 192.0.2.10", "198.51.100.1"},
+		{"10.1.0.1:1234", "10.2.0.1, 10.3.0.1", "10.2.0.1"},
+		{"10.1.0.1:1234", "198.51.100.1, unknown", "10.1.0.1"},
+		{"[2001:db8::1]:1234", "198.51.100.1", "2001:db8::1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/tree", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			req.Header.Set(forwardedForHeader, tc.forwardedFor)
+		}
+		if ip := l.clientIP(req); ip != tc.expected {
+			t.Errorf("%s via %q: expected %s, got %s", tc.remoteAddr, tc.forwardedFor, tc.expected, ip)
+		}
+	}
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	h := newTestServer(defaultTrees, func(cfg *config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.Burst = 2
+		cfg.Auth.APIKeys = []apiKeyConfig{{Name: "ci", Hash: hashAPIKey("secret-ci-key"), Scopes: scopeTreesWrite}}
+	}).handler()
+	// guessing api keys is limited like any other request
+	for i, status := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		rec := record(h, http.MethodGet, "/tree", "", from("192.0.2.1:1234"), withHeaders(map[string]string{apiKeyHeader: "guess"}))
+		if rec.Code != status {
+			t.Errorf("request %d: expected %d, got %d", i, status, rec.Code)
+		}
+	}
+}
+
+func TestRateLimitPerCaller(t *testing.T) {
+	h := newTestServer(defaultTrees, func(cfg *config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.Burst = 2
+		cfg.Auth.APIKeys = []apiKeyConfig{
+			{Name: "ci", Hash: hashAPIKey("secret-ci-key"), Scopes: scopeTreesRead},
+			{Name: "cd", Hash: hashAPIKey("secret-cd-key"), Scopes: scopeTreesRead},
+		}
+	}).handler()
+	// both keys are used from the same address, each has its own bucket
+	for _, key := range []string{"secret-ci-key", "secret-cd-key"} {
+		for i, status := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			rec := record(h, http.MethodGet, "/tree", "", from("192.0.2.1:1234"), withHeaders(map[string]string{apiKeyHeader: key}))
+			if rec.Code != status {
+				t.Errorf("%s request %d: expected %d, got %d", key, i, status, rec.Code)
+			}
+		}
+	}
+	// the requests of the keys were not counted against the address
+	for i := 0; i < 2; i++ {
+		if rec := record(h, http.MethodGet, "/tree", "", from("192.0.2.1:1234")); rec.Code != http.StatusOK {
+			t.Errorf("anonymous request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+}
+
+func TestRateLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	cfg := defaultConfig().RateLimit
+	cfg.Enabled = true
+	cfg.MaxClients = 2
+	l, err := newRateLimiter(cfg, newMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lim := limit{rate: 1, burst: 1}
+	l.take("a", "", lim)
+	// the buckets of the routes of a client count as one client
+	l.take("a", "GET /trees", lim)
+	l.take("b", "", lim)
+	l.take("a", "", lim)
+	l.take("c", "", lim)
+	if _, ok := l.clients["b"]; ok || len(l.clients) != 2 || l.lru.Len() != 2 {
+		t.Errorf("expected b to be evicted, got %v", l.clients)
+	}
+	if _, wait := l.take("a", "GET /trees", lim); wait == 0 {
+		t.Error("expected the buckets of a to be kept")
+	}
+}
+
+func TestRateLimitRoutesMustExist(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.Routes = []routeLimitConfig{{Route: "GET /tress", Rate: 1, Burst: 1},
+		{Route: "GET /livez", Rate: 1, Burst: 1}}
+	err := cfg.validate()
+	for _, route := range []string{"GET /tress", "GET /livez"} {
+		if err == nil || !strings.Contains(err.Error(), route) {
+			t.Errorf("expected %s to be refused, got %v", route, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// router dispatches requests by method and path pattern. Patterns consist of
+// literal segments and parameters in curly braces, e.g. "/trees/{id}". Literal
+// segments take precedence over parameters. For every registered route HEAD
+// is answered by the GET handler and OPTIONS is answered automatically. If a
+// path matches but the method does not, 405 is returned with an Allow header.
+type router struct {
+	routes []*route
+	// limited are the rate limited routes, e.g. "GET /tree"
+	limited map[string]bool
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+type paramsKey struct{}
+
+type routeKey struct{}
+
+func newRouter() *router {
+	return &router{limited: map[string]bool{}}
+}
+
+// Handle registers h for the given method and pattern
+func (rt *router) Handle(method, pattern string, h http.Handler) {
+	for _, r := range rt.routes {
+		if r.pattern == pattern {
+			if _, ok := r.handlers[method]; ok {
+				panic(fmt.Sprintf("router: duplicate route %s %s", method, pattern))
+			}
+			r.handlers[method] = h
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handlers: map[string]http.Handler{method: h},
+	})
+}
+
+// HandleFunc registers f for the given method and pattern
+func (rt *router) HandleFunc(method, pattern string, f http.HandlerFunc) {
+	rt.Handle(method, pattern, f)
+}
+
+// HandleLimited registers f like HandleFunc and marks the route as rate
+// limited
+func (rt *router) HandleLimited(method, pattern string, f http.HandlerFunc) {
+	rt.Handle(method, pattern, f)
+	rt.limited[method+" "+pattern] = true
+}
+
+// lookup returns the route serving r, e.g. "GET /trees/{id}", and whether it
+// is rate limited. Requests no route serves, which are answered with 404 or
+// 405, return "" and are limited.
+func (rt *router) lookup(r *http.Request) (string, bool) {
+	rte, _ := rt.match(r.URL.Path)
+	if rte == nil {
+		return "", true
+	}
+	method := r.Method
+	if _, ok := rte.handlers[method]; !ok && method == http.MethodHead {
+		method = http.MethodGet
+	}
+	if _, ok := rte.handlers[method]; !ok {
+		return "", true
+	}
+	route := method + " " + rte.pattern
+	return route, rt.limited[route]
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rte, params := rt.match(r.URL.Path)
+	if rte == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound,
+			newMessage("path \"%s\" does not exist", r.URL.Path))
+		return
+	}
+	if pattern, ok := r.Context().Value(routeKey{}).(*string); ok {
+		*pattern = rte.pattern
+	}
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+	}
+
+	if h, ok := rte.handlers[r.Method]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		if h, ok := rte.handlers[http.MethodGet]; ok {
+			h.ServeHTTP(headResponseWriter{w}, r)
+			return
+		}
+	case http.MethodOptions:
+		w.Header().Set("Allow", rte.allow())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Allow", rte.allow())
+	writeMethodNotAllowed(w, r)
+}
+
+// match returns the most specific route for path along with its parameters
+func (rt *router) match(path string) (*route, map[string]string) {
+	segments := splitPath(path)
+	var best *route
+	for _, r := range rt.routes {
+		if !r.matches(segments) {
+			continue
+		}
+		if best == nil || r.moreSpecific(best) {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	params := map[string]string{}
+	for i, s := range best.segments {
+		if name, ok := paramName(s); ok {
+			params[name] = segments[i]
+		}
+	}
+	return best, params
+}
+
+func (r *route) matches(segments []string) bool {
+	if len(segments) != len(r.segments) {
+		return false
+	}
+	for i, s := range r.segments {
+		if _, ok := paramName(s); ok {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if s != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific reports whether r has a literal segment at the first position
+// where other has a parameter
+func (r *route) moreSpecific(other *route) bool {
+	for i, s := range r.segments {
+		_, param := paramName(s)
+		_, otherParam := paramName(other.segments[i])
+		if param != otherParam {
+			return otherParam
+		}
+	}
+	return false
+}
+
+func (r *route) allow() string {
+	methods := []string{http.MethodOptions}
+	for m := range r.handlers {
+		methods = append(methods, m)
+	}
+	_, hasGet := r.handlers[http.MethodGet]
+	_, hasHead := r.handlers[http.MethodHead]
+	if hasGet && !hasHead {
+		methods = append(methods, http.MethodHead)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// recordRoute returns a copy of r in which the router stores the pattern of
+// the route it matches, so that middlewares can report it
+func recordRoute(r *http.Request) (*http.Request, *string) {
+	if pattern, ok := r.Context().Value(routeKey{}).(*string); ok {
+		return r, pattern
+	}
+	pattern := new(string)
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, pattern)), pattern
+}
+
+// pathParam returns the value of the named path parameter of the matched route
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func paramName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// headResponseWriter discards the body so that GET handlers can answer HEAD
+// requests. Writers whose headers depend on the size of the body, like the
+// compressing one, are told the size.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// bodyCounter is implemented by response writers that need to know the size
+// of the discarded body of HEAD responses
+type bodyCounter interface {
+	countBody(n int)
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	if c, ok := w.ResponseWriter.(bodyCounter); ok {
+		c.countBody(len(b))
+	}
+	return len(b), nil
+}
+
+// Flush sends the headers, the body is discarded anyway
+func (w headResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	rt := newRouter()
+	echo := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + ":" + pathParam(r, "id")))
+		}
+	}
+	rt.HandleFunc(http.MethodGet, "/trees/{id}", echo("get"))
+	rt.HandleFunc(http.MethodDelete, "/trees/{id}", echo("delete"))
+	rt.HandleFunc(http.MethodGet, "/trees/search", echo("search"))
+
+	tests := []struct {
+		method, path string
+		status       int
+		body, allow  string
+	}{
+		{http.MethodGet, "/trees/oak", http.StatusOK, "get:oak", ""},
+		{http.MethodDelete, "/trees/oak/", http.StatusOK, "delete:oak", ""},
+		{http.MethodGet, "/trees/search", http.StatusOK, "search:", ""},
+		{http.MethodHead, "/trees/oak", http.StatusOK, "", ""},
+		{http.MethodOptions, "/trees/oak", http.StatusNoContent, "", "DELETE, GET, HEAD, OPTIONS"},
+		{http.MethodPost, "/trees/oak", http.StatusMethodNotAllowed, "", "DELETE, GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/bushes/oak", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/trees", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		rec := record(rt, tt.method, tt.path, "")
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s %s: expected body %q, got %q", tt.method, tt.path, tt.body, rec.Body.String())
+		}
+		if tt.method == http.MethodHead && rec.Body.Len() != 0 {
+			t.Errorf("HEAD %s: expected empty body, got %q", tt.path, rec.Body.String())
+		}
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", tt.method, tt.path, tt.allow, got)
+		}
+		if rec.Code >= 400 && rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: expected a json error", tt.method, tt.path)
+		}
+	}
+}
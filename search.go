@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const maxSearchResults = 100
+
+// Limits of search queries. Every term is compared to every indexed term, so
+// long queries are expensive.
+const (
+	maxQueryLength = 200
+	maxQueryTerms  = 10
+)
+
+// Weights of matches in the different fields of a tree
+const (
+	weightName           = 3
+	weightScientificName = 2
+	weightDescription    = 1
+)
+
+// Weights of the different kinds of term matches
+const (
+	matchExact  = 1.0
+	matchPrefix = 0.7
+	matchFuzzy  = 0.5
+)
+
+// searchIndex is an inverted index over the names and descriptions of the
+// trees. It is safe for concurrent use.
+type searchIndex struct {
+	mu sync.RWMutex
+	// postings maps a term to the weight it has in each tree
+	postings map[string]map[string]float64
+	// terms holds the terms of every tree so they can be removed again
+	terms map[string][]string
+}
+
+func newSearchIndex(trees []Tree) *searchIndex {
+	idx := &searchIndex{
+		postings: map[string]map[string]float64{},
+		terms:    map[string][]string{},
+	}
+	for _, t := range trees {
+		idx.add(t)
+	}
+	return idx
+}
+
+// add indexes t, replacing an earlier version of it
+func (idx *searchIndex) add(t Tree) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(t.ID)
+
+	// a term counts once per field, so trees mentioning it in several
+	// fields rank higher
+	weights := map[string]float64{}
+	for _, f := range []struct {
+		text   string
+		weight float64
+	}{
+		{t.Species, weightName},
+		{t.CommonName, weightName},
+		{t.ScientificName, weightScientificName},
+		{t.Description, weightDescription},
+	} {
+		seen := map[string]bool{}
+		for _, term := range tokenize(f.text) {
+			if !seen[term] {
+				seen[term] = true
+				weights[term] += f.weight
+			}
+		}
+	}
+	for term, w := range weights {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[string]float64{}
+		}
+		idx.postings[term][t.ID] = w
+		idx.terms[t.ID] = append(idx.terms[t.ID], term)
+	}
+}
+
+// delete removes the tree with the given id from the index
+func (idx *searchIndex) delete(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+func (idx *searchIndex) remove(id string) {
+	for _, term := range idx.terms[id] {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.terms, id)
+}
+
+// searchHit is the id of a matching tree with its relevance
+type searchHit struct {
+	ID    string
+	Score float64
+}
+
+// search returns the ids of the trees matching query ordered by descending
+// relevance. Query terms match index terms exactly, as prefix or with a small
+// edit distance.
+func (idx *searchIndex) search(query string) []searchHit {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	scores := map[string]float64{}
+	docs := float64(len(idx.terms))
+	for _, q := range tokenize(query) {
+		// the best match of the query term in every tree
+		best := map[string]float64{}
+		for term, postings := range idx.postings {
+			quality := matchQuality(q, term)
+			if quality == 0 {
+				continue
+			}
+			idf := math.Log(1 + docs/float64(len(postings)))
+			for id, weight := range postings {
+				best[id] = math.Max(best[id], quality*weight*idf)
+			}
+		}
+		for id, score := range best {
+			scores[id] += score
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, searchHit{id, score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// matchQuality rates how well the query term q matches the index term
+func matchQuality(q, term string) float64 {
+	switch {
+	case q == term:
+		return matchExact
+	case len(q) >= 3 && strings.HasPrefix(term, q):
+		return matchPrefix
+	}
+	maxDistance := 1
+	if len(q) > 7 {
+		maxDistance = 2
+	}
+	if len(q) < 4 || abs(len(q)-len(term)) > maxDistance {
+		return 0
+	}
+	if d := editDistance(q, term); d <= maxDistance {
+		return matchFuzzy / float64(d)
+	}
+	return 0
+}
+
+// tokenize splits text into lower case terms and reduces them to their stem
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) < 2 || stopWords[w] {
+			continue
+		}
+		terms = append(terms, stem(w))
+	}
+	return terms
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "by": true,
+	"for": true, "from": true, "in": true, "into": true, "is": true, "it": true, "its": true,
+	"of": true, "on": true, "or": true, "that": true, "the": true, "to": true, "with": true,
+}
+
+// stem strips common english suffixes. It is deliberately simple: queries
+// and index terms are stemmed alike, so it only needs to be consistent.
+func stem(w string) string {
+	for _, s := range []struct{ suffix, replacement string }{
+		{"ies", "y"},
+		{"ing", ""},
+		{"ed", ""},
+		{"es", ""},
+		{"s", ""},
+	} {
+		if strings.HasSuffix(w, s.suffix) && len(w)-len(s.suffix) >= 3 && !strings.HasSuffix(w, "ss") {
+			return strings.TrimSuffix(w, s.suffix) + s.replacement
+		}
+	}
+	return w
+}
+
+// editDistance is the optimal string alignment distance of a and b, i.e. the
+// number of insertions, deletions, substitutions and transpositions of
+// adjacent characters needed to turn a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				if t := d[i-2][j-2] + 1; t < d[i][j] {
+					d[i][j] = t
+				}
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func min3(a, b, c int) int {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// indexedStore keeps a search index in sync with the wrapped store
+type indexedStore struct {
+	TreeStore
+	index *searchIndex
+}
+
+func newIndexedStore(store TreeStore) (*indexedStore, error) {
+	trees, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	return &indexedStore{store, newSearchIndex(trees)}, nil
+}
+
+func (s *indexedStore) Create(t Tree) error {
+	if err := s.TreeStore.Create(t); err != nil {
+		return err
+	}
+	s.index.add(t)
+	return nil
+}
+
+func (s *indexedStore) Put(t Tree) (bool, error) {
+	created, err := s.TreeStore.Put(t)
+	if err != nil {
+		return false, err
+	}
+	s.index.add(t)
+	return created, nil
+}
+
+func (s *indexedStore) Update(id string, fn func(*Tree) error) (Tree, error) {
+	t, err := s.TreeStore.Update(id, fn)
+	if err != nil {
+		return t, err
+	}
+	s.index.add(t)
+	return t, nil
+}
+
+func (s *indexedStore) Delete(id string) error {
+	if err := s.TreeStore.Delete(id); err != nil {
+		return err
+	}
+	s.index.delete(id)
+	return nil
+}
+
+type searchResults struct {
+	XMLName xml.Name      `json:"-" yaml:"-" xml:"searchResults"`
+	Query   string        `json:"query" yaml:"query" xml:"query"`
+	Results []searchMatch `json:"results" yaml:"results" xml:"result"`
+}
+
+type searchMatch struct {
+	Score float64 `json:"score" yaml:"score" xml:"score,attr"`
+	Tree  Tree    `json:"tree" yaml:"tree" xml:"tree"`
+}
+
+func (r searchResults) plainText() string {
+	lines := make([]string, len(r.Results))
+	for i, m := range r.Results {
+		lines[i] = fmt.Sprintf("%.3f %s", m.Score, m.Tree.plainText())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func validateSearchQuery(query string) []fieldError {
+	terms := len(tokenize(query))
+	switch {
+	case terms == 0:
+		return []fieldError{newFieldError("q", "must contain at least one search term")}
+	case len([]rune(query)) > maxQueryLength:
+		return []fieldError{newFieldError("q", "must not be longer than %d characters", maxQueryLength)}
+	case terms > maxQueryTerms:
+		return []fieldError{newFieldError("q", "must not contain more than %d search terms", maxQueryTerms)}
+	}
+	return nil
+}
+
+// searchTrees serves the trees matching the q parameter ordered by relevance
+func (s *server) searchTrees(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if errs := validateSearchQuery(query); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, newMessage("invalid search"), errs...)
+		return
+	}
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxSearchResults {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, newMessage("invalid search"),
+				newFieldError("limit", "must be between 1 and %d", maxSearchResults))
+			return
+		}
+		limit = l
+	}
+
+	results := searchResults{Query: query, Results: []searchMatch{}}
+	store := s.store(r.Context())
+	for _, hit := range s.index.search(query) {
+		if len(results.Results) == limit {
+			break
+		}
+		t, err := store.Get(hit.ID)
+		if err == errTreeNotFound {
+			continue
+		}
+		if err != nil {
+			writeStoreError(w, r, err, hit.ID)
+			return
+		}
+		results.Results = append(results.Results, searchMatch{math.Round(hit.Score*1000) / 1000, t})
+	}
+	setLastModified(w, s.trees.LastModified())
+	respond(w, r, http.StatusOK, results)
+}
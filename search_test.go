@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func searchIDs(t *testing.T, h http.Handler, query string) []string {
+	rec := record(h, http.MethodGet, "/trees/search?q="+url.QueryEscape(query), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: unexpected status %d: %s", query, rec.Code, rec.Body.String())
+	}
+	var results searchResults
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, m := range results.Results {
+		ids = append(ids, m.Tree.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	for _, tc := range []struct {
+		query string
+		first string
+	}{
+		{"sequoia", "sequoia"},
+		{"sequioa", "sequoia"},
+		{"Ginko", "ginkgo"},
+		{"quercus", "english-oak"},
+		{"oaks", "english-oak"},
+		{"adansonia digitata", "baobab"},
+		{"beec", "european-beech"},
+	} {
+		ids := searchIDs(t, h, tc.query)
+		if len(ids) == 0 || ids[0] != tc.first {
+			t.Errorf("%s: expected %s first, got %v", tc.query, tc.first, ids)
+		}
+	}
+
+	if ids := searchIDs(t, h, "xylophone"); len(ids) != 0 {
+		t.Errorf("expected no results, got %v", ids)
+	}
+}
+
+func TestSearchInvalid(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+	for _, path := range []string{
+		"/trees/search",
+		"/trees/search?q=+",
+		"/trees/search?q=oak&limit=0",
+		"/trees/search?q=" + strings.Repeat("o", maxQueryLength+1),
+		"/trees/search?q=" + strings.Repeat("oak+", maxQueryTerms+1),
+	} {
+		if rec := record(h, http.MethodGet, path, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	body := `{"id":"rowan","species":"rowan","commonName":"Rowan","scientificName":"Sorbus aucuparia",
+		"family":"Rosaceae","nativeRegions":["Europe"],"maxHeightMetres":15,"lifespanYears":200}`
+	if rec := record(h, http.MethodPost, "/trees", body); rec.Code != http.StatusCreated {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if ids := searchIDs(t, h, "sorbus"); len(ids) != 1 || ids[0] != "rowan" {
+		t.Fatalf("expected the created tree, got %v", ids)
+	}
+
+	if rec := record(h, http.MethodPatch, "/trees/rowan", `{"commonName":"Mountain ash"}`); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if ids := searchIDs(t, h, "mountain"); len(ids) != 1 || ids[0] != "rowan" {
+		t.Fatalf("expected the updated tree, got %v", ids)
+	}
+	if ids := searchIDs(t, h, "rowan"); len(ids) != 1 {
+		t.Fatalf("expected the species to still match, got %v", ids)
+	}
+
+	if rec := record(h, http.MethodDelete, "/trees/rowan", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	if ids := searchIDs(t, h, "sorbus"); len(ids) != 0 {
+		t.Fatalf("expected the deleted tree to be gone, got %v", ids)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		distance int
+	}{
+		{"sequoia", "sequoia", 0},
+		{"sequioa", "sequoia", 1},
+		{"ginko", "ginkgo", 1},
+		{"oak", "ash", 3},
+		{"", "abc", 3},
+	} {
+		if d := editDistance(tc.a, tc.b); d != tc.distance {
+			t.Errorf("%s/%s: expected %d, got %d", tc.a, tc.b, tc.distance, d)
+		}
+	}
+}
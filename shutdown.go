@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// signalContext returns a context that is cancelled once one of the given
+// signals is received
+func signalContext(signals ...os.Signal) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	go func() {
+		sig := <-c
+		log.Printf("received signal %s", sig)
+		signal.Stop(c)
+		cancel()
+	}()
+	return ctx
+}
+
+// draining reports whether the server is shutting down
+func (s *server) draining() bool {
+	return atomic.LoadInt32(&s.drain) == 1
+}
+
+// startDraining fails the readiness checks and ends the streams of changes,
+// so that their clients reconnect to another pod. Webhooks are delivered
+// until the server shut down.
+func (s *server) startDraining() {
+	atomic.StoreInt32(&s.drain, 1)
+	s.grpcHealth.Shutdown()
+	s.events.close()
+}
+
+// serve serves srv on l until ctx is cancelled. It then marks s as draining so
+// that the readiness check fails, keeps serving for the drain period to give
+// kubernetes time to stop routing traffic to the pod and finally shuts srv
+// down, waiting up to timeout for in-flight requests and pending webhook
+// deliveries to complete.
+func serve(ctx context.Context, srv *http.Server, l net.Listener, s *server, drain, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("draining connections for %s", drain)
+	s.startDraining()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(drain):
+	}
+
+	log.Printf("shutting down, waiting up to %s for in-flight requests", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	// the changes of the last requests are delivered in the rest of the
+	// timeout
+	s.webhooks.shutdown(shutdownCtx)
+	if err != nil {
+		return fmt.Errorf("shutdown: %s", err)
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeCompletesInFlightRequests(t *testing.T) {
+	s := newTestServer(defaultTrees)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	rt := s.routes().(*router)
+	rt.HandleFunc(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, &http.Server{Handler: rt}, l, s, 200*time.Millisecond, 5*time.Second)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		slow <- result{string(b), err}
+	}()
+	<-started
+	cancel()
+
+	// while draining the server keeps serving but reports that it is not ready
+	time.Sleep(50 * time.Millisecond)
+	resp, err := http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the health check to fail while draining, got %d", resp.StatusCode)
+	}
+
+	// the shutdown waits for the in-flight request
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the in-flight request completed: %v", err)
+	default:
+	}
+	close(release)
+
+	if r := <-slow; r.err != nil || r.body != "done" {
+		t.Fatalf("expected the in-flight request to complete, got %q, %v", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("expected a clean shutdown, got %v", err)
+	}
+	if _, err := http.Get(url + "/healthz"); err == nil {
+		t.Fatal("expected the server to be closed")
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	s := newTestServer(defaultTrees)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	rt := newRouter()
+	rt.HandleFunc(http.MethodGet, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, &http.Server{Handler: rt}, l, s, 0, 100*time.Millisecond)
+	}()
+	go http.Get("http://" + l.Addr().String() + "/slow")
+	<-started
+	cancel()
+
+	if err := <-served; err == nil {
+		t.Fatal("expected an error when in-flight requests exceed the shutdown timeout")
+	}
+}
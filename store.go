@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Names of the available TreeStore backends
+const (
+	storeMemory = "memory"
+	storeFile   = "file"
+	storeLog    = "log"
+)
+
+// TreeStore persists the tree catalogue and the favourite tree of every
+// user. Implementations are safe for concurrent use. Lookups of unknown ids
+// fail with errTreeNotFound.
+type TreeStore interface {
+	// List returns all trees ordered by their id
+	List() ([]Tree, error)
+	// Get returns the tree with the given id
+	Get(id string) (Tree, error)
+	// Create adds a new tree and fails with errTreeExists if the id is taken
+	Create(t Tree) error
+	// Put stores a tree, replacing an existing one with the same id. It
+	// reports whether the tree was newly created.
+	Put(t Tree) (bool, error)
+	// Update applies fn to the tree with the given id and stores the result
+	// unless fn returns an error
+	Update(id string, fn func(*Tree) error) (Tree, error)
+	// Delete removes the tree with the given id
+	Delete(id string) error
+	// Favourite returns the id of the favourite tree of user. It fails with
+	// errFavouriteNotSet if the user has not picked one.
+	Favourite(user string) (string, error)
+	// SetFavourite makes the tree with the given id the favourite of user
+	SetFavourite(user, treeID string) error
+	// LastModified returns the time of the last change to the trees or
+	// favourites. Changes made before the store was opened are reported as
+	// made when it was opened.
+	LastModified() time.Time
+	// Ping reports whether the underlying storage is reachable
+	Ping() error
+	// Close releases all resources held by the store
+	Close() error
+}
+
+// openStore creates the TreeStore backend with the given name. Persistent
+// backends that start out empty are seeded with the given trees.
+func openStore(backend, path string, seed []Tree) (TreeStore, error) {
+	switch backend {
+	case storeMemory:
+		return newMemoryStore(seed), nil
+	case storeFile:
+		return openFileStore(path, seed)
+	case storeLog:
+		return openLogStore(path, seed)
+	default:
+		return nil, fmt.Errorf("unknown store backend \"%s\"", backend)
+	}
+}
+
+// mutation is a single change to the content of a store. It either puts or
+// deletes the tree with the given id or sets the favourite of a user.
+type mutation struct {
+	ID        string `json:"id,omitempty"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	Tree      *Tree  `json:"tree,omitempty"`
+	User      string `json:"user,omitempty"`
+	Favourite string `json:"favourite,omitempty"`
+}
+
+func (m mutation) valid() bool {
+	if m.User != "" {
+		return m.Favourite != ""
+	}
+	return m.ID != "" && (m.Deleted || m.Tree != nil)
+}
+
+// storeState is the complete content of a store
+type storeState struct {
+	trees      map[string]Tree
+	favourites map[string]string
+}
+
+func newStoreState(trees []Tree, favourites map[string]string) storeState {
+	st := storeState{
+		trees:      make(map[string]Tree, len(trees)),
+		favourites: make(map[string]string, len(favourites)),
+	}
+	for _, t := range trees {
+		st.trees[t.ID] = t
+	}
+	for user, id := range favourites {
+		st.favourites[user] = id
+	}
+	return st
+}
+
+func (st storeState) apply(m mutation) {
+	switch {
+	case m.User != "":
+		st.favourites[m.User] = m.Favourite
+	case m.Deleted:
+		delete(st.trees, m.ID)
+	default:
+		st.trees[m.ID] = *m.Tree
+	}
+}
+
+func (st storeState) clone() storeState {
+	return newStoreState(sortedTrees(st.trees), st.favourites)
+}
+
+// size returns the number of records needed to describe the state
+func (st storeState) size() int {
+	return len(st.trees) + len(st.favourites)
+}
+
+// mutations returns the records describing the state
+func (st storeState) mutations() []mutation {
+	var result []mutation
+	for _, t := range sortedTrees(st.trees) {
+		t := t
+		result = append(result, mutation{ID: t.ID, Tree: &t})
+	}
+	users := make([]string, 0, len(st.favourites))
+	for user := range st.favourites {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		result = append(result, mutation{User: user, Favourite: st.favourites[user]})
+	}
+	return result
+}
+
+// memoryStore keeps all trees and favourites in maps. Persistent backends
+// embed it and set persist, which is called with the store locked before a
+// mutation becomes visible. If persist fails the mutation is discarded.
+type memoryStore struct {
+	mu       sync.RWMutex
+	state    storeState
+	modified time.Time
+	persist  func(m mutation) error
+}
+
+func newMemoryStore(trees []Tree) *memoryStore {
+	return &memoryStore{state: newStoreState(trees, nil), modified: time.Now()}
+}
+
+func (s *memoryStore) List() ([]Tree, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedTrees(s.state.trees), nil
+}
+
+func (s *memoryStore) Get(id string) (Tree, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.state.trees[id]
+	if !ok {
+		return Tree{}, errTreeNotFound
+	}
+	return t, nil
+}
+
+func (s *memoryStore) Create(t Tree) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.state.trees[t.ID]; ok {
+		return errTreeExists
+	}
+	return s.apply(mutation{ID: t.ID, Tree: &t})
+}
+
+func (s *memoryStore) Put(t Tree) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.state.trees[t.ID]
+	if err := s.apply(mutation{ID: t.ID, Tree: &t}); err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+func (s *memoryStore) Update(id string, fn func(*Tree) error) (Tree, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.state.trees[id]
+	if !ok {
+		return Tree{}, errTreeNotFound
+	}
+	t.NativeRegions = append([]string(nil), t.NativeRegions...)
+	if err := fn(&t); err != nil {
+		return Tree{}, err
+	}
+	if err := s.apply(mutation{ID: id, Tree: &t}); err != nil {
+		return Tree{}, err
+	}
+	return t, nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.state.trees[id]; !ok {
+		return errTreeNotFound
+	}
+	return s.apply(mutation{ID: id, Deleted: true})
+}
+
+func (s *memoryStore) Favourite(user string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.state.favourites[user]
+	if !ok {
+		return "", errFavouriteNotSet
+	}
+	return id, nil
+}
+
+func (s *memoryStore) SetFavourite(user, treeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.state.trees[treeID]; !ok {
+		return errTreeNotFound
+	}
+	return s.apply(mutation{User: user, Favourite: treeID})
+}
+
+func (s *memoryStore) LastModified() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.modified
+}
+
+func (s *memoryStore) Ping() error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// apply persists and then performs m. The caller must hold the write lock.
+func (s *memoryStore) apply(m mutation) error {
+	if s.persist != nil {
+		if err := s.persist(m); err != nil {
+			return err
+		}
+	}
+	s.state.apply(m)
+	s.modified = time.Now()
+	return nil
+}
+
+// stateWith returns a copy of the current state with m applied. The caller
+// must hold the lock.
+func (s *memoryStore) stateWith(m mutation) storeState {
+	st := s.state.clone()
+	st.apply(m)
+	return st
+}
+
+func sortedTrees(trees map[string]Tree) []Tree {
+	result := make([]Tree, 0, len(trees))
+	for _, t := range trees {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// fileStore keeps the catalogue in memory and writes the complete set of
+// trees and favourites to a json file on every change. Writes go to a
+// temporary file that is renamed over the original so the file is never left
+// half written.
+type fileStore struct {
+	*memoryStore
+	path string
+}
+
+// fileContent is the layout of the file written by the fileStore
+type fileContent struct {
+	Trees      []Tree            `json:"trees"`
+	Favourites map[string]string `json:"favourites,omitempty"`
+}
+
+func openFileStore(path string, seed []Tree) (*fileStore, error) {
+	s := &fileStore{path: path}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		s.memoryStore = newMemoryStore(seed)
+		if err := s.write(s.state); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("read tree file \"%s\": %s", path, err)
+	default:
+		var content fileContent
+		if err := json.Unmarshal(b, &content); err != nil {
+			return nil, fmt.Errorf("decode tree file \"%s\": %s", path, err)
+		}
+		s.memoryStore = newMemoryStore(nil)
+		s.state = newStoreState(content.Trees, content.Favourites)
+	}
+
+	s.persist = func(m mutation) error {
+		return s.write(s.stateWith(m))
+	}
+	return s, nil
+}
+
+func (s *fileStore) Ping() error {
+	_, err := os.Stat(s.path)
+	return err
+}
+
+func (s *fileStore) write(st storeState) error {
+	b, err := json.MarshalIndent(fileContent{sortedTrees(st.trees), st.favourites}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// writeFileAtomic replaces the file at path with data. The data is synced to
+// disk before the rename so a crash leaves either the old or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary file in \"%s\": %s", dir, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write \"%s\": %s", f.Name(), err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync \"%s\": %s", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close \"%s\": %s", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("rename \"%s\" to \"%s\": %s", f.Name(), path, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes a preceding rename in dir durable. Not every platform
+// supports syncing directories, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// minCompactRecords is the number of log records below which the log is
+// never compacted
+const minCompactRecords = 1000
+
+// logStore keeps the catalogue in memory and appends every change as a json
+// line to a log file. On startup the log is replayed to restore the state.
+// Once the log holds more than twice as many records as are needed to
+// describe the state it is compacted.
+type logStore struct {
+	*memoryStore
+	path    string
+	f       *os.File
+	records int
+}
+
+func openLogStore(path string, seed []Tree) (*logStore, error) {
+	s := &logStore{path: path, memoryStore: newMemoryStore(nil)}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		s.memoryStore = newMemoryStore(seed)
+		if err := s.compact(s.state); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("open tree log \"%s\": %s", path, err)
+	default:
+		valid, err := s.replay(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if s.f, err = os.OpenFile(path, os.O_RDWR, 0644); err != nil {
+			return nil, fmt.Errorf("open tree log \"%s\": %s", path, err)
+		}
+		// drop a partially written record left behind by a crash
+		if err := s.f.Truncate(valid); err != nil {
+			s.f.Close()
+			return nil, fmt.Errorf("truncate tree log \"%s\": %s", path, err)
+		}
+		if _, err := s.f.Seek(valid, io.SeekStart); err != nil {
+			s.f.Close()
+			return nil, fmt.Errorf("seek tree log \"%s\": %s", path, err)
+		}
+	}
+
+	s.persist = s.append
+	return s, nil
+}
+
+// replay applies all complete records in r and returns the number of bytes
+// they occupy
+func (s *logStore) replay(r io.Reader) (int64, error) {
+	var valid int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("ignoring incomplete record at the end of tree log \"%s\"", s.path)
+			}
+			return valid, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read tree log \"%s\": %s", s.path, err)
+		}
+		var m mutation
+		if err := json.Unmarshal(line, &m); err != nil || !m.valid() {
+			return 0, fmt.Errorf("corrupt record at offset %d of tree log \"%s\"", valid, s.path)
+		}
+		s.state.apply(m)
+		valid += int64(len(line))
+		s.records++
+	}
+}
+
+func (s *logStore) append(m mutation) error {
+	if s.records+1 > minCompactRecords && s.records+1 > 2*s.state.size() {
+		return s.compact(s.stateWith(m))
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	end, err := s.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("seek tree log \"%s\": %s", s.path, err)
+	}
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		s.rollback(end)
+		return fmt.Errorf("append to tree log \"%s\": %s", s.path, err)
+	}
+	if err := s.f.Sync(); err != nil {
+		s.rollback(end)
+		return fmt.Errorf("sync tree log \"%s\": %s", s.path, err)
+	}
+	s.records++
+	return nil
+}
+
+// rollback drops a partially written record at the end of the log, so that
+// the following records are not appended to it. The change is not applied
+// to the state, so it must not be replayed either.
+func (s *logStore) rollback(end int64) {
+	if err := s.f.Truncate(end); err != nil {
+		log.Printf("failed to truncate tree log \"%s\" after a failed write: %s", s.path, err)
+		return
+	}
+	s.f.Seek(end, io.SeekStart)
+}
+
+// compact atomically replaces the log with the minimal records describing st
+func (s *logStore) compact(st storeState) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, m := range st.mutations() {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(s.path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open tree log \"%s\": %s", s.path, err)
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = f
+	s.records = st.size()
+	return nil
+}
+
+func (s *logStore) Ping() error {
+	_, err := os.Stat(s.path)
+	return err
+}
+
+func (s *logStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
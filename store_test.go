@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tree-spotter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestStores(t *testing.T) {
+	for _, backend := range []string{storeMemory, storeFile, storeLog} {
+		t.Run(backend, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "trees")
+			store, err := openStore(backend, path, defaultTrees)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			oak := Tree{ID: "oak", Species: "Oak"}
+			if err := store.Create(oak); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Create(oak); err != errTreeExists {
+				t.Fatalf("expected errTreeExists, got %v", err)
+			}
+			if _, err := store.Update("oak", func(t *Tree) error {
+				t.Lifespan = 1000
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete("baobab"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete("baobab"); err != errTreeNotFound {
+				t.Fatalf("expected errTreeNotFound, got %v", err)
+			}
+			if _, err := store.Get("baobab"); err != errTreeNotFound {
+				t.Fatalf("expected errTreeNotFound, got %v", err)
+			}
+			if err := store.SetFavourite("jan", "oak"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetFavourite("jan", "baobab"); err != errTreeNotFound {
+				t.Fatalf("expected errTreeNotFound, got %v", err)
+			}
+			if _, err := store.Favourite("kim"); err != errFavouriteNotSet {
+				t.Fatalf("expected errFavouriteNotSet, got %v", err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if backend == storeMemory {
+				return
+			}
+
+			store, err = openStore(backend, path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			trees, _ := store.List()
+			if len(trees) != len(defaultTrees) {
+				t.Fatalf("expected %d trees after reopening, got %d", len(defaultTrees), len(trees))
+			}
+			got, err := store.Get("oak")
+			if err != nil || got.Lifespan != 1000 {
+				t.Fatalf("expected the updated oak after reopening, got %v, %v", got, err)
+			}
+			if id, err := store.Favourite("jan"); err != nil || id != "oak" {
+				t.Fatalf("expected the favourite to survive reopening, got %v, %v", id, err)
+			}
+		})
+	}
+}
+
+func TestLogStoreIgnoresTornRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trees.log")
+	store, err := openLogStore(path, defaultTrees[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"id":"oak","tree":{"id":"o`)
+	f.Close()
+
+	store, err = openLogStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(Tree{ID: "oak"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = openLogStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if trees, _ := store.List(); len(trees) != 2 {
+		t.Fatalf("expected 2 trees, got %v", trees)
+	}
+}
+
+func TestLogStoreRollsBackFailedWrites(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trees.log")
+	store, err := openLogStore(path, defaultTrees[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a write failing halfway leaves part of the record behind
+	end, _ := store.f.Seek(0, io.SeekEnd)
+	store.f.WriteString(`{"id":"ash","tree":{"id":"a`)
+	store.rollback(end)
+	if err := store.Create(Tree{ID: "oak"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = openLogStore(path, nil)
+	if err != nil {
+		t.Fatalf("expected the log to stay readable, got %s", err)
+	}
+	defer store.Close()
+	if trees, _ := store.List(); len(trees) != 2 {
+		t.Fatalf("expected 2 trees, got %v", trees)
+	}
+}
+
+func TestLogStoreCompacts(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trees.log")
+	store, err := openLogStore(path, defaultTrees[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < 2*minCompactRecords; i++ {
+		if _, err := store.Put(Tree{ID: "oak", Lifespan: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.records > minCompactRecords+1 {
+		t.Fatalf("expected the log to be compacted, it holds %d records", store.records)
+	}
+	got, _ := store.Get("oak")
+	if got.Lifespan != 2*minCompactRecords-1 {
+		t.Fatalf("unexpected oak after compaction %v", got)
+	}
+}
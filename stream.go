@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Names of the live events besides the changes to the catalogue, which are
+// named after the type of the change
+const (
+	// liveFavourite carries the favourite tree like GET /tree
+	liveFavourite = "favourite"
+	// liveReset tells clients that events were lost and they have to reload
+	// what they show
+	liveReset = "reset"
+)
+
+const (
+	// streamRetry is how long clients wait before reconnecting a stream
+	streamRetry = 3 * time.Second
+	// streamWriteWait bounds the time to send an event to a client
+	streamWriteWait = 10 * time.Second
+)
+
+var (
+	errStreamBehind  = errors.New("the stream fell behind the changes")
+	errShuttingDown  = errors.New("the server is shutting down")
+	errNotStreamable = newMessage("the response can not be streamed")
+)
+
+// liveEvent is a message of the live update streams. Its id is the id of the
+// change that caused it.
+type liveEvent struct {
+	ID   uint64
+	Name string
+	Data interface{}
+}
+
+// catalogueEvent converts a change to the catalogue to a live event
+func catalogueEvent(ev treeEvent) liveEvent {
+	if ev.Type == treeDeleted {
+		return liveEvent{ev.ID, ev.Type, map[string]string{"id": ev.Tree.ID}}
+	}
+	return liveEvent{ev.ID, ev.Type, ev.Tree}
+}
+
+// liveSink delivers live events over one connection
+type liveSink interface {
+	send(ev liveEvent) error
+	// ping keeps the connection open while there are no changes
+	ping() error
+}
+
+// liveFeed selects the events of the streams of a single user: every change
+// to the catalogue and a favourite event whenever the favourite tree of the
+// user changes
+type liveFeed struct {
+	s       *server
+	user    string
+	species string
+	events  <-chan treeEvent
+	cancel  func()
+	pending []liveEvent
+}
+
+// startFeed opens the feed of a stream request. Streams resuming after
+// lastEventID first get the changes to the catalogue they missed, or a reset
+// event if these are no longer known. Every stream then gets the current
+// favourite of the user. startFeed writes the error response and returns nil
+// if the user is invalid, too many streams are open or the favourite can not
+// be looked up.
+func (s *server) startFeed(w http.ResponseWriter, r *http.Request, lastEventID string) *liveFeed {
+	user, err := userFromRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, messageOf(err))
+		return nil
+	}
+	if !s.openStream() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(streamRetry.Seconds())))
+		writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, newMessage("too many streams are open"))
+		return nil
+	}
+	after := s.events.lastID()
+	if lastEventID != "" {
+		if after, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			// ids that were never issued can not be resumed from
+			after = math.MaxUint64
+		}
+	}
+	missed, last, complete, events, cancel := s.events.resume(after, s.streaming.Buffer)
+	f := &liveFeed{s: s, user: user, events: events, cancel: cancel}
+	if !complete {
+		f.pending = append(f.pending, liveEvent{last, liveReset, struct{}{}})
+	}
+	for _, ev := range missed {
+		if ev.Type != favouriteChanged {
+			f.pending = append(f.pending, catalogueEvent(ev))
+		}
+	}
+	t, err := s.favouriteTree(r.Context(), user)
+	if err != nil {
+		f.close()
+		writeStoreError(w, r, err, t.ID)
+		return nil
+	}
+	f.species = t.Species
+	f.pending = append(f.pending, liveEvent{last, liveFavourite, resp{MyFavouriteTree: t.Species}})
+	return f
+}
+
+func (f *liveFeed) close() {
+	f.cancel()
+	f.s.closeStream()
+}
+
+// openStream counts a new stream of changes against the MaxSubscribers limit
+// shared by the server-sent event, WebSocket and gRPC streams. It reports
+// false if too many streams are open.
+func (s *server) openStream() bool {
+	if s.streams.add(1) > int64(s.streaming.MaxSubscribers) {
+		s.streams.add(-1)
+		return false
+	}
+	return true
+}
+
+func (s *server) closeStream() {
+	s.streams.add(-1)
+}
+
+// run sends the events of the feed to sink until ctx is done, the feed falls
+// behind by more than the stream buffer or the server shuts down. Clients
+// of streams that fell behind reconnect and resume where they left off.
+func (f *liveFeed) run(ctx context.Context, sink liveSink) error {
+	for _, ev := range f.pending {
+		if err := sink.send(ev); err != nil {
+			return err
+		}
+	}
+	f.pending = nil
+	heartbeat := time.NewTicker(time.Duration(f.s.streaming.Heartbeat))
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-heartbeat.C:
+			if err := sink.ping(); err != nil {
+				return err
+			}
+		case ev, ok := <-f.events:
+			if !ok && f.s.draining() {
+				return errShuttingDown
+			}
+			if !ok {
+				return errStreamBehind
+			}
+			if err := f.deliver(ctx, ev, sink); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// deliver sends a change to the catalogue and, if the change affects the
+// favourite tree of the user, the new favourite
+func (f *liveFeed) deliver(ctx context.Context, ev treeEvent, sink liveSink) error {
+	if ev.Type == favouriteChanged {
+		if ev.User != f.user {
+			return nil
+		}
+	} else if err := sink.send(catalogueEvent(ev)); err != nil {
+		return err
+	}
+	t, err := f.s.favouriteTree(ctx, f.user)
+	if err != nil {
+		// the favourite is sent with the next change once it can be looked up
+		logger.Warn("failed to look up the favourite of a stream", fields{"error": err.Error()})
+		return nil
+	}
+	if t.Species == f.species {
+		return nil
+	}
+	f.species = t.Species
+	return sink.send(liveEvent{ev.ID, liveFavourite, resp{MyFavouriteTree: t.Species}})
+}
+
+type connKey struct{}
+
+// withConn stores the connection of the requests in their context. It is
+// the ConnContext of the http server.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// extendWriteDeadline lifts the write timeout of the server for the next
+// writes of a stream. Over HTTP/2 the write timeout still bounds the
+// duration of a stream and clients reconnect.
+func extendWriteDeadline(r *http.Request, d time.Duration) {
+	if c, ok := r.Context().Value(connKey{}).(net.Conn); ok && r.ProtoMajor == 1 {
+		c.SetWriteDeadline(time.Now().Add(d))
+	}
+}
+
+// sseSink writes events in the text/event-stream format
+type sseSink struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	r       *http.Request
+	timeout time.Duration
+}
+
+func (s sseSink) send(ev liveEvent) error {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Name, data))
+}
+
+func (s sseSink) ping() error {
+	// comments are ignored by clients
+	return s.write(": ping\n\n")
+}
+
+func (s sseSink) write(msg string) error {
+	extendWriteDeadline(s.r, s.timeout)
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// setStreamHeaders sets the headers of server-sent event streams
+func setStreamHeaders(h http.Header) {
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// proxies like nginx must not buffer the stream
+	h.Set("X-Accel-Buffering", "no")
+}
+
+// streamFavourite streams the live events as server-sent events. Clients
+// resume lost connections with the Last-Event-ID header. HEAD requests get
+// the headers of the stream without subscribing to it.
+func (s *server) streamFavourite(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		setStreamHeaders(w.Header())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, errNotStreamable)
+		return
+	}
+	feed := s.startFeed(w, r, r.Header.Get("Last-Event-ID"))
+	if feed == nil {
+		return
+	}
+	defer feed.close()
+	setStreamHeaders(w.Header())
+	w.WriteHeader(http.StatusOK)
+	sink := sseSink{w, flusher, r, time.Duration(s.streaming.Heartbeat) + streamWriteWait}
+	if err := sink.write(fmt.Sprintf("retry: %d\n\n", streamRetry/time.Millisecond)); err != nil {
+		return
+	}
+	err := feed.run(r.Context(), sink)
+	logger.Debug("stream ended", fields{"requestId": requestID(r.Context()), "reason": err.Error()})
+}
+
+// wsSink writes events as json text messages
+type wsSink struct {
+	conn *websocket.Conn
+}
+
+type wsMessage struct {
+	ID    string      `json:"id"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+func (s wsSink) send(ev liveEvent) error {
+	s.conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	return s.conn.WriteJSON(wsMessage{strconv.FormatUint(ev.ID, 10), ev.Name, ev.Data})
+}
+
+func (s wsSink) ping() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait))
+}
+
+// checkWebSocketOrigin accepts handshakes from pages of the same origin and
+// of the origins allowed by the CORS policy
+func (s *server) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return s.cors != nil && s.cors.allowsOrigin(origin)
+}
+
+// streamFavouriteWebSocket streams the live events over a WebSocket. As
+// browsers can not set headers on WebSockets, clients resume lost
+// connections with the lastEventId query parameter. WebSockets are only
+// opened with GET, so HEAD is not allowed.
+func (s *server) streamFavouriteWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeMethodNotAllowed(w, r)
+		return
+	}
+	lastEventID := r.URL.Query().Get("lastEventId")
+	if lastEventID == "" {
+		lastEventID = r.Header.Get("Last-Event-ID")
+	}
+	feed := s.startFeed(w, r, lastEventID)
+	if feed == nil {
+		return
+	}
+	defer feed.close()
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkWebSocketOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has written the error response
+		return
+	}
+	defer conn.Close()
+
+	// clients only send control frames, reading them handles the pongs and
+	// notices closed connections
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	timeout := 2 * time.Duration(s.streaming.Heartbeat)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = feed.run(ctx, wsSink{conn})
+	logger.Debug("stream ended", fields{"requestId": requestID(r.Context()), "reason": err.Error()})
+	code := websocket.CloseNormalClosure
+	switch err {
+	case errStreamBehind:
+		code = websocket.CloseTryAgainLater
+	case errShuttingDown:
+		code = websocket.CloseGoingAway
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()),
+		time.Now().Add(streamWriteWait))
+}
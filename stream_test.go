@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startStreamServer serves the complete api of a test server like main does
+func startStreamServer(configure ...func(cfg *config)) (*server, *httptest.Server) {
+	s := newTestServer(defaultTrees, configure...)
+	srv := httptest.NewUnstartedServer(s.handler())
+	srv.Config.WriteTimeout = time.Duration(testConfig(configure...).WriteTimeout)
+	srv.Config.ConnContext = withConn
+	srv.Start()
+	return s, srv
+}
+
+type sseEvent struct {
+	id, event, data, retry, comment string
+}
+
+// openStream requests a server-sent event stream
+func openStream(t *testing.T, url string, headers map[string]string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, bufio.NewReader(res.Body)
+}
+
+// readEvent reads the next block of fields of a server-sent event stream
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the stream: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return ev
+		}
+		if strings.HasPrefix(line, ":") {
+			ev.comment = strings.TrimSpace(line[1:])
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		switch parts[0] {
+		case "id":
+			ev.id = parts[1]
+		case "event":
+			ev.event = parts[1]
+		case "data":
+			ev.data = parts[1]
+		case "retry":
+			ev.retry = parts[1]
+		}
+	}
+}
+
+func expectEvents(t *testing.T, r *bufio.Reader, expected ...sseEvent) {
+	t.Helper()
+	for _, e := range expected {
+		if ev := readEvent(t, r); ev != e {
+			t.Fatalf("expected event %+v, got %+v", e, ev)
+		}
+	}
+}
+
+func TestStreamFavourite(t *testing.T) {
+	s, srv := startStreamServer()
+	defer srv.Close()
+
+	res, stream := openStream(t, srv.URL+"/tree/stream", map[string]string{userHeader: "kim"})
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	expectEvents(t, stream,
+		sseEvent{retry: "3000"},
+		sseEvent{id: "0", event: liveFavourite, data: `{"myFavouriteTree":"Sequoia"}`})
+
+	s.trees.SetFavourite("kim", "english-oak")
+	expectEvents(t, stream, sseEvent{id: "1", event: liveFavourite, data: `{"myFavouriteTree":"Oak"}`})
+	// the favourites of other users are not streamed
+	s.trees.SetFavourite("jan", "baobab")
+	s.trees.Delete("english-oak")
+	expectEvents(t, stream,
+		sseEvent{id: "3", event: treeDeleted, data: `{"id":"english-oak"}`},
+		// the deleted favourite falls back to the default
+		sseEvent{id: "3", event: liveFavourite, data: `{"myFavouriteTree":"Sequoia"}`})
+	res.Body.Close()
+
+	s.trees.SetFavourite("kim", "european-beech")
+	s.trees.Create(Tree{ID: "rowan", Species: "Rowan"})
+	res, stream = openStream(t, srv.URL+"/tree/stream", map[string]string{
+		userHeader: "kim", "Last-Event-ID": "3"})
+	defer res.Body.Close()
+	rowan, _ := json.Marshal(Tree{ID: "rowan", Species: "Rowan"})
+	expectEvents(t, stream,
+		sseEvent{retry: "3000"},
+		sseEvent{id: "5", event: treeCreated, data: string(rowan)},
+		sseEvent{id: "5", event: liveFavourite, data: `{"myFavouriteTree":"Beech"}`})
+}
+
+func TestStreamHead(t *testing.T) {
+	_, srv := startStreamServer()
+	defer srv.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Head(srv.URL + "/tree/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("expected the headers of the stream, got %d %v", res.StatusCode, res.Header)
+	}
+	if res, err = client.Head(srv.URL + "/tree/ws"); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed || res.Header.Get("Allow") != "GET, OPTIONS" {
+		t.Errorf("expected HEAD not to be allowed on the WebSocket, got %d %v", res.StatusCode, res.Header)
+	}
+}
+
+func TestStreamResetsUnknownEvents(t *testing.T) {
+	s, srv := startStreamServer()
+	defer srv.Close()
+	s.trees.SetFavourite("kim", "baobab")
+
+	for _, lastEventID := range []string{"42", "oak"} {
+		res, stream := openStream(t, srv.URL+"/tree/stream", map[string]string{"Last-Event-ID": lastEventID})
+		expectEvents(t, stream,
+			sseEvent{retry: "3000"},
+			sseEvent{id: "1", event: liveReset, data: "{}"},
+			sseEvent{id: "1", event: liveFavourite, data: `{"myFavouriteTree":"Sequoia"}`})
+		res.Body.Close()
+	}
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	_, srv := startStreamServer(func(cfg *config) {
+		cfg.Stream.Heartbeat = duration(20 * time.Millisecond)
+		// streams outlive the write timeout of the server
+		cfg.WriteTimeout = duration(100 * time.Millisecond)
+	})
+	defer srv.Close()
+
+	res, stream := openStream(t, srv.URL+"/tree/stream", nil)
+	defer res.Body.Close()
+	readEvent(t, stream)
+	readEvent(t, stream)
+	for start := time.Now(); time.Since(start) < 300*time.Millisecond; {
+		if ev := readEvent(t, stream); ev.comment != "ping" {
+			t.Fatalf("expected a ping, got %+v", ev)
+		}
+	}
+}
+
+func TestStreamSubscriberLimit(t *testing.T) {
+	_, srv := startStreamServer(func(cfg *config) { cfg.Stream.MaxSubscribers = 1 })
+	defer srv.Close()
+
+	res, _ := openStream(t, srv.URL+"/tree/stream", nil)
+	defer res.Body.Close()
+	rejected, _ := openStream(t, srv.URL+"/tree/stream", nil)
+	defer rejected.Body.Close()
+	if rejected.StatusCode != http.StatusServiceUnavailable || rejected.Header.Get("Retry-After") != "3" {
+		t.Fatalf("expected 503 with Retry-After, got %d %q",
+			rejected.StatusCode, rejected.Header.Get("Retry-After"))
+	}
+	var body errorResponse
+	json.NewDecoder(rejected.Body).Decode(&body)
+	if body.Error.Code != codeUnavailable {
+		t.Errorf("expected code %s, got %+v", codeUnavailable, body)
+	}
+}
+
+// recordingSink keeps the events sent to it
+type recordingSink struct {
+	events []liveEvent
+}
+
+func (s *recordingSink) send(ev liveEvent) error {
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *recordingSink) ping() error {
+	return nil
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	s := newTestServer(defaultTrees, func(cfg *config) { cfg.Stream.Buffer = 1 })
+
+	feed := s.startFeed(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tree/stream", nil), "")
+	defer feed.close()
+	// the feed is not read while the changes are published
+	for _, id := range []string{"rowan", "hazel", "alder"} {
+		s.trees.Create(Tree{ID: id})
+	}
+	sink := &recordingSink{}
+	if err := feed.run(httptest.NewRequest(http.MethodGet, "/", nil).Context(), sink); err != errStreamBehind {
+		t.Fatalf("expected the feed to fall behind, got %v", err)
+	}
+	// the initial favourite and the buffered change were sent
+	if len(sink.events) != 2 || sink.events[1].Name != treeCreated {
+		t.Errorf("expected the favourite and one change, got %+v", sink.events)
+	}
+
+	feed = s.startFeed(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tree/stream", nil), "")
+	defer feed.close()
+	s.startDraining()
+	if err := feed.run(httptest.NewRequest(http.MethodGet, "/", nil).Context(), sink); err != errShuttingDown {
+		t.Errorf("expected the feed to end on shutdown, got %v", err)
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	s, srv := startStreamServer()
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/tree/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{userHeader: {"kim"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() string {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(msg))
+	}
+	if msg := read(); msg != `{"id":"0","event":"favourite","data":{"myFavouriteTree":"Sequoia"}}` {
+		t.Errorf("unexpected first message %s", msg)
+	}
+	s.trees.SetFavourite("kim", "baobab")
+	if msg := read(); !strings.Contains(msg, `"event":"favourite"`) || !strings.Contains(msg, `"id":"1"`) {
+		t.Errorf("expected the new favourite, got %s", msg)
+	}
+	conn.Close()
+
+	s.trees.Delete("ginkgo")
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?lastEventId=1", http.Header{userHeader: {"kim"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if msg := read(); msg != `{"id":"2","event":"deleted","data":{"id":"ginkgo"}}` {
+		t.Errorf("expected the missed change, got %s", msg)
+	}
+
+	// pages of other origins need to be allowed by the CORS policy
+	_, res, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://example.com"}})
+	if err == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the handshake from another origin to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of the tls.clientAuth setting
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional"
+	clientAuthRequired = "required"
+)
+
+// tlsVersions maps the values of the tls.minVersion setting to versions
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuites are the TLS 1.2 cipher suites that may be enabled with the
+// tls.cipherSuites setting, in order of preference. All of them provide
+// forward secrecy and authenticated encryption. TLS 1.3 suites are not
+// configurable.
+var cipherSuites = []struct {
+	name string
+	id   uint16
+}{
+	{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305", tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305},
+	{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305", tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305},
+	{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+}
+
+// parseCipherSuites parses a comma separated list of cipher suite names. An
+// empty list selects all suites of cipherSuites.
+func parseCipherSuites(names string) ([]uint16, error) {
+	var ids []uint16
+	if strings.TrimSpace(names) == "" {
+		for _, cs := range cipherSuites {
+			ids = append(ids, cs.id)
+		}
+		return ids, nil
+	}
+next:
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		for _, cs := range cipherSuites {
+			if cs.name == name {
+				ids = append(ids, cs.id)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("unsupported cipher suite \"%s\"", name)
+	}
+	return ids, nil
+}
+
+// certReloader holds the server certificate and the client CA bundle and
+// reloads them when their files change, so that renewed certificates are
+// picked up without a restart
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the files again if any of them changed since the last load.
+// It reports whether they did. On failure the previous certificates stay in
+// use.
+func (c *certReloader) reload() (bool, error) {
+	files := []string{c.certFile, c.keyFile}
+	if c.caFile != "" {
+		files = append(files, c.caFile)
+	}
+	modTimes := make(map[string]time.Time, len(files))
+	changed := false
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		modTimes[f] = info.ModTime()
+		c.mu.RLock()
+		changed = changed || !info.ModTime().Equal(c.modTimes[f])
+		c.mu.RUnlock()
+	}
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load certificate \"%s\": %s", c.certFile, err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		pem, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return false, fmt.Errorf("read client CA bundle: %s", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("client CA bundle \"%s\" contains no certificates", c.caFile)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert, c.clientCAs, c.modTimes = &cert, pool, modTimes
+	return true, nil
+}
+
+// watch reloads the files every interval until ctx is cancelled
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			changed, err := c.reload()
+			switch {
+			case err != nil:
+				log.Printf("failed to reload the tls certificates, keeping the current ones: %s", err)
+			case changed:
+				log.Printf("reloaded the tls certificates")
+			}
+		}
+	}
+}
+
+func (c *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, c.clientCAs
+}
+
+// newTLSConfig returns the tls configuration of the server. Every handshake
+// uses the certificates currently held by certs.
+func newTLSConfig(cfg tlsConfig, certs *certReloader) (*tls.Config, error) {
+	suites, err := parseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	policy := &tls.Config{
+		MinVersion:               tlsVersions[cfg.MinVersion],
+		CipherSuites:             suites,
+		PreferServerCipherSuites: true,
+		NextProtos:               []string{"h2", "http/1.1"},
+	}
+	switch cfg.ClientAuth {
+	case clientAuthOptional:
+		policy.ClientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequired:
+		policy.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		MinVersion: policy.MinVersion,
+		NextProtos: policy.NextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := certs.current()
+			c := policy.Clone()
+			c.Certificates = []tls.Certificate{*cert}
+			c.ClientCAs = clientCAs
+			return c, nil
+		},
+	}, nil
+}
+
+// listenTLS wraps l so that it terminates tls with the configured
+// certificates, which are reloaded on change until ctx is cancelled
+func listenTLS(ctx context.Context, l net.Listener, cfg tlsConfig) (net.Listener, *tls.Config, error) {
+	caFile := cfg.ClientCAFile
+	if cfg.ClientAuth == clientAuthNone {
+		caFile = ""
+	}
+	certs, err := newCertReloader(cfg.CertFile, cfg.KeyFile, caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsCfg, err := newTLSConfig(cfg, certs)
+	if err != nil {
+		return nil, nil, err
+	}
+	go certs.watch(ctx, time.Duration(cfg.ReloadInterval))
+	return tls.NewListener(l, tlsCfg), tlsCfg, nil
+}
+
+// redirectToHTTPS redirects every request to the same url on the https port
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
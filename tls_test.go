@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for tests and writes them to dir
+type testCA struct {
+	dir    string
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	file   string
+	serial int64
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	ca := &testCA{dir: dir}
+	ca.cert, ca.key = ca.sign(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	ca.file = filepath.Join(dir, name+".pem")
+	writePEM(t, ca.file, "CERTIFICATE", ca.cert.Raw)
+	return ca
+}
+
+// sign signs tmpl with the CA, or self-signs it if the CA has no key yet
+func (ca *testCA) sign(t *testing.T, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl.SerialNumber = big.NewInt(ca.serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := tmpl, key
+	if ca.key != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// issue writes a certificate for name and its key and returns their files
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (string, string) {
+	cert, key := ca.sign(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	})
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(ca.dir, name+".crt"), filepath.Join(ca.dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", cert.Raw)
+	writePEM(t, keyFile, "EC PRIVATE KEY", der)
+	return certFile, keyFile
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS serves the api with the given tls settings and returns its url
+func serveTLS(t *testing.T, cfg tlsConfig) (string, *certReloader, func()) {
+	caFile := cfg.ClientCAFile
+	if cfg.ClientAuth == clientAuthNone {
+		caFile = ""
+	}
+	certs, err := newCertReloader(cfg.CertFile, cfg.KeyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCfg, err := newTLSConfig(cfg, certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newTestServer(defaultTrees).routes(), TLSConfig: tlsCfg}
+	go srv.Serve(tls.NewListener(l, tlsCfg))
+	return "https://" + l.Addr().String(), certs, func() { srv.Close() }
+}
+
+func tlsClient(roots *x509.CertPool, certs ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+		ForceAttemptHTTP2: true,
+	}}
+}
+
+func defaultTLSConfig(certFile, keyFile string) tlsConfig {
+	cfg := defaultConfig().TLS
+	cfg.CertFile, cfg.KeyFile = certFile, keyFile
+	return cfg
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+
+	url, certs, stop := serveTLS(t, defaultTLSConfig(certFile, keyFile))
+	defer stop()
+	client := tlsClient(ca.pool())
+
+	res, err := client.Get(url + "/tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.ProtoMajor != 2 {
+		t.Fatalf("expected 200 over HTTP/2, got %d over %s", res.StatusCode, res.Proto)
+	}
+	if cn := res.TLS.PeerCertificates[0].Subject.CommonName; cn != "server" {
+		t.Fatalf("expected the server certificate, got %s", cn)
+	}
+
+	// a renewed certificate is picked up without a restart
+	certFile, keyFile = ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if changed, err := certs.reload(); err != nil || !changed {
+		t.Fatalf("expected the certificate to be reloaded, got %v, %v", changed, err)
+	}
+	if changed, _ := certs.reload(); changed {
+		t.Fatalf("expected unchanged files not to be reloaded")
+	}
+	client = tlsClient(ca.pool())
+	res, err = client.Get(url + "/tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != ca.serial {
+		t.Fatalf("expected the renewed certificate %d, got %d", ca.serial, serial)
+	}
+
+	// a broken certificate is not picked up
+	ioutil.WriteFile(certFile, []byte("garbage"), 0600)
+	os.Chtimes(certFile, future.Add(time.Minute), future.Add(time.Minute))
+	if _, err := certs.reload(); err == nil {
+		t.Fatal("expected reloading a broken certificate to fail")
+	}
+	if _, err := tlsClient(ca.pool()).Get(url + "/tree"); err != nil {
+		t.Fatalf("expected the previous certificate to stay in use: %s", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+	other := newTestCA(t, dir, "other-ca")
+	certFile, keyFile := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+
+	cfg := defaultTLSConfig(certFile, keyFile)
+	cfg.ClientCAFile = ca.file
+	cfg.ClientAuth = clientAuthRequired
+	url, _, stop := serveTLS(t, cfg)
+	defer stop()
+
+	load := func(ca *testCA, name string) tls.Certificate {
+		cert, err := tls.LoadX509KeyPair(ca.issue(t, name, x509.ExtKeyUsageClientAuth))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	if _, err := tlsClient(ca.pool()).Get(url + "/tree"); err == nil {
+		t.Fatal("expected a client without certificate to be rejected")
+	}
+	if _, err := tlsClient(ca.pool(), load(other, "mallory")).Get(url + "/tree"); err == nil {
+		t.Fatal("expected a client certificate of another CA to be rejected")
+	}
+	res, err := tlsClient(ca.pool(), load(ca, "alice")).Get(url + "/tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+}
+
+func TestTLSVersionPolicy(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+	cfg := defaultTLSConfig(ca.issue(t, "server", x509.ExtKeyUsageServerAuth))
+	cfg.MinVersion = "1.3"
+	url, _, stop := serveTLS(t, cfg)
+	defer stop()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    ca.pool(),
+		MaxVersion: tls.VersionTLS12,
+	}}}
+	if _, err := client.Get(url + "/tree"); err == nil {
+		t.Fatal("expected a TLS 1.2 client to be rejected")
+	}
+	res, err := tlsClient(ca.pool()).Get(url + "/tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.TLS.Version != tls.VersionTLS13 {
+		t.Fatalf("expected TLS 1.3, got %x", res.TLS.Version)
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := parseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305")
+	if err != nil || len(ids) != 2 || ids[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("unexpected result %v, %v", ids, err)
+	}
+	if _, err := parseCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Fatal("expected an insecure cipher suite to be rejected")
+	}
+	if ids, _ := parseCipherSuites(""); len(ids) != len(cipherSuites) {
+		t.Fatalf("expected all cipher suites by default, got %v", ids)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, tc := range []struct {
+		host     string
+		port     int
+		location string
+	}{
+		{"trees.example.com:8080", 8443, "https://trees.example.com:8443/trees?limit=2"},
+		{"trees.example.com", 443, "https://trees.example.com/trees?limit=2"},
+		{"[::1]:80", 8443, "https://[::1]:8443/trees?limit=2"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tc.host+"/trees?limit=2", nil)
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(tc.port).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tc.location {
+			t.Errorf("%s: expected 308 to %s, got %d %s", tc.host, tc.location, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestListenTLS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "ca")
+	cfg := defaultTLSConfig(ca.issue(t, "server", x509.ExtKeyUsageServerAuth))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tl, tlsCfg, err := listenTLS(ctx, l, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	if len(tlsCfg.NextProtos) == 0 || tlsCfg.GetConfigForClient == nil {
+		t.Fatalf("unexpected tls config %+v", tlsCfg)
+	}
+
+	cfg.CertFile = filepath.Join(dir, "missing.crt")
+	if _, _, err := listenTLS(ctx, l, cfg); err == nil {
+		t.Fatal("expected a missing certificate to fail")
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of the W3C trace context specification
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// Span kinds as defined by OpenTelemetry
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// Span status codes as defined by OpenTelemetry
+const (
+	spanStatusUnset = 0
+	spanStatusError = 2
+)
+
+const sampledFlag = 0x01
+
+// spanContext identifies a span and is propagated to other services
+type spanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string
+}
+
+func (sc spanContext) sampled() bool {
+	return sc.Flags&sampledFlag != 0
+}
+
+func (sc spanContext) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x",
+		hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), sc.Flags)
+}
+
+// parseTraceparent parses a traceparent header. Future versions are accepted
+// as long as they start with the fields of version 00.
+func parseTraceparent(h string) (spanContext, error) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("traceparent \"%s\" has too few fields", h)
+	}
+	version, err := decodeHex(parts[0], 1)
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, fmt.Errorf("traceparent \"%s\" has an invalid version", h)
+	}
+	traceID, err := decodeHex(parts[1], 16)
+	if err != nil || isZero(traceID) {
+		return sc, fmt.Errorf("traceparent \"%s\" has an invalid trace id", h)
+	}
+	spanID, err := decodeHex(parts[2], 8)
+	if err != nil || isZero(spanID) {
+		return sc, fmt.Errorf("traceparent \"%s\" has an invalid parent id", h)
+	}
+	flags, err := decodeHex(parts[3], 1)
+	if err != nil {
+		return sc, fmt.Errorf("traceparent \"%s\" has invalid flags", h)
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	return sc, nil
+}
+
+func decodeHex(s string, length int) ([]byte, error) {
+	if len(s) != 2*length || strings.ToLower(s) != s {
+		return nil, fmt.Errorf("expected %d lower case hex digits", 2*length)
+	}
+	return hex.DecodeString(s)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// span is a single timed operation of a trace
+type span struct {
+	tracer     *tracer
+	context    spanContext
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	mu         sync.Mutex
+	attributes map[string]interface{}
+	status     int
+	message    string
+}
+
+// setAttribute records a string, bool, int or float64 attribute
+func (s *span) setAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// setError marks the span as failed
+func (s *span) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = spanStatusError
+	s.message = err.Error()
+}
+
+// finish ends the span and hands it to the exporter if it is sampled
+func (s *span) finish() {
+	s.mu.Lock()
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.context.sampled() && s.tracer.exporter != nil {
+		s.tracer.exporter.export(s)
+	}
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the current span or nil
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// tracer creates spans and hands finished spans to its exporter. Without an
+// exporter trace contexts are still propagated but no spans are recorded.
+type tracer struct {
+	exporter    spanExporter
+	sampleRatio float64
+}
+
+func newTracer(exporter spanExporter, sampleRatio float64) *tracer {
+	return &tracer{exporter: exporter, sampleRatio: sampleRatio}
+}
+
+// start begins a span as child of the span in ctx, or of remote if ctx has
+// no span, or as the root of a new trace
+func (t *tracer) start(ctx context.Context, name string, kind int, remote *spanContext) (context.Context, *span) {
+	s := &span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: map[string]interface{}{},
+	}
+	parent := remote
+	if p := spanFromContext(ctx); p != nil {
+		parent = &p.context
+	}
+	if parent != nil {
+		s.context = *parent
+		s.parentID = parent.SpanID
+	} else {
+		randomBytes(s.context.TraceID[:])
+		if t.sample() {
+			s.context.Flags = sampledFlag
+		}
+	}
+	randomBytes(s.context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *tracer) sample() bool {
+	if t.exporter == nil || t.sampleRatio <= 0 {
+		return false
+	}
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<53))
+	return err == nil && float64(n.Int64())/(1<<53) < t.sampleRatio
+}
+
+// shutdown flushes all pending spans
+func (t *tracer) shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.shutdown(ctx)
+}
+
+func randomBytes(b []byte) {
+	for isZero(b) {
+		if _, err := rand.Read(b); err != nil {
+			log.Printf("failed to generate a random id: %s", err)
+		}
+	}
+}
+
+// injectTraceContext adds the trace context of ctx to the headers of an
+// outgoing request
+func injectTraceContext(ctx context.Context, h http.Header) {
+	s := spanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	h.Set(traceparentHeader, s.context.traceparent())
+	if s.context.State != "" {
+		h.Set(tracestateHeader, s.context.State)
+	}
+}
+
+// trace starts a server span for every request. The trace context of the
+// caller is continued if the request carries a valid traceparent header and
+// returned in the traceparent and tracestate response headers.
+func trace(t *tracer) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var remote *spanContext
+			if h := r.Header.Get(traceparentHeader); h != "" {
+				if sc, err := parseTraceparent(h); err == nil {
+					sc.State = strings.Join(r.Header[http.CanonicalHeaderKey(tracestateHeader)], ",")
+					remote = &sc
+				}
+			}
+
+			r, route := recordRoute(r)
+			ctx, s := t.start(r.Context(), r.Method, spanKindServer, remote)
+			injectTraceContext(ctx, w.Header())
+			sw := newStatusWriter(w)
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			if *route != "" {
+				s.name = r.Method + " " + *route
+				s.setAttribute("http.route", *route)
+			}
+			s.setAttribute("http.method", r.Method)
+			s.setAttribute("http.target", r.URL.RequestURI())
+			s.setAttribute("http.status_code", sw.status)
+			if id := requestID(r.Context()); id != "" {
+				s.setAttribute("http.request_id", id)
+			}
+			if sw.status >= http.StatusInternalServerError {
+				s.setError(fmt.Errorf("%s", http.StatusText(sw.status)))
+			}
+			s.finish()
+		})
+	}
+}
+
+// tracedStore records a span for every call to the wrapped store
+type tracedStore struct {
+	TreeStore
+	ctx    context.Context
+	tracer *tracer
+}
+
+// store returns the tree store to use while serving a request with the
+// given context
+func (s *server) store(ctx context.Context) TreeStore {
+	if spanFromContext(ctx) == nil {
+		return s.trees
+	}
+	return tracedStore{s.trees, ctx, s.tracer}
+}
+
+func (s tracedStore) span(op string) *span {
+	_, sp := s.tracer.start(s.ctx, "store."+op, spanKindInternal, nil)
+	return sp
+}
+
+func endSpan(sp *span, err error) {
+	if err != nil && err != errTreeNotFound && err != errFavouriteNotSet {
+		sp.setError(err)
+	}
+	sp.finish()
+}
+
+func (s tracedStore) List() ([]Tree, error) {
+	sp := s.span("List")
+	trees, err := s.TreeStore.List()
+	sp.setAttribute("store.trees", len(trees))
+	endSpan(sp, err)
+	return trees, err
+}
+
+func (s tracedStore) Get(id string) (Tree, error) {
+	sp := s.span("Get")
+	sp.setAttribute("tree.id", id)
+	t, err := s.TreeStore.Get(id)
+	endSpan(sp, err)
+	return t, err
+}
+
+func (s tracedStore) Create(t Tree) error {
+	sp := s.span("Create")
+	sp.setAttribute("tree.id", t.ID)
+	err := s.TreeStore.Create(t)
+	endSpan(sp, err)
+	return err
+}
+
+func (s tracedStore) Put(t Tree) (bool, error) {
+	sp := s.span("Put")
+	sp.setAttribute("tree.id", t.ID)
+	created, err := s.TreeStore.Put(t)
+	endSpan(sp, err)
+	return created, err
+}
+
+func (s tracedStore) Update(id string, fn func(*Tree) error) (Tree, error) {
+	sp := s.span("Update")
+	sp.setAttribute("tree.id", id)
+	t, err := s.TreeStore.Update(id, fn)
+	if _, invalid := err.(validationError); invalid {
+		endSpan(sp, nil)
+	} else {
+		endSpan(sp, err)
+	}
+	return t, err
+}
+
+func (s tracedStore) Delete(id string) error {
+	sp := s.span("Delete")
+	sp.setAttribute("tree.id", id)
+	err := s.TreeStore.Delete(id)
+	endSpan(sp, err)
+	return err
+}
+
+func (s tracedStore) Favourite(user string) (string, error) {
+	sp := s.span("Favourite")
+	id, err := s.TreeStore.Favourite(user)
+	endSpan(sp, err)
+	return id, err
+}
+
+func (s tracedStore) SetFavourite(user, treeID string) error {
+	sp := s.span("SetFavourite")
+	sp.setAttribute("tree.id", treeID)
+	err := s.TreeStore.SetFavourite(user, treeID)
+	endSpan(sp, err)
+	return err
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Names of the available span exporters
+const (
+	exporterNone   = "none"
+	exporterStdout = "stdout"
+	exporterOTLP   = "otlp"
+)
+
+const (
+	serviceName     = "tree-spotter"
+	otlpQueueSize   = 2048
+	otlpBatchSize   = 512
+	otlpFlushPeriod = 5 * time.Second
+)
+
+// spanExporter sends finished spans to a tracing backend
+type spanExporter interface {
+	// export queues a finished span. It must not block.
+	export(s *span)
+	// shutdown sends all queued spans
+	shutdown(ctx context.Context) error
+}
+
+// newSpanExporter creates the exporter with the given name. It returns nil if
+// tracing is disabled.
+func newSpanExporter(name, endpoint string, stdout io.Writer) spanExporter {
+	switch name {
+	case exporterStdout:
+		return &writerExporter{w: stdout}
+	case exporterOTLP:
+		return newOTLPExporter(endpoint, &http.Client{Timeout: 10 * time.Second})
+	default:
+		return nil
+	}
+}
+
+// writerExporter writes every span as a json line
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *writerExporter) export(s *span) {
+	b, err := json.Marshal(toOTLPSpan(s))
+	if err != nil {
+		log.Printf("failed to encode span: %s", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(b, '\n'))
+}
+
+func (e *writerExporter) shutdown(context.Context) error {
+	return nil
+}
+
+// otlpExporter sends spans in batches to an OTLP/HTTP collector using the
+// json encoding. Spans are dropped when the queue is full or the exporter is
+// shut down. The queue is never closed, as spans of requests outliving the
+// shutdown may still end.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+	queue    chan *span
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newOTLPExporter(endpoint string, client *http.Client) *otlpExporter {
+	e := &otlpExporter{
+		endpoint: endpoint,
+		client:   client,
+		queue:    make(chan *span, otlpQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) export(s *span) {
+	select {
+	case <-e.stop:
+		return
+	default:
+	}
+	select {
+	case e.queue <- s:
+	default:
+		log.Printf("span queue is full, dropping span \"%s\"", s.name)
+	}
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpFlushPeriod)
+	defer ticker.Stop()
+
+	var batch []*span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Printf("failed to export %d spans: %s", len(batch), err)
+		}
+		batch = nil
+	}
+	add := func(s *span) {
+		batch = append(batch, s)
+		if len(batch) >= otlpBatchSize {
+			flush()
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			add(s)
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			// send what was queued before the shutdown
+			for {
+				select {
+				case s := <-e.queue:
+					add(s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) send(spans []*span) error {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: stringPtr(serviceName)}},
+			{Key: "service.version", Value: otlpValue{StringValue: stringPtr(version)}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: serviceName}}},
+	}}}
+	for _, s := range spans {
+		scope := &req.ResourceSpans[0].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, toOTLPSpan(s))
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *otlpExporter) shutdown(ctx context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The following types model the json encoding of the OTLP trace service
+// request, see opentelemetry-proto/opentelemetry/proto/trace/v1/trace.proto
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	TraceState        string          `json:"traceState,omitempty"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    string   `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func toOTLPSpan(s *span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := otlpSpan{
+		TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+		TraceState:        s.context.State,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.status, Message: s.message},
+	}
+	if !isZero(s.parentID[:]) {
+		o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	keys := make([]string, 0, len(s.attributes))
+	for k := range s.attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o.Attributes = append(o.Attributes, otlpAttribute{k, toOTLPValue(s.attributes[k])})
+	}
+	return o
+}
+
+func toOTLPValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		return otlpValue{IntValue: strconv.Itoa(v)}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for h, valid := range map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":      true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00":      true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what": false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":      false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":      false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":      false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01":      false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01":        false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736":                          false,
+	} {
+		sc, err := parseTraceparent(h)
+		if (err == nil) != valid {
+			t.Errorf("%s: expected valid=%t, got %v", h, valid, err)
+		}
+		if valid && !strings.HasPrefix(h, "01") && sc.traceparent() != h {
+			t.Errorf("%s: expected to round trip, got %s", h, sc.traceparent())
+		}
+	}
+}
+
+func TestTraceContinuesCallerTrace(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestServer(defaultTrees)
+	s.tracer = newTracer(&writerExporter{w: &buf}, 0)
+	h := s.handler()
+
+	r := httptest.NewRequest(http.MethodGet, "/trees/sequoia", nil)
+	r.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set(tracestateHeader, "vendor=value")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+
+	sc, err := parseTraceparent(rec.Header().Get(traceparentHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get(tracestateHeader) != "vendor=value" {
+		t.Errorf("expected the trace state to be returned, got %q", rec.Header().Get(tracestateHeader))
+	}
+
+	var spans []otlpSpan
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var sp otlpSpan
+		if err := dec.Decode(&sp); err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, sp)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected a store and a server span, got %+v", spans)
+	}
+	store, server := spans[0], spans[1]
+	if store.Name != "store.Get" || store.Kind != spanKindInternal || server.Name != "GET /trees/{id}" || server.Kind != spanKindServer {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+	for _, sp := range spans {
+		if sp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected span %s to continue the trace, got trace id %s", sp.Name, sp.TraceID)
+		}
+	}
+	if server.ParentSpanID != "00f067aa0ba902b7" || store.ParentSpanID != server.SpanID {
+		t.Errorf("unexpected parents: server %s, store %s", server.ParentSpanID, store.ParentSpanID)
+	}
+	if server.SpanID != strings.Split(sc.traceparent(), "-")[2] {
+		t.Errorf("expected the response to carry the server span")
+	}
+}
+
+func TestTraceRespectsSampling(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestServer(defaultTrees)
+	s.tracer = newTracer(&writerExporter{w: &buf}, 0)
+	h := s.handler()
+
+	r := httptest.NewRequest(http.MethodGet, "/trees", nil)
+	r.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/trees", nil))
+	if buf.Len() != 0 {
+		t.Errorf("expected unsampled traces not to be exported, got %s", buf.String())
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		received <- req
+	}))
+	defer collector.Close()
+
+	tr := newTracer(newOTLPExporter(collector.URL+"/v1/traces", collector.Client()), 1)
+	ctx, parent := tr.start(context.Background(), "parent", spanKindServer, nil)
+	_, child := tr.start(ctx, "child", spanKindInternal, nil)
+	child.setAttribute("tree.id", "oak")
+	child.finish()
+	parent.finish()
+	if err := tr.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-received
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+	if *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != serviceName {
+		t.Errorf("expected the service name as resource attribute")
+	}
+	if spans[0].ParentSpanID != spans[1].SpanID || spans[0].TraceID != spans[1].TraceID {
+		t.Errorf("expected child and parent to be linked: %+v", spans)
+	}
+	if *spans[0].Attributes[0].Value.StringValue != "oak" {
+		t.Errorf("unexpected attributes %+v", spans[0].Attributes)
+	}
+
+	// spans of requests outliving the shutdown are dropped
+	_, late := tr.start(context.Background(), "late", spanKindServer, nil)
+	late.finish()
+	if err := tr.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	errTreeExists   = errors.New("tree already exists")
+	errTreeNotFound = errors.New("tree does not exist")
+
+	errFavouriteNotSet = errors.New("no favourite tree set")
+)
+
+// Tree describes a single species in the tree catalogue
+type Tree struct {
+	XMLName        xml.Name `json:"-" yaml:"-" xml:"tree"`
+	ID             string   `json:"id" yaml:"id" xml:"id"`
+	Species        string   `json:"species" yaml:"species" xml:"species"`
+	CommonName     string   `json:"commonName" yaml:"commonName" xml:"commonName"`
+	ScientificName string   `json:"scientificName" yaml:"scientificName" xml:"scientificName"`
+	Family         string   `json:"family" yaml:"family" xml:"family"`
+	NativeRegions  []string `json:"nativeRegions" yaml:"nativeRegions" xml:"nativeRegions>region"`
+	MaxHeight      float64  `json:"maxHeightMetres" yaml:"maxHeightMetres" xml:"maxHeightMetres"`
+	Lifespan       int      `json:"lifespanYears" yaml:"lifespanYears" xml:"lifespanYears"`
+	Description    string   `json:"description,omitempty" yaml:"description,omitempty" xml:"description,omitempty"`
+	// Modified is the time of the last change through the api. It is set
+	// by the server and nil for trees that have not changed since seeding.
+	Modified *time.Time `json:"lastModified,omitempty" yaml:"lastModified,omitempty" xml:"lastModified,omitempty"`
+}
+
+func (t Tree) plainText() string {
+	return fmt.Sprintf("%s: %s (%s)", t.ID, t.Species, t.ScientificName)
+}
+
+// defaultTrees is the catalogue the service starts with
+var defaultTrees = []Tree{
+	{
+		ID:             "sequoia",
+		Species:        "Sequoia",
+		CommonName:     "Coast redwood",
+		ScientificName: "Sequoia sempervirens",
+		Family:         "Cupressaceae",
+		NativeRegions:  []string{"North America"},
+		MaxHeight:      115.9,
+		Lifespan:       2200,
+		Description:    "The tallest tree on earth, growing in the fog belt of the Pacific coast of California and Oregon.",
+	},
+	{
+		ID:             "giant-sequoia",
+		Species:        "Giant sequoia",
+		CommonName:     "Sierra redwood",
+		ScientificName: "Sequoiadendron giganteum",
+		Family:         "Cupressaceae",
+		NativeRegions:  []string{"North America"},
+		MaxHeight:      95,
+		Lifespan:       3200,
+		Description:    "The most massive tree on earth, found in groves on the western slopes of the Sierra Nevada.",
+	},
+	{
+		ID:             "european-beech",
+		Species:        "Beech",
+		CommonName:     "European beech",
+		ScientificName: "Fagus sylvatica",
+		Family:         "Fagaceae",
+		NativeRegions:  []string{"Europe"},
+		MaxHeight:      50,
+		Lifespan:       300,
+		Description:    "A large deciduous tree with smooth grey bark that forms dense forests across central Europe.",
+	},
+	{
+		ID:             "english-oak",
+		Species:        "Oak",
+		CommonName:     "English oak",
+		ScientificName: "Quercus robur",
+		Family:         "Fagaceae",
+		NativeRegions:  []string{"Europe", "Asia"},
+		MaxHeight:      40,
+		Lifespan:       1000,
+		Description:    "A long lived broadleaf tree with lobed leaves and acorns, home to hundreds of insect species.",
+	},
+	{
+		ID:             "baobab",
+		Species:        "Baobab",
+		CommonName:     "African baobab",
+		ScientificName: "Adansonia digitata",
+		Family:         "Malvaceae",
+		NativeRegions:  []string{"Africa"},
+		MaxHeight:      25,
+		Lifespan:       2500,
+		Description:    "A thick trunked tree of the African savanna that stores water in its stem and bears large fruits.",
+	},
+	{
+		ID:             "ginkgo",
+		Species:        "Ginkgo",
+		CommonName:     "Maidenhair tree",
+		ScientificName: "Ginkgo biloba",
+		Family:         "Ginkgoaceae",
+		NativeRegions:  []string{"Asia"},
+		MaxHeight:      50,
+		Lifespan:       3000,
+		Description:    "A living fossil with fan shaped leaves that turn golden yellow in autumn.",
+	},
+}
@@ -0,0 +1,5 @@
+// Package treepb holds the protocol buffer messages and the gRPC service of
+// tree-spotter
+package treepb
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. tree.proto
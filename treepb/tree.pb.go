@@ -0,0 +1,751 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: tree.proto
+
+package treepb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type TreeEvent_Type int32
+
+const (
+	TreeEvent_TYPE_UNSPECIFIED TreeEvent_Type = 0
+	TreeEvent_EXISTING         TreeEvent_Type = 1
+	TreeEvent_CREATED          TreeEvent_Type = 2
+	TreeEvent_UPDATED          TreeEvent_Type = 3
+	TreeEvent_DELETED          TreeEvent_Type = 4
+)
+
+var TreeEvent_Type_name = map[int32]string{
+	0: "TYPE_UNSPECIFIED",
+	1: "EXISTING",
+	2: "CREATED",
+	3: "UPDATED",
+	4: "DELETED",
+}
+
+var TreeEvent_Type_value = map[string]int32{
+	"TYPE_UNSPECIFIED": 0,
+	"EXISTING":         1,
+	"CREATED":          2,
+	"UPDATED":          3,
+	"DELETED":          4,
+}
+
+func (x TreeEvent_Type) String() string {
+	return proto.EnumName(TreeEvent_Type_name, int32(x))
+}
+
+func (TreeEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{6, 0}
+}
+
+type Tree struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Species              string   `protobuf:"bytes,2,opt,name=species,proto3" json:"species,omitempty"`
+	CommonName           string   `protobuf:"bytes,3,opt,name=common_name,json=commonName,proto3" json:"common_name,omitempty"`
+	ScientificName       string   `protobuf:"bytes,4,opt,name=scientific_name,json=scientificName,proto3" json:"scientific_name,omitempty"`
+	Family               string   `protobuf:"bytes,5,opt,name=family,proto3" json:"family,omitempty"`
+	NativeRegions        []string `protobuf:"bytes,6,rep,name=native_regions,json=nativeRegions,proto3" json:"native_regions,omitempty"`
+	MaxHeightMetres      float64  `protobuf:"fixed64,7,opt,name=max_height_metres,json=maxHeightMetres,proto3" json:"max_height_metres,omitempty"`
+	LifespanYears        int32    `protobuf:"varint,8,opt,name=lifespan_years,json=lifespanYears,proto3" json:"lifespan_years,omitempty"`
+	Description          string   `protobuf:"bytes,9,opt,name=description,proto3" json:"description,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Tree) Reset()         { *m = Tree{} }
+func (m *Tree) String() string { return proto.CompactTextString(m) }
+func (*Tree) ProtoMessage()    {}
+func (*Tree) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{0}
+}
+
+func (m *Tree) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Tree.Unmarshal(m, b)
+}
+func (m *Tree) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Tree.Marshal(b, m, deterministic)
+}
+func (m *Tree) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Tree.Merge(m, src)
+}
+func (m *Tree) XXX_Size() int {
+	return xxx_messageInfo_Tree.Size(m)
+}
+func (m *Tree) XXX_DiscardUnknown() {
+	xxx_messageInfo_Tree.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Tree proto.InternalMessageInfo
+
+func (m *Tree) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Tree) GetSpecies() string {
+	if m != nil {
+		return m.Species
+	}
+	return ""
+}
+
+func (m *Tree) GetCommonName() string {
+	if m != nil {
+		return m.CommonName
+	}
+	return ""
+}
+
+func (m *Tree) GetScientificName() string {
+	if m != nil {
+		return m.ScientificName
+	}
+	return ""
+}
+
+func (m *Tree) GetFamily() string {
+	if m != nil {
+		return m.Family
+	}
+	return ""
+}
+
+func (m *Tree) GetNativeRegions() []string {
+	if m != nil {
+		return m.NativeRegions
+	}
+	return nil
+}
+
+func (m *Tree) GetMaxHeightMetres() float64 {
+	if m != nil {
+		return m.MaxHeightMetres
+	}
+	return 0
+}
+
+func (m *Tree) GetLifespanYears() int32 {
+	if m != nil {
+		return m.LifespanYears
+	}
+	return 0
+}
+
+func (m *Tree) GetDescription() string {
+	if m != nil {
+		return m.Description
+	}
+	return ""
+}
+
+type GetFavouriteTreeRequest struct {
+	// user_id is ignored if the call carries credentials, the subject of the
+	// credentials is the user then. Empty for anonymous users.
+	UserId               string   `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetFavouriteTreeRequest) Reset()         { *m = GetFavouriteTreeRequest{} }
+func (m *GetFavouriteTreeRequest) String() string { return proto.CompactTextString(m) }
+func (*GetFavouriteTreeRequest) ProtoMessage()    {}
+func (*GetFavouriteTreeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{1}
+}
+
+func (m *GetFavouriteTreeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetFavouriteTreeRequest.Unmarshal(m, b)
+}
+func (m *GetFavouriteTreeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetFavouriteTreeRequest.Marshal(b, m, deterministic)
+}
+func (m *GetFavouriteTreeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetFavouriteTreeRequest.Merge(m, src)
+}
+func (m *GetFavouriteTreeRequest) XXX_Size() int {
+	return xxx_messageInfo_GetFavouriteTreeRequest.Size(m)
+}
+func (m *GetFavouriteTreeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetFavouriteTreeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetFavouriteTreeRequest proto.InternalMessageInfo
+
+func (m *GetFavouriteTreeRequest) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+type ListTreesRequest struct {
+	// page_size defaults to 20 and is at most 100
+	PageSize int32 `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// page_token is the next_page_token of the previous page
+	PageToken string `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	// order_by is a comma separated list of fields, prefixed with - for
+	// descending order, e.g. "-maxHeightMetres,id"
+	OrderBy              string   `protobuf:"bytes,3,opt,name=order_by,json=orderBy,proto3" json:"order_by,omitempty"`
+	Family               string   `protobuf:"bytes,4,opt,name=family,proto3" json:"family,omitempty"`
+	Region               string   `protobuf:"bytes,5,opt,name=region,proto3" json:"region,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListTreesRequest) Reset()         { *m = ListTreesRequest{} }
+func (m *ListTreesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListTreesRequest) ProtoMessage()    {}
+func (*ListTreesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{2}
+}
+
+func (m *ListTreesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListTreesRequest.Unmarshal(m, b)
+}
+func (m *ListTreesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListTreesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListTreesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListTreesRequest.Merge(m, src)
+}
+func (m *ListTreesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListTreesRequest.Size(m)
+}
+func (m *ListTreesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListTreesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListTreesRequest proto.InternalMessageInfo
+
+func (m *ListTreesRequest) GetPageSize() int32 {
+	if m != nil {
+		return m.PageSize
+	}
+	return 0
+}
+
+func (m *ListTreesRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
+	}
+	return ""
+}
+
+func (m *ListTreesRequest) GetOrderBy() string {
+	if m != nil {
+		return m.OrderBy
+	}
+	return ""
+}
+
+func (m *ListTreesRequest) GetFamily() string {
+	if m != nil {
+		return m.Family
+	}
+	return ""
+}
+
+func (m *ListTreesRequest) GetRegion() string {
+	if m != nil {
+		return m.Region
+	}
+	return ""
+}
+
+type ListTreesResponse struct {
+	Trees []*Tree `protobuf:"bytes,1,rep,name=trees,proto3" json:"trees,omitempty"`
+	// next_page_token is empty on the last page
+	NextPageToken        string   `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListTreesResponse) Reset()         { *m = ListTreesResponse{} }
+func (m *ListTreesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListTreesResponse) ProtoMessage()    {}
+func (*ListTreesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{3}
+}
+
+func (m *ListTreesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListTreesResponse.Unmarshal(m, b)
+}
+func (m *ListTreesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListTreesResponse.Marshal(b, m, deterministic)
+}
+func (m *ListTreesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListTreesResponse.Merge(m, src)
+}
+func (m *ListTreesResponse) XXX_Size() int {
+	return xxx_messageInfo_ListTreesResponse.Size(m)
+}
+func (m *ListTreesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListTreesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListTreesResponse proto.InternalMessageInfo
+
+func (m *ListTreesResponse) GetTrees() []*Tree {
+	if m != nil {
+		return m.Trees
+	}
+	return nil
+}
+
+func (m *ListTreesResponse) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
+	}
+	return ""
+}
+
+type GetTreeRequest struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetTreeRequest) Reset()         { *m = GetTreeRequest{} }
+func (m *GetTreeRequest) String() string { return proto.CompactTextString(m) }
+func (*GetTreeRequest) ProtoMessage()    {}
+func (*GetTreeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{4}
+}
+
+func (m *GetTreeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetTreeRequest.Unmarshal(m, b)
+}
+func (m *GetTreeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetTreeRequest.Marshal(b, m, deterministic)
+}
+func (m *GetTreeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetTreeRequest.Merge(m, src)
+}
+func (m *GetTreeRequest) XXX_Size() int {
+	return xxx_messageInfo_GetTreeRequest.Size(m)
+}
+func (m *GetTreeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetTreeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetTreeRequest proto.InternalMessageInfo
+
+func (m *GetTreeRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type WatchTreesRequest struct {
+	// include_existing sends an EXISTING event for every tree of the catalogue
+	// before the first change
+	IncludeExisting      bool     `protobuf:"varint,1,opt,name=include_existing,json=includeExisting,proto3" json:"include_existing,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchTreesRequest) Reset()         { *m = WatchTreesRequest{} }
+func (m *WatchTreesRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchTreesRequest) ProtoMessage()    {}
+func (*WatchTreesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{5}
+}
+
+func (m *WatchTreesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchTreesRequest.Unmarshal(m, b)
+}
+func (m *WatchTreesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchTreesRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchTreesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchTreesRequest.Merge(m, src)
+}
+func (m *WatchTreesRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchTreesRequest.Size(m)
+}
+func (m *WatchTreesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchTreesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchTreesRequest proto.InternalMessageInfo
+
+func (m *WatchTreesRequest) GetIncludeExisting() bool {
+	if m != nil {
+		return m.IncludeExisting
+	}
+	return false
+}
+
+type TreeEvent struct {
+	Type TreeEvent_Type `protobuf:"varint,1,opt,name=type,proto3,enum=treespotter.v1.TreeEvent_Type" json:"type,omitempty"`
+	// tree only holds the id of deleted trees
+	Tree *Tree `protobuf:"bytes,2,opt,name=tree,proto3" json:"tree,omitempty"`
+	// id increases with every change to the catalogue
+	Id                   uint64   `protobuf:"varint,3,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TreeEvent) Reset()         { *m = TreeEvent{} }
+func (m *TreeEvent) String() string { return proto.CompactTextString(m) }
+func (*TreeEvent) ProtoMessage()    {}
+func (*TreeEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_cb3889276909882a, []int{6}
+}
+
+func (m *TreeEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TreeEvent.Unmarshal(m, b)
+}
+func (m *TreeEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TreeEvent.Marshal(b, m, deterministic)
+}
+func (m *TreeEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TreeEvent.Merge(m, src)
+}
+func (m *TreeEvent) XXX_Size() int {
+	return xxx_messageInfo_TreeEvent.Size(m)
+}
+func (m *TreeEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_TreeEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TreeEvent proto.InternalMessageInfo
+
+func (m *TreeEvent) GetType() TreeEvent_Type {
+	if m != nil {
+		return m.Type
+	}
+	return TreeEvent_TYPE_UNSPECIFIED
+}
+
+func (m *TreeEvent) GetTree() *Tree {
+	if m != nil {
+		return m.Tree
+	}
+	return nil
+}
+
+func (m *TreeEvent) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("treespotter.v1.TreeEvent_Type", TreeEvent_Type_name, TreeEvent_Type_value)
+	proto.RegisterType((*Tree)(nil), "treespotter.v1.Tree")
+	proto.RegisterType((*GetFavouriteTreeRequest)(nil), "treespotter.v1.GetFavouriteTreeRequest")
+	proto.RegisterType((*ListTreesRequest)(nil), "treespotter.v1.ListTreesRequest")
+	proto.RegisterType((*ListTreesResponse)(nil), "treespotter.v1.ListTreesResponse")
+	proto.RegisterType((*GetTreeRequest)(nil), "treespotter.v1.GetTreeRequest")
+	proto.RegisterType((*WatchTreesRequest)(nil), "treespotter.v1.WatchTreesRequest")
+	proto.RegisterType((*TreeEvent)(nil), "treespotter.v1.TreeEvent")
+}
+
+func init() {
+	proto.RegisterFile("tree.proto", fileDescriptor_cb3889276909882a)
+}
+
+var fileDescriptor_cb3889276909882a = []byte{
+	// 684 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x54, 0xdd, 0x6e, 0xda, 0x4a,
+	0x18, 0x3c, 0x06, 0xf3, 0xf7, 0x71, 0x02, 0x64, 0x15, 0x25, 0x4e, 0x8e, 0x4e, 0xea, 0x22, 0xb5,
+	0xa1, 0xb9, 0x20, 0x29, 0xbd, 0xac, 0xd4, 0xaa, 0x09, 0x4e, 0x8a, 0x94, 0x46, 0xc4, 0x10, 0xb5,
+	0xe9, 0x8d, 0x65, 0xcc, 0x07, 0xac, 0x82, 0xbd, 0xae, 0x77, 0x41, 0x90, 0x37, 0xe9, 0x4b, 0xf4,
+	0x1d, 0x7a, 0xdf, 0x87, 0xaa, 0x76, 0x6d, 0x12, 0x42, 0x92, 0xf6, 0x0a, 0x66, 0x76, 0xbc, 0x9e,
+	0x6f, 0xbe, 0x91, 0x01, 0x44, 0x84, 0x58, 0x0f, 0x23, 0x26, 0x18, 0x29, 0xc9, 0xff, 0x3c, 0x64,
+	0x42, 0x60, 0x54, 0x9f, 0xbe, 0xae, 0xfe, 0x48, 0x81, 0xde, 0x8d, 0x10, 0x49, 0x09, 0x52, 0xb4,
+	0x6f, 0x68, 0xa6, 0x56, 0x2b, 0xd8, 0x29, 0xda, 0x27, 0x06, 0xe4, 0x78, 0x88, 0x1e, 0x45, 0x6e,
+	0xa4, 0x14, 0xb9, 0x80, 0xe4, 0x19, 0x14, 0x3d, 0xe6, 0xfb, 0x2c, 0x70, 0x02, 0xd7, 0x47, 0x23,
+	0xad, 0x4e, 0x21, 0xa6, 0xce, 0x5d, 0x1f, 0xc9, 0x1e, 0x94, 0xb9, 0x47, 0x31, 0x10, 0x74, 0x40,
+	0xbd, 0x58, 0xa4, 0x2b, 0x51, 0xe9, 0x8e, 0x56, 0xc2, 0x4d, 0xc8, 0x0e, 0x5c, 0x9f, 0x8e, 0xe7,
+	0x46, 0x46, 0x9d, 0x27, 0x88, 0xbc, 0x80, 0x52, 0xe0, 0x0a, 0x3a, 0x45, 0x27, 0xc2, 0x21, 0x65,
+	0x01, 0x37, 0xb2, 0x66, 0xba, 0x56, 0xb0, 0xd7, 0x62, 0xd6, 0x8e, 0x49, 0xb2, 0x0f, 0xeb, 0xbe,
+	0x3b, 0x73, 0x46, 0x48, 0x87, 0x23, 0xe1, 0xf8, 0x28, 0x22, 0xe4, 0x46, 0xce, 0xd4, 0x6a, 0x9a,
+	0x5d, 0xf6, 0xdd, 0xd9, 0x47, 0xc5, 0x7f, 0x52, 0xb4, 0xbc, 0x72, 0x4c, 0x07, 0xc8, 0x43, 0x37,
+	0x70, 0xe6, 0xe8, 0x46, 0xdc, 0xc8, 0x9b, 0x5a, 0x2d, 0x63, 0xaf, 0x2d, 0xd8, 0x2b, 0x49, 0x12,
+	0x13, 0x8a, 0x7d, 0xe4, 0x5e, 0x44, 0x43, 0x41, 0x59, 0x60, 0x14, 0x94, 0xad, 0x65, 0xaa, 0xda,
+	0x80, 0xad, 0x53, 0x14, 0x27, 0xee, 0x94, 0x4d, 0x22, 0x2a, 0x50, 0x66, 0x67, 0xe3, 0xb7, 0x09,
+	0x72, 0x41, 0xb6, 0x20, 0x37, 0xe1, 0x18, 0x39, 0xb7, 0x39, 0x66, 0x25, 0x6c, 0xf5, 0xab, 0xdf,
+	0x35, 0xa8, 0x9c, 0x51, 0x2e, 0xa4, 0x98, 0x2f, 0xd4, 0xff, 0x41, 0x21, 0x74, 0x87, 0xe8, 0x70,
+	0x7a, 0x83, 0x4a, 0x9f, 0xb1, 0xf3, 0x92, 0xe8, 0xd0, 0x1b, 0x24, 0xff, 0x03, 0xa8, 0x43, 0xc1,
+	0xae, 0x31, 0x48, 0x16, 0xa0, 0xe4, 0x5d, 0x49, 0x90, 0x6d, 0xc8, 0xb3, 0xa8, 0x8f, 0x91, 0xd3,
+	0x9b, 0x27, 0xf9, 0xe7, 0x14, 0x3e, 0x9a, 0x2f, 0x65, 0xaa, 0xdf, 0xcb, 0x74, 0x13, 0xb2, 0x71,
+	0x98, 0x8b, 0xac, 0x63, 0x54, 0x1d, 0xc2, 0xfa, 0x92, 0x35, 0x1e, 0xb2, 0x80, 0x23, 0xd9, 0x87,
+	0x8c, 0xea, 0x89, 0xa1, 0x99, 0xe9, 0x5a, 0xb1, 0xb1, 0x51, 0xbf, 0xdf, 0x9a, 0xba, 0x9a, 0x3a,
+	0x96, 0x90, 0x97, 0x50, 0x0e, 0x70, 0x26, 0x9c, 0x07, 0x7e, 0xd7, 0x24, 0xdd, 0x5e, 0x78, 0xae,
+	0x9a, 0x50, 0x3a, 0x45, 0xb1, 0x9c, 0xd7, 0x4a, 0xe5, 0xaa, 0xef, 0x60, 0xfd, 0xb3, 0x2b, 0xbc,
+	0xd1, 0xbd, 0x98, 0x5e, 0x41, 0x85, 0x06, 0xde, 0x78, 0xd2, 0x47, 0x07, 0x67, 0x94, 0x0b, 0x1a,
+	0x0c, 0xd5, 0x23, 0x79, 0xbb, 0x9c, 0xf0, 0x56, 0x42, 0x57, 0x7f, 0x69, 0x50, 0x90, 0xcf, 0x5a,
+	0x53, 0x0c, 0x04, 0x69, 0x80, 0x2e, 0xe6, 0x61, 0x1c, 0x6d, 0xa9, 0xb1, 0xfb, 0xd8, 0x08, 0x4a,
+	0x58, 0xef, 0xce, 0x43, 0xb4, 0x95, 0x96, 0xd4, 0x40, 0x97, 0x32, 0x35, 0xc0, 0x53, 0x63, 0x2b,
+	0x45, 0xe2, 0x5d, 0x66, 0xaf, 0x2b, 0xef, 0x17, 0xa0, 0xcb, 0x7b, 0xc8, 0x06, 0x54, 0xba, 0x57,
+	0x6d, 0xcb, 0xb9, 0x3c, 0xef, 0xb4, 0xad, 0xe3, 0xd6, 0x49, 0xcb, 0x6a, 0x56, 0xfe, 0x21, 0xff,
+	0x42, 0xde, 0xfa, 0xd2, 0xea, 0x74, 0x5b, 0xe7, 0xa7, 0x15, 0x8d, 0x14, 0x21, 0x77, 0x6c, 0x5b,
+	0x1f, 0xba, 0x56, 0xb3, 0x92, 0x92, 0xe0, 0xb2, 0xdd, 0x54, 0x20, 0x2d, 0x41, 0xd3, 0x3a, 0xb3,
+	0x24, 0xd0, 0x1b, 0x3f, 0x53, 0x50, 0x94, 0x6f, 0xec, 0xc4, 0x06, 0xc8, 0x05, 0x54, 0x56, 0x9b,
+	0x47, 0xf6, 0x56, 0x2d, 0x3e, 0xd1, 0xcd, 0x9d, 0x47, 0x67, 0x21, 0x6d, 0x28, 0xdc, 0x2e, 0x9f,
+	0x98, 0xab, 0x92, 0xd5, 0xca, 0xee, 0x3c, 0xff, 0x83, 0x22, 0x69, 0xce, 0x7b, 0xc8, 0x25, 0x5b,
+	0x26, 0xbb, 0x8f, 0x78, 0xfb, 0xbb, 0xa5, 0x33, 0x80, 0xbb, 0x12, 0x90, 0x07, 0x6f, 0x7c, 0x50,
+	0x90, 0x9d, 0xed, 0x27, 0x37, 0x7b, 0xa8, 0x1d, 0x35, 0xbe, 0x1e, 0x0e, 0xa9, 0x18, 0x4d, 0x7a,
+	0x75, 0x8f, 0xf9, 0x07, 0x83, 0x31, 0xc3, 0xeb, 0x6b, 0x6f, 0x84, 0xc1, 0x01, 0x7a, 0x8c, 0x53,
+	0xd7, 0xa1, 0x81, 0x88, 0xd8, 0x81, 0xbc, 0x20, 0xec, 0xbd, 0x8d, 0x7f, 0x7a, 0x59, 0xf5, 0xa5,
+	0x7c, 0xf3, 0x7b, 0x00, 0x93, 0x63, 0xca, 0xb2, 0x37, 0x05, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// TreeSpotterClient is the client API for TreeSpotter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type TreeSpotterClient interface {
+	// GetFavouriteTree returns the favourite tree of a user, or the default
+	// favourite if the user has not picked one
+	GetFavouriteTree(ctx context.Context, in *GetFavouriteTreeRequest, opts ...grpc.CallOption) (*Tree, error)
+	// ListTrees returns a page of the catalogue
+	ListTrees(ctx context.Context, in *ListTreesRequest, opts ...grpc.CallOption) (*ListTreesResponse, error)
+	// GetTree returns a single tree of the catalogue
+	GetTree(ctx context.Context, in *GetTreeRequest, opts ...grpc.CallOption) (*Tree, error)
+	// WatchTrees streams the changes to the catalogue
+	WatchTrees(ctx context.Context, in *WatchTreesRequest, opts ...grpc.CallOption) (TreeSpotter_WatchTreesClient, error)
+}
+
+type treeSpotterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTreeSpotterClient(cc grpc.ClientConnInterface) TreeSpotterClient {
+	return &treeSpotterClient{cc}
+}
+
+func (c *treeSpotterClient) GetFavouriteTree(ctx context.Context, in *GetFavouriteTreeRequest, opts ...grpc.CallOption) (*Tree, error) {
+	out := new(Tree)
+	err := c.cc.Invoke(ctx, "/treespotter.v1.TreeSpotter/GetFavouriteTree", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeSpotterClient) ListTrees(ctx context.Context, in *ListTreesRequest, opts ...grpc.CallOption) (*ListTreesResponse, error) {
+	out := new(ListTreesResponse)
+	err := c.cc.Invoke(ctx, "/treespotter.v1.TreeSpotter/ListTrees", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeSpotterClient) GetTree(ctx context.Context, in *GetTreeRequest, opts ...grpc.CallOption) (*Tree, error) {
+	out := new(Tree)
+	err := c.cc.Invoke(ctx, "/treespotter.v1.TreeSpotter/GetTree", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeSpotterClient) WatchTrees(ctx context.Context, in *WatchTreesRequest, opts ...grpc.CallOption) (TreeSpotter_WatchTreesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_TreeSpotter_serviceDesc.Streams[0], "/treespotter.v1.TreeSpotter/WatchTrees", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &treeSpotterWatchTreesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TreeSpotter_WatchTreesClient interface {
+	Recv() (*TreeEvent, error)
+	grpc.ClientStream
+}
+
+type treeSpotterWatchTreesClient struct {
+	grpc.ClientStream
+}
+
+func (x *treeSpotterWatchTreesClient) Recv() (*TreeEvent, error) {
+	m := new(TreeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TreeSpotterServer is the server API for TreeSpotter service.
+type TreeSpotterServer interface {
+	// GetFavouriteTree returns the favourite tree of a user, or the default
+	// favourite if the user has not picked one
+	GetFavouriteTree(context.Context, *GetFavouriteTreeRequest) (*Tree, error)
+	// ListTrees returns a page of the catalogue
+	ListTrees(context.Context, *ListTreesRequest) (*ListTreesResponse, error)
+	// GetTree returns a single tree of the catalogue
+	GetTree(context.Context, *GetTreeRequest) (*Tree, error)
+	// WatchTrees streams the changes to the catalogue
+	WatchTrees(*WatchTreesRequest, TreeSpotter_WatchTreesServer) error
+}
+
+// UnimplementedTreeSpotterServer can be embedded to have forward compatible implementations.
+type UnimplementedTreeSpotterServer struct {
+}
+
+func (*UnimplementedTreeSpotterServer) GetFavouriteTree(ctx context.Context, req *GetFavouriteTreeRequest) (*Tree, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFavouriteTree not implemented")
+}
+func (*UnimplementedTreeSpotterServer) ListTrees(ctx context.Context, req *ListTreesRequest) (*ListTreesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTrees not implemented")
+}
+func (*UnimplementedTreeSpotterServer) GetTree(ctx context.Context, req *GetTreeRequest) (*Tree, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTree not implemented")
+}
+func (*UnimplementedTreeSpotterServer) WatchTrees(req *WatchTreesRequest, srv TreeSpotter_WatchTreesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTrees not implemented")
+}
+
+func RegisterTreeSpotterServer(s *grpc.Server, srv TreeSpotterServer) {
+	s.RegisterService(&_TreeSpotter_serviceDesc, srv)
+}
+
+func _TreeSpotter_GetFavouriteTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFavouriteTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeSpotterServer).GetFavouriteTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/treespotter.v1.TreeSpotter/GetFavouriteTree",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeSpotterServer).GetFavouriteTree(ctx, req.(*GetFavouriteTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TreeSpotter_ListTrees_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTreesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeSpotterServer).ListTrees(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/treespotter.v1.TreeSpotter/ListTrees",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeSpotterServer).ListTrees(ctx, req.(*ListTreesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TreeSpotter_GetTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeSpotterServer).GetTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/treespotter.v1.TreeSpotter/GetTree",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeSpotterServer).GetTree(ctx, req.(*GetTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TreeSpotter_WatchTrees_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTreesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TreeSpotterServer).WatchTrees(m, &treeSpotterWatchTreesServer{stream})
+}
+
+type TreeSpotter_WatchTreesServer interface {
+	Send(*TreeEvent) error
+	grpc.ServerStream
+}
+
+type treeSpotterWatchTreesServer struct {
+	grpc.ServerStream
+}
+
+func (x *treeSpotterWatchTreesServer) Send(m *TreeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _TreeSpotter_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "treespotter.v1.TreeSpotter",
+	HandlerType: (*TreeSpotterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetFavouriteTree",
+			Handler:    _TreeSpotter_GetFavouriteTree_Handler,
+		},
+		{
+			MethodName: "ListTrees",
+			Handler:    _TreeSpotter_ListTrees_Handler,
+		},
+		{
+			MethodName: "GetTree",
+			Handler:    _TreeSpotter_GetTree_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTrees",
+			Handler:       _TreeSpotter_WatchTrees_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tree.proto",
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// userHeader identifies the user of a request that carries no credentials
+const userHeader = "X-User-ID"
+
+var userPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+type userKey struct{}
+
+// favourite is the favourite tree of a single user
+type favourite struct {
+	XMLName xml.Name `json:"-" yaml:"-" xml:"userFavourite"`
+	User    string   `json:"userId" yaml:"userId" xml:"userId"`
+	TreeID  string   `json:"treeId" yaml:"treeId" xml:"treeId"`
+	Tree    *Tree    `json:"tree,omitempty" yaml:"tree,omitempty" xml:"tree,omitempty"`
+}
+
+func (f favourite) plainText() string {
+	return fmt.Sprintf("%s: %s", f.User, f.TreeID)
+}
+
+type favouriteRequest struct {
+	TreeID string `json:"treeId"`
+}
+
+// withUser returns a copy of ctx carrying the authenticated user, e.g. the
+// subject of a verified bearer token
+func withUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// userFromRequest identifies the user a request is made for. An
+// authenticated user takes precedence over the X-User-ID header. It returns
+// an empty string for anonymous requests.
+func userFromRequest(r *http.Request) (string, error) {
+	if user, ok := r.Context().Value(userKey{}).(string); ok && user != "" {
+		return user, nil
+	}
+	user := r.Header.Get(userHeader)
+	if user != "" && !userPattern.MatchString(user) {
+		return "", newMessage("header %s is not a valid user id", userHeader)
+	}
+	return user, nil
+}
+
+// favouriteOf returns the id of the favourite tree of user, falling back to
+// the default favourite for anonymous users and users that have not picked one
+func (s *server) favouriteOf(ctx context.Context, user string) (string, error) {
+	if user == "" {
+		return s.defaultFavourite, nil
+	}
+	id, err := s.store(ctx).Favourite(user)
+	if err == errFavouriteNotSet {
+		return s.defaultFavourite, nil
+	}
+	return id, err
+}
+
+// favouriteTree returns the favourite tree of user. It falls back to the
+// default favourite if the favourite of the user has been deleted from the
+// catalogue. If neither exists the error is errTreeNotFound and the returned
+// tree holds the id of the missing tree.
+func (s *server) favouriteTree(ctx context.Context, user string) (Tree, error) {
+	id, err := s.favouriteOf(ctx, user)
+	if err != nil {
+		return Tree{ID: id}, err
+	}
+	t, err := s.store(ctx).Get(id)
+	if err == errTreeNotFound && id != s.defaultFavourite {
+		id = s.defaultFavourite
+		t, err = s.store(ctx).Get(id)
+	}
+	if err != nil {
+		return Tree{ID: id}, err
+	}
+	return t, nil
+}
+
+// mayChangeFavourite reports whether the caller of ctx may change the
+// favourite of user. Callers may only change their own favourite unless they
+// were granted the favourites:admin scope. Everyone may if authentication is
+// not configured.
+func (s *server) mayChangeFavourite(ctx context.Context, user string) bool {
+	if s.auth == nil {
+		return true
+	}
+	p, _ := ctx.Value(principalKey{}).(principal)
+	return (p.subject != "" && p.subject == user) || p.scopes[scopeFavouritesAdmin]
+}
+
+func writeInvalidFavourite(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	writeError(w, r, http.StatusUnprocessableEntity, codeValidation, newMessage("the favourite is invalid"), errs...)
+}
+
+func (s *server) getUserFavourite(w http.ResponseWriter, r *http.Request) {
+	user := pathParam(r, "id")
+	id, err := s.store(r.Context()).Favourite(user)
+	if err == errFavouriteNotSet {
+		writeNotFound(w, r, "favourite tree of user", user)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err, id)
+		return
+	}
+	f := favourite{User: user, TreeID: id}
+	if t, err := s.store(r.Context()).Get(id); err == nil {
+		f.Tree = &t
+	}
+	setLastModified(w, s.trees.LastModified())
+	respond(w, r, http.StatusOK, f)
+}
+
+func (s *server) putUserFavourite(w http.ResponseWriter, r *http.Request) {
+	user := pathParam(r, "id")
+	if !userPattern.MatchString(user) {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest,
+			newMessage("\"%s\" is not a valid user id", user))
+		return
+	}
+	if !s.mayChangeFavourite(r.Context(), user) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, newMessage("the favourite of another user can not be changed"))
+		return
+	}
+	var req favouriteRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.TreeID == "" {
+		writeInvalidFavourite(w, r, []fieldError{newFieldError("treeId", "is required")})
+		return
+	}
+	err := s.store(r.Context()).SetFavourite(user, req.TreeID)
+	if err == errTreeNotFound {
+		writeInvalidFavourite(w, r, []fieldError{fieldErrorOf("treeId", notFoundMessage("tree", req.TreeID))})
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err, req.TreeID)
+		return
+	}
+	t, err := s.store(r.Context()).Get(req.TreeID)
+	if err != nil {
+		writeStoreError(w, r, err, req.TreeID)
+		return
+	}
+	respond(w, r, http.StatusOK, favourite{User: user, TreeID: req.TreeID, Tree: &t})
+}
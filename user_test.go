@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUserFavourites(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+
+	if rec := record(h, http.MethodGet, "/users/jan/favourite-tree", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected no favourite for jan, got %d", rec.Code)
+	}
+	if rec := record(h, http.MethodPut, "/users/jan/favourite-tree", `{"treeId":"atlantis-palm"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected unknown trees to be rejected, got %d", rec.Code)
+	}
+	if rec := record(h, http.MethodPut, "/users/jan/favourite-tree", `{"treeId":"ginkgo"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the favourite to be set, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := record(h, http.MethodGet, "/users/jan/favourite-tree", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"treeId":"ginkgo"`) {
+		t.Fatalf("unexpected favourite of jan %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for user, expected := range map[string]string{
+		"":    "Sequoia",
+		"jan": "Ginkgo",
+		"kim": "Sequoia",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/tree", nil)
+		if user != "" {
+			r.Header.Set(userHeader, user)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if !strings.Contains(rec.Body.String(), `{"myFavouriteTree":"`+expected+`"}`) {
+			t.Errorf("user %q: expected %s, got %s", user, expected, rec.Body.String())
+		}
+	}
+
+	record(h, http.MethodDelete, "/trees/ginkgo", "")
+	r := httptest.NewRequest(http.MethodGet, "/tree", nil)
+	r.Header.Set(userHeader, "jan")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if !strings.Contains(rec.Body.String(), `{"myFavouriteTree":"Sequoia"}`) {
+		t.Errorf("expected the default once the favourite is deleted, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxNameLength   = 100
+	maxHeightMetres = 200
+	maxLifespan     = 10000
+	maxDescription  = 1000
+)
+
+var idPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// knownRegions are the values accepted in Tree.NativeRegions
+var knownRegions = []string{
+	"Africa",
+	"Antarctica",
+	"Asia",
+	"Europe",
+	"North America",
+	"Oceania",
+	"South America",
+}
+
+// validateTree checks a tree for completeness and plausibility. It returns one
+// fieldError per offending field.
+func validateTree(t Tree) []fieldError {
+	var errs []fieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, newFieldError(field, format, args...))
+	}
+
+	switch {
+	case t.ID == "":
+		add("id", "is required")
+	case len(t.ID) > maxNameLength:
+		add("id", "must not be longer than %d characters", maxNameLength)
+	case !idPattern.MatchString(t.ID):
+		add("id", "must consist of lower case letters and digits separated by single dashes")
+	}
+
+	for _, f := range []struct {
+		name, value string
+		required    bool
+	}{
+		{"species", t.Species, true},
+		{"commonName", t.CommonName, false},
+		{"scientificName", t.ScientificName, true},
+		{"family", t.Family, true},
+	} {
+		switch {
+		case f.required && strings.TrimSpace(f.value) == "":
+			add(f.name, "is required")
+		case len(f.value) > maxNameLength:
+			add(f.name, "must not be longer than %d characters", maxNameLength)
+		}
+	}
+
+	if len(t.Description) > maxDescription {
+		add("description", "must not be longer than %d characters", maxDescription)
+	}
+
+	for i, region := range t.NativeRegions {
+		if !isKnownRegion(region) {
+			add(fmt.Sprintf("nativeRegions[%d]", i),
+				"unknown region \"%s\", must be one of %s",
+				region, strings.Join(knownRegions, ", "))
+		}
+	}
+
+	if t.MaxHeight < 0 || t.MaxHeight > maxHeightMetres {
+		add("maxHeightMetres", "must be between 0 and %d", maxHeightMetres)
+	}
+	if t.Lifespan < 0 || t.Lifespan > maxLifespan {
+		add("lifespanYears", "must be between 0 and %d", maxLifespan)
+	}
+	return errs
+}
+
+func isKnownRegion(region string) bool {
+	for _, r := range knownRegions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers of the webhook deliveries. The signature is the HMAC-SHA256 of the
+// timestamp and the body joined by a dot, keyed with the secret of the
+// webhook.
+const (
+	webhookSignatureHeader = "X-Tree-Spotter-Signature"
+	webhookTimestampHeader = "X-Tree-Spotter-Timestamp"
+	webhookEventHeader     = "X-Tree-Spotter-Event"
+	webhookDeliveryHeader  = "X-Tree-Spotter-Delivery"
+)
+
+const (
+	// minWebhookSecret is the minimum length of the secrets of webhooks
+	minWebhookSecret = 16
+	// deliveryLogSize is the number of recent deliveries kept per webhook
+	deliveryLogSize = 100
+	// webhookQueue is the number of deliveries waiting for a worker before
+	// new changes are held back
+	webhookQueue = 1024
+)
+
+// Status of deliveries
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// webhookEvents are the events webhooks can subscribe to
+var webhookEvents = []string{favouriteChanged, treeCreated, treeUpdated, treeDeleted}
+
+type webhook struct {
+	XMLName xml.Name  `json:"-" yaml:"-" xml:"webhook"`
+	ID      string    `json:"id" yaml:"id" xml:"id"`
+	URL     string    `json:"url" yaml:"url" xml:"url"`
+	Events  []string  `json:"events" yaml:"events" xml:"events>event"`
+	Created time.Time `json:"created" yaml:"created" xml:"created"`
+	// secret signs the deliveries, it is never returned
+	secret string
+}
+
+func (h *webhook) wants(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+type webhookList struct {
+	XMLName  xml.Name  `json:"-" yaml:"-" xml:"webhooks"`
+	Webhooks []webhook `json:"webhooks" yaml:"webhooks" xml:"webhook"`
+}
+
+// delivery is the notification of a webhook about a single change
+type delivery struct {
+	XMLName  xml.Name  `json:"-" yaml:"-" xml:"delivery"`
+	ID       string    `json:"id" yaml:"id" xml:"id"`
+	Webhook  string    `json:"webhookId" yaml:"webhookId" xml:"webhookId"`
+	URL      string    `json:"url" yaml:"url" xml:"url"`
+	Event    string    `json:"event" yaml:"event" xml:"event"`
+	EventID  uint64    `json:"eventId" yaml:"eventId" xml:"eventId"`
+	Status   string    `json:"status" yaml:"status" xml:"status"`
+	Attempts []attempt `json:"attempts" yaml:"attempts" xml:"attempts>attempt"`
+
+	hook    *webhook
+	payload []byte
+}
+
+// attempt is a single request of a delivery. The status code is 0 if no
+// response was received.
+type attempt struct {
+	Time       time.Time `json:"time" yaml:"time" xml:"time"`
+	StatusCode int       `json:"statusCode,omitempty" yaml:"statusCode,omitempty" xml:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty" yaml:"error,omitempty" xml:"error,omitempty"`
+}
+
+type deliveryList struct {
+	XMLName    xml.Name   `json:"-" yaml:"-" xml:"deliveries"`
+	Deliveries []delivery `json:"deliveries" yaml:"deliveries" xml:"delivery"`
+}
+
+// webhookPayload is the body of the deliveries
+type webhookPayload struct {
+	ID    uint64      `json:"id"`
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+func newWebhookPayload(ev treeEvent) webhookPayload {
+	p := webhookPayload{ID: ev.ID, Event: ev.Type, Time: ev.Time}
+	if ev.Type == favouriteChanged {
+		p.Data = favourite{User: ev.User, TreeID: ev.Tree.ID}
+	} else {
+		p.Data = catalogueEvent(ev).Data
+	}
+	return p
+}
+
+// signPayload returns the hex encoded signature of a delivery
+func signPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhooks keeps the webhook subscriptions and delivers the changes to them.
+// Subscriptions and deliveries are kept in memory only.
+type webhooks struct {
+	cfg       webhookConfig
+	client    *http.Client
+	queue     chan *delivery
+	done      chan struct{}
+	closeOnce sync.Once
+	delivered *counterVec
+	// stop tells the dispatcher to deliver the last changes and return,
+	// dispatched is closed once it did
+	stop       chan struct{}
+	dispatched chan struct{}
+	// pending counts the deliveries that are neither delivered nor failed
+	pending sync.WaitGroup
+
+	mu    sync.Mutex
+	hooks map[string]*webhook
+	log   map[string][]*delivery
+	dead  []*delivery
+}
+
+// privateNetworks are the networks webhooks may not deliver to unless
+// private networks are allowed, besides loopback, link-local and
+// unspecified addresses
+var privateNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12",
+		"192.168.0.0/16", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// refusePrivateNetworks is the Control of the dialer of the webhook
+// deliveries. It checks the address a connection is actually made to, after
+// the host of the webhook was resolved, so that neither hosts resolving to
+// internal addresses nor changing dns records can reach internal services.
+func refusePrivateNetworks(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return fmt.Errorf("delivering webhooks to %s is not allowed", host)
+	}
+	return nil
+}
+
+func newWebhooks(cfg webhookConfig, reg *metricsRegistry) *webhooks {
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.Timeout)}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = refusePrivateNetworks
+	}
+	w := &webhooks{
+		cfg: cfg,
+		client: &http.Client{
+			// no proxy is used, it would be the address the dialer checks
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				MaxIdleConnsPerHost: cfg.Workers,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			Timeout: time.Duration(cfg.Timeout),
+			// redirects are failed deliveries, receivers have to be
+			// registered with their final url
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		queue:      make(chan *delivery, webhookQueue),
+		done:       make(chan struct{}),
+		stop:       make(chan struct{}),
+		dispatched: make(chan struct{}),
+		delivered: newCounterVec(metricsNamespace+"webhook_deliveries_total",
+			"Number of finished webhook deliveries by status.", "status"),
+		hooks: map[string]*webhook{},
+		log:   map[string][]*delivery{},
+	}
+	reg.register(w.delivered)
+	return w
+}
+
+// start delivers the changes published by events until the webhooks are
+// closed
+func (w *webhooks) start(events *treeEvents) {
+	go w.dispatch(events, events.lastID())
+	for i := 0; i < w.cfg.Workers; i++ {
+		go w.work()
+	}
+}
+
+func (w *webhooks) close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+// shutdown delivers the changes made until the server stopped serving and
+// waits for the pending deliveries, retries included, until ctx is done. It
+// then closes the webhooks. The events have to be closed before.
+func (w *webhooks) shutdown(ctx context.Context) {
+	close(w.stop)
+	flushed := make(chan struct{})
+	go func() {
+		<-w.dispatched
+		w.pending.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		log.Printf("pending webhook deliveries were dropped on shutdown")
+	}
+	w.close()
+}
+
+// dispatch creates the deliveries of the changes after the event with id
+// last. If it falls behind the changes it resumes after the last change it
+// saw. Once the events are closed it waits for the shutdown of the webhooks,
+// as changes are still made while the server drains, and creates the
+// deliveries of the changes it has not seen.
+func (w *webhooks) dispatch(events *treeEvents, last uint64) {
+	defer close(w.dispatched)
+	for {
+		closed := events.isClosed()
+		missed, newest, complete, c, cancel := events.resume(last, webhookQueue)
+		if !complete {
+			log.Printf("webhooks missed the changes after event %d", last)
+			last = newest
+		}
+		for _, ev := range missed {
+			w.publish(ev)
+			last = ev.ID
+		}
+		for ev := range c {
+			w.publish(ev)
+			last = ev.ID
+		}
+		cancel()
+		if closed {
+			return
+		}
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		if events.isClosed() {
+			select {
+			case <-w.stop:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// publish queues the deliveries of a change to the webhooks subscribed to it
+func (w *webhooks) publish(ev treeEvent) {
+	payload, err := json.Marshal(newWebhookPayload(ev))
+	if err != nil {
+		log.Printf("failed to encode the webhook payload of event %d: %s", ev.ID, err)
+		return
+	}
+	var deliveries []*delivery
+	w.mu.Lock()
+	for _, h := range w.hooks {
+		if !h.wants(ev.Type) {
+			continue
+		}
+		d := &delivery{ID: newRequestID(), Webhook: h.ID, URL: h.URL, Event: ev.Type, EventID: ev.ID,
+			Status: deliveryPending, Attempts: []attempt{}, hook: h, payload: payload}
+		logged := append(w.log[h.ID], d)
+		if len(logged) > deliveryLogSize {
+			logged = logged[len(logged)-deliveryLogSize:]
+		}
+		w.log[h.ID] = logged
+		deliveries = append(deliveries, d)
+	}
+	w.mu.Unlock()
+	w.pending.Add(len(deliveries))
+	for _, d := range deliveries {
+		w.enqueue(d)
+	}
+}
+
+func (w *webhooks) enqueue(d *delivery) {
+	select {
+	case w.queue <- d:
+	case <-w.done:
+		w.pending.Done()
+	}
+}
+
+func (w *webhooks) work() {
+	for {
+		select {
+		case d := <-w.queue:
+			w.deliver(d)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// deliver makes an attempt of d. Failed attempts are retried after a backoff
+// until the maximum number of attempts is reached, then the delivery is
+// moved to the dead letters.
+func (w *webhooks) deliver(d *delivery) {
+	w.mu.Lock()
+	deleted := w.hooks[d.Webhook] != d.hook
+	w.mu.Unlock()
+	if deleted {
+		w.pending.Done()
+		return
+	}
+	start := time.Now().UTC()
+	status, err := w.post(d)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a := attempt{Time: start, StatusCode: status}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	d.Attempts = append(d.Attempts, a)
+	switch {
+	case err == nil:
+		d.Status = deliveryDelivered
+		w.delivered.inc(deliveryDelivered)
+		w.pending.Done()
+	case len(d.Attempts) >= w.cfg.MaxAttempts:
+		d.Status = deliveryFailed
+		w.delivered.inc(deliveryFailed)
+		w.dead = append(w.dead, d)
+		if len(w.dead) > w.cfg.DeadLetters {
+			w.dead = w.dead[len(w.dead)-w.cfg.DeadLetters:]
+		}
+		w.pending.Done()
+	default:
+		time.AfterFunc(w.backoff(len(d.Attempts)), func() {
+			w.enqueue(d)
+		})
+	}
+}
+
+// post sends d to its webhook. Only 2xx responses are successful.
+func (w *webhooks) post(d *delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.hook.URL, bytes.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tree-spotter/"+version)
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, d.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signPayload(d.hook.secret, timestamp, d.payload))
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// reading the body lets the client reuse the connection
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("the receiver answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// backoff returns the delay after the nth failed attempt. It doubles with
+// every attempt up to the maximum backoff. Half of it is random so that the
+// retries of many deliveries do not hit a receiver at the same time.
+func (w *webhooks) backoff(n int) time.Duration {
+	d, max := time.Duration(w.cfg.Backoff), time.Duration(w.cfg.MaxBackoff)
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (w *webhooks) add(req webhookRequest) webhook {
+	h := &webhook{
+		ID:      newRequestID(),
+		URL:     req.URL,
+		Created: time.Now().UTC(),
+		secret:  req.Secret,
+	}
+	seen := map[string]bool{}
+	for _, e := range req.Events {
+		if !seen[e] {
+			seen[e] = true
+			h.Events = append(h.Events, e)
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hooks[h.ID] = h
+	return *h
+}
+
+// list returns the webhooks in the order they were registered
+func (w *webhooks) list() []webhook {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := []webhook{}
+	for _, h := range w.hooks {
+		list = append(list, *h)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created.Equal(list[j].Created) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+func (w *webhooks) get(id string) (webhook, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	h, ok := w.hooks[id]
+	if !ok {
+		return webhook{}, false
+	}
+	return *h, true
+}
+
+// remove deletes a webhook and its delivery log. Its pending deliveries are
+// dropped.
+func (w *webhooks) remove(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.hooks[id]; !ok {
+		return false
+	}
+	delete(w.hooks, id)
+	delete(w.log, id)
+	return true
+}
+
+// deliveries returns the recent deliveries of a webhook, latest first
+func (w *webhooks) deliveries(id string) ([]delivery, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.hooks[id]; !ok {
+		return nil, false
+	}
+	return snapshot(w.log[id]), true
+}
+
+// deadLetters returns the deliveries that failed every attempt, latest first
+func (w *webhooks) deadLetters() []delivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return snapshot(w.dead)
+}
+
+// snapshot copies deliveries in reverse order. The caller must hold the lock.
+func snapshot(deliveries []*delivery) []delivery {
+	list := make([]delivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		d := *deliveries[i]
+		d.Attempts = append([]attempt{}, d.Attempts...)
+		list = append(list, d)
+	}
+	return list
+}
+
+func validateWebhook(req webhookRequest) []fieldError {
+	var errs []fieldError
+	if req.URL == "" {
+		errs = append(errs, newFieldError("url", "is required"))
+	} else if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, newFieldError("url", "must be an absolute http or https url"))
+	}
+	if len(req.Events) == 0 {
+		errs = append(errs, newFieldError("events", "is required"))
+	}
+	for _, e := range req.Events {
+		known := false
+		for _, k := range webhookEvents {
+			known = known || e == k
+		}
+		if !known {
+			errs = append(errs, newFieldError("events", "unknown event \"%s\", must be one of %s",
+				e, strings.Join(webhookEvents, ", ")))
+		}
+	}
+	if req.Secret == "" {
+		errs = append(errs, newFieldError("secret", "is required"))
+	} else if len(req.Secret) < minWebhookSecret {
+		errs = append(errs, newFieldError("secret", "must be at least %d characters long", minWebhookSecret))
+	}
+	return errs
+}
+
+// managed guards the webhook routes with the webhooks:manage scope. Without
+// authentication anyone could register webhooks, so the routes are refused.
+func (s *server) managed(h http.HandlerFunc) http.HandlerFunc {
+	if s.auth == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, r, http.StatusForbidden, codeForbidden, newMessage("webhooks require authentication to be configured"))
+		}
+	}
+	return s.scoped(scopeWebhooksManage, h)
+}
+
+func (s *server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if errs := validateWebhook(req); len(errs) > 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, codeValidation, newMessage("the webhook is invalid"), errs...)
+		return
+	}
+	h := s.webhooks.add(req)
+	w.Header().Set("Location", "/webhooks/"+h.ID)
+	respond(w, r, http.StatusCreated, h)
+}
+
+func (s *server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, webhookList{Webhooks: s.webhooks.list()})
+}
+
+func (s *server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	h, ok := s.webhooks.get(id)
+	if !ok {
+		writeNotFound(w, r, "webhook", id)
+		return
+	}
+	respond(w, r, http.StatusOK, h)
+}
+
+func (s *server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	if !s.webhooks.remove(id) {
+		writeNotFound(w, r, "webhook", id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	deliveries, ok := s.webhooks.deliveries(id)
+	if !ok {
+		writeNotFound(w, r, "webhook", id)
+		return
+	}
+	respond(w, r, http.StatusOK, deliveryList{Deliveries: deliveries})
+}
+
+func (s *server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, deliveryList{Deliveries: s.webhooks.deadLetters()})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef"
+
+// receiver records the webhook deliveries it gets and answers them with the
+// given status codes in turn, repeating the last one
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(statuses ...int) *receiver {
+	rc := &receiver{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rc.mu.Lock()
+		status := rc.statuses[0]
+		if len(rc.statuses) > 1 {
+			rc.statuses = rc.statuses[1:]
+		}
+		rc.requests = append(rc.requests, r)
+		rc.bodies = append(rc.bodies, body)
+		rc.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return rc
+}
+
+func (rc *receiver) received() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+// eventually fails the test if cond does not hold within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+const webhookTestKey = "webhook-key"
+
+// newWebhookTestServer returns a server delivering to the receivers of the
+// tests, which listen on loopback addresses
+func newWebhookTestServer(attempts int) *server {
+	return newTestServer(defaultTrees, func(cfg *config) {
+		cfg.Auth.APIKeys = []apiKeyConfig{{Name: "ops", Hash: hashAPIKey(webhookTestKey),
+			Scopes: "trees:read trees:write favourites:write favourites:admin webhooks:manage"}}
+		cfg.Webhooks.MaxAttempts = attempts
+		cfg.Webhooks.Backoff = duration(time.Millisecond)
+		cfg.Webhooks.MaxBackoff = duration(4 * time.Millisecond)
+		cfg.Webhooks.AllowPrivateNetworks = true
+	})
+}
+
+// webhookHandler serves the api of s to the requests of the tests, which
+// are made with an api key managing webhooks
+func webhookHandler(s *server) http.Handler {
+	h := s.handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(apiKeyHeader, webhookTestKey)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// registerWebhook registers a webhook and returns its id
+func registerWebhook(t *testing.T, h http.Handler, url string, events ...string) string {
+	t.Helper()
+	body, _ := json.Marshal(webhookRequest{URL: url, Events: events, Secret: testSecret})
+	rec := record(h, http.MethodPost, "/webhooks", string(body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var hook map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &hook)
+	if _, ok := hook["secret"]; ok {
+		t.Error("expected the secret not to be returned")
+	}
+	if rec.Header().Get("Location") != "/webhooks/"+hook["id"].(string) {
+		t.Errorf("unexpected Location %s", rec.Header().Get("Location"))
+	}
+	return hook["id"].(string)
+}
+
+func deliveriesOf(t *testing.T, h http.Handler, path string) []delivery {
+	rec := record(h, http.MethodGet, path, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var list deliveryList
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	return list.Deliveries
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	rc := newReceiver(http.StatusNoContent)
+	defer rc.Close()
+	s := newWebhookTestServer(3)
+	h := webhookHandler(s)
+	id := registerWebhook(t, h, rc.URL, favouriteChanged, favouriteChanged)
+
+	if rec := record(h, http.MethodDelete, "/trees/ginkgo", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if rec := record(h, http.MethodPut, "/users/kim/favourite-tree", `{"treeId": "baobab"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	eventually(t, "the delivery", func() bool { return rc.received() == 1 })
+
+	req, body := rc.requests[0], rc.bodies[0]
+	var payload webhookPayload
+	json.Unmarshal(body, &payload)
+	// the change to the catalogue is not subscribed to
+	if payload.ID != 2 || payload.Event != favouriteChanged ||
+		fmt.Sprint(payload.Data) != "map[treeId:baobab userId:kim]" {
+		t.Errorf("unexpected payload %s", body)
+	}
+	timestamp := req.Header.Get(webhookTimestampHeader)
+	if sig := req.Header.Get(webhookSignatureHeader); sig != "sha256="+signPayload(testSecret, timestamp, body) {
+		t.Errorf("unexpected signature %s", sig)
+	}
+	if req.Header.Get(webhookEventHeader) != favouriteChanged || req.Header.Get(webhookDeliveryHeader) == "" {
+		t.Errorf("unexpected headers %v", req.Header)
+	}
+
+	var deliveries []delivery
+	eventually(t, "the delivery log", func() bool {
+		deliveries = deliveriesOf(t, h, "/webhooks/"+id+"/deliveries")
+		return len(deliveries) == 1 && deliveries[0].Status == deliveryDelivered
+	})
+	d := deliveries[0]
+	if d.ID != req.Header.Get(webhookDeliveryHeader) || d.EventID != 2 ||
+		len(d.Attempts) != 1 || d.Attempts[0].StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	rc := newReceiver(http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK)
+	defer rc.Close()
+	s := newWebhookTestServer(3)
+	h := webhookHandler(s)
+	id := registerWebhook(t, h, rc.URL, treeDeleted)
+
+	record(h, http.MethodDelete, "/trees/ginkgo", "")
+	var deliveries []delivery
+	eventually(t, "the retries", func() bool {
+		deliveries = deliveriesOf(t, h, "/webhooks/"+id+"/deliveries")
+		return len(deliveries) == 1 && deliveries[0].Status == deliveryDelivered
+	})
+	var statuses []int
+	for _, a := range deliveries[0].Attempts {
+		statuses = append(statuses, a.StatusCode)
+	}
+	if fmt.Sprint(statuses) != "[500 503 200]" {
+		t.Errorf("unexpected attempts %+v", deliveries[0].Attempts)
+	}
+	if string(rc.bodies[0]) != string(rc.bodies[2]) || rc.requests[0].Header.Get(webhookDeliveryHeader) !=
+		rc.requests[2].Header.Get(webhookDeliveryHeader) {
+		t.Error("expected the retries to send the same delivery")
+	}
+	if len(deliveriesOf(t, h, "/webhooks/dead-letters")) != 0 {
+		t.Error("expected no dead letters")
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	rc := newReceiver(http.StatusGone)
+	defer rc.Close()
+	s := newWebhookTestServer(2)
+	h := webhookHandler(s)
+	id := registerWebhook(t, h, rc.URL, treeDeleted)
+	// deliveries to receivers that are down fail without a status code
+	down := registerWebhook(t, h, "http://127.0.0.1:1/hook", treeDeleted)
+
+	record(h, http.MethodDelete, "/trees/ginkgo", "")
+	var dead []delivery
+	eventually(t, "the dead letters", func() bool {
+		dead = deliveriesOf(t, h, "/webhooks/dead-letters")
+		return len(dead) == 2
+	})
+	for _, d := range dead {
+		if d.Status != deliveryFailed || len(d.Attempts) != 2 || d.Attempts[1].Error == "" {
+			t.Errorf("unexpected dead letter %+v", d)
+		}
+		if d.Webhook == id && d.Attempts[1].StatusCode != http.StatusGone {
+			t.Errorf("expected the status of the receiver, got %+v", d.Attempts)
+		}
+		if d.Webhook == down && d.Attempts[1].StatusCode != 0 {
+			t.Errorf("expected no status, got %+v", d.Attempts)
+		}
+	}
+	if rc.received() != 2 {
+		t.Errorf("expected 2 attempts, got %d", rc.received())
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	w := newWebhooks(webhookConfig{Backoff: duration(time.Second), MaxBackoff: duration(10 * time.Second)},
+		newMetricsRegistry())
+	for n, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second} {
+		if d := w.backoff(n + 1); d < max/2 || d > max {
+			t.Errorf("expected the backoff after attempt %d to be between %s and %s, got %s", n+1, max/2, max, d)
+		}
+	}
+}
+
+func TestWebhookManagement(t *testing.T) {
+	s := newWebhookTestServer(1)
+	h := webhookHandler(s)
+
+	rec := record(h, http.MethodPost, "/webhooks",
+		`{"url": "ftp://example.com", "events": ["favourite", "planted"], "secret": "short"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	for _, field := range []string{`"field":"url"`, `"field":"events"`, `"field":"secret"`} {
+		if !strings.Contains(rec.Body.String(), field) {
+			t.Errorf("expected an error of %s, got %s", field, rec.Body)
+		}
+	}
+
+	first := registerWebhook(t, h, "https://example.com/trees", treeCreated)
+	second := registerWebhook(t, h, "https://example.com/favourites", favouriteChanged)
+	var list webhookList
+	json.Unmarshal(record(h, http.MethodGet, "/webhooks", "").Body.Bytes(), &list)
+	if len(list.Webhooks) != 2 || list.Webhooks[0].ID != first || list.Webhooks[1].ID != second {
+		t.Errorf("expected the webhooks in the order they were registered, got %+v", list)
+	}
+	if rec := record(h, http.MethodGet, "/webhooks/"+first, ""); !strings.Contains(rec.Body.String(), `"events":["created"]`) {
+		t.Errorf("unexpected webhook %s", rec.Body)
+	}
+
+	if rec := record(h, http.MethodDelete, "/webhooks/"+first, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	for _, path := range []string{"/webhooks/" + first, "/webhooks/" + first + "/deliveries"} {
+		if rec := record(h, http.MethodGet, path, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("expected %s to be gone, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestWebhooksRequireScope(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	h := newAuthTestServer(t, dir)
+
+	if rec := record(h, http.MethodGet, "/webhooks", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected anonymous callers to be refused, got %d", rec.Code)
+	}
+	rec := record(h, http.MethodGet, "/webhooks", "", withHeaders(map[string]string{apiKeyHeader: "secret-ci-key"}))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), scopeWebhooksManage) {
+		t.Errorf("expected the webhooks:manage scope to be required, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWebhooksRequireAuthentication(t *testing.T) {
+	h := newTestServer(defaultTrees).routes()
+	rec := record(h, http.MethodPost, "/webhooks",
+		`{"url": "https://example.com/trees", "events": ["created"], "secret": "0123456789abcdef"}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "authentication") {
+		t.Errorf("expected webhooks to be refused without authentication, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWebhookPrivateNetworks(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "169.254.169.254:80", "10.1.2.3:80",
+		"172.20.0.1:80", "192.168.1.1:80", "[fd00::1]:80", "0.0.0.0:80"} {
+		if refusePrivateNetworks("tcp", address, nil) == nil {
+			t.Errorf("expected %s to be refused", address)
+		}
+	}
+	if err := refusePrivateNetworks("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("expected public addresses to be allowed, got %s", err)
+	}
+
+	rc := newReceiver(http.StatusOK)
+	defer rc.Close()
+	w := newWebhooks(webhookConfig{Timeout: duration(time.Second), Workers: 1}, newMetricsRegistry())
+	d := &delivery{hook: &webhook{URL: rc.URL}, payload: []byte("{}")}
+	if _, err := w.post(d); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("expected the delivery to the loopback receiver to be refused, got %v", err)
+	}
+	if rc.received() != 0 {
+		t.Error("expected the receiver not to be called")
+	}
+}
+
+func TestWebhooksFlushOnShutdown(t *testing.T) {
+	rc := newReceiver(http.StatusInternalServerError, http.StatusNoContent)
+	defer rc.Close()
+	s := newWebhookTestServer(3)
+	h := webhookHandler(s)
+	registerWebhook(t, h, rc.URL, treeDeleted)
+
+	// the server keeps serving while it drains
+	s.startDraining()
+	if rec := record(h, http.MethodDelete, "/trees/ginkgo", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.webhooks.shutdown(ctx)
+	if ctx.Err() != nil || rc.received() != 2 {
+		t.Errorf("expected the change and its retry to be delivered before the shutdown, got %d requests",
+			rc.received())
+	}
+}